@@ -0,0 +1,256 @@
+package amdgpu
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/batch-insights/nvml"
+	"github.com/Azure/batch-insights/pkg/utils"
+)
+
+// PCI vendor ID of AMD
+const amdVendorID = "0x1002"
+
+// Only match the cards, not their connectors(e.g. card0-DP-1)
+var cardPattern = regexp.MustCompile(`^card[0-9]+$`)
+
+// Client NvmlClient reading the AMD GPU metrics exposed by the amdgpu driver in sysfs
+type Client struct {
+	sysRoot string
+	cards   []string
+}
+
+// Device sysfs device directory of a card
+type Device struct {
+	path string
+}
+
+// New Create a new amdgpu client reading sysfs at the given root
+func New(sysRoot string) *Client {
+	return &Client{sysRoot: sysRoot}
+}
+
+// Available returns true if at least one AMD GPU is exposed in sysfs
+func Available(sysRoot string) bool {
+	cards, err := discover(sysRoot)
+	return err == nil && len(cards) > 0
+}
+
+// discover list the device directories of the AMD cards sorted by card index
+func discover(sysRoot string) ([]string, error) {
+	drm := filepath.Join(sysRoot, "class", "drm")
+	entries, err := ioutil.ReadDir(drm)
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []string
+	for _, entry := range entries {
+		if !cardPattern.MatchString(entry.Name()) {
+			continue
+		}
+		device := filepath.Join(drm, entry.Name(), "device")
+		if vendor, err := utils.ReadString(filepath.Join(device, "vendor")); err != nil || vendor != amdVendorID {
+			continue
+		}
+		cards = append(cards, device)
+	}
+
+	sort.Slice(cards, func(i, j int) bool {
+		return cardIndex(cards[i]) < cardIndex(cards[j])
+	})
+	return cards, nil
+}
+
+func cardIndex(device string) int {
+	index, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(device)), "card"))
+	return index
+}
+
+func (client *Client) Init() error {
+	cards, err := discover(client.sysRoot)
+	if err != nil {
+		return err
+	}
+	if len(cards) == 0 {
+		return errors.New("amdgpu: no AMD GPU found")
+	}
+	client.cards = cards
+	return nil
+}
+
+func (client *Client) Shutdown() error {
+	client.cards = nil
+	return nil
+}
+
+func (client *Client) GetDeviceCount() (uint, error) {
+	return uint(len(client.cards)), nil
+}
+
+func (client *Client) DeviceGetHandleByIndex(index uint) (nvml.Device, error) {
+	if index >= uint(len(client.cards)) {
+		return nil, fmt.Errorf("amdgpu: invalid device index %d", index)
+	}
+	path := client.cards[index]
+	if _, err := utils.ReadString(filepath.Join(path, "vendor")); err != nil {
+		// The device disappeared from sysfs
+		return nil, nvml.ErrGPULost
+	}
+	return nvml.Device(Device{path: path}), nil
+}
+
+// DeviceGetUUID the unique ID is only exposed by recent GPUs. Fallback on the PCI bus ID which is stable for a VM
+func (client *Client) DeviceGetUUID(device nvml.Device) (string, error) {
+	amdDevice := device.(Device)
+	if uniqueID, err := utils.ReadString(filepath.Join(amdDevice.path, "unique_id")); err == nil && uniqueID != "" {
+		return "GPU-" + uniqueID, nil
+	}
+	busID, err := client.DeviceGetPCIBusID(device)
+	if err != nil {
+		return "", err
+	}
+	return "GPU-" + busID, nil
+}
+
+func (client *Client) DeviceGetName(device nvml.Device) (string, error) {
+	amdDevice := device.(Device)
+	if name, err := utils.ReadString(filepath.Join(amdDevice.path, "product_name")); err == nil && name != "" {
+		return name, nil
+	}
+	deviceID, err := utils.ReadString(filepath.Join(amdDevice.path, "device"))
+	if err != nil {
+		return "", nvml.ErrNotSupported
+	}
+	return "AMD GPU " + deviceID, nil
+}
+
+func (client *Client) DeviceGetPCIBusID(device nvml.Device) (string, error) {
+	amdDevice := device.(Device)
+	content, err := ioutil.ReadFile(filepath.Join(amdDevice.path, "uevent"))
+	if err != nil {
+		return "", nvml.ErrNotSupported
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "PCI_SLOT_NAME=") {
+			return strings.TrimPrefix(line, "PCI_SLOT_NAME="), nil
+		}
+	}
+	return "", nvml.ErrNotSupported
+}
+
+func (client *Client) DeviceGetMemoryInfo(device nvml.Device) (nvml.Memory, error) {
+	amdDevice := device.(Device)
+	total, err := utils.ReadUint(filepath.Join(amdDevice.path, "mem_info_vram_total"))
+	if err != nil {
+		return nvml.Memory{}, err
+	}
+	used, err := utils.ReadUint(filepath.Join(amdDevice.path, "mem_info_vram_used"))
+	if err != nil {
+		return nvml.Memory{}, err
+	}
+	free := uint64(0)
+	if total > used {
+		free = total - used
+	}
+	return nvml.Memory{Total: total, Used: used, Free: free}, nil
+}
+
+func (client *Client) DeviceGetUtilizationRates(device nvml.Device) (nvml.GPUUtilization, error) {
+	amdDevice := device.(Device)
+	gpu, err := utils.ReadUint(filepath.Join(amdDevice.path, "gpu_busy_percent"))
+	if err != nil {
+		return nvml.GPUUtilization{}, err
+	}
+	// Not exposed by older kernels
+	memory, _ := utils.ReadUint(filepath.Join(amdDevice.path, "mem_busy_percent"))
+	return nvml.GPUUtilization{GPU: uint(gpu), Memory: uint(memory)}, nil
+}
+
+// DeviceGetTemperature edge temperature reported by hwmon in millidegrees
+func (client *Client) DeviceGetTemperature(device nvml.Device) (uint, error) {
+	value, err := client.readHwmon(device, "temp1_input")
+	return uint(value / 1000), err
+}
+
+// DeviceGetPowerUsage average power reported by hwmon in microwatts
+func (client *Client) DeviceGetPowerUsage(device nvml.Device) (uint, error) {
+	value, err := client.readHwmon(device, "power1_average")
+	return uint(value / 1000), err
+}
+
+// DeviceGetPowerLimit power cap reported by hwmon in microwatts
+func (client *Client) DeviceGetPowerLimit(device nvml.Device) (uint, error) {
+	value, err := client.readHwmon(device, "power1_cap")
+	return uint(value / 1000), err
+}
+
+func (client *Client) DeviceGetClocks(device nvml.Device) (nvml.Clocks, error) {
+	amdDevice := device.(Device)
+	sm, err := readCurrentClock(filepath.Join(amdDevice.path, "pp_dpm_sclk"))
+	if err != nil {
+		return nvml.Clocks{}, nvml.ErrNotSupported
+	}
+	memory, err := readCurrentClock(filepath.Join(amdDevice.path, "pp_dpm_mclk"))
+	if err != nil {
+		return nvml.Clocks{}, nvml.ErrNotSupported
+	}
+	return nvml.Clocks{SM: sm, Memory: memory}, nil
+}
+
+func (client *Client) DeviceGetThrottleReasons(device nvml.Device) (nvml.ThrottleReasons, error) {
+	return 0, nvml.ErrNotSupported
+}
+
+func (client *Client) DeviceGetECCErrors(device nvml.Device) (nvml.ECCErrors, error) {
+	return nvml.ECCErrors{}, nvml.ErrNotSupported
+}
+
+func (client *Client) DeviceGetPCIeThroughput(device nvml.Device) (nvml.PCIeThroughput, error) {
+	return nvml.PCIeThroughput{}, nvml.ErrNotSupported
+}
+
+func (client *Client) DeviceGetComputeRunningProcesses(device nvml.Device) ([]nvml.ProcessInfo, error) {
+	return nil, nvml.ErrNotSupported
+}
+
+func (client *Client) DeviceGetProcessUtilization(device nvml.Device, since time.Time) ([]nvml.ProcessUtilization, error) {
+	return nil, nvml.ErrNotSupported
+}
+
+// readHwmon read a sensor of the hwmon directory of the device
+func (client *Client) readHwmon(device nvml.Device, sensor string) (uint64, error) {
+	amdDevice := device.(Device)
+	matches, _ := filepath.Glob(filepath.Join(amdDevice.path, "hwmon", "hwmon*", sensor))
+	if len(matches) == 0 {
+		return 0, nvml.ErrNotSupported
+	}
+	return utils.ReadUint(matches[0])
+}
+
+// readCurrentClock parse a DPM clock table and return the level marked as current. e.g. "1: 1000Mhz *"
+func readCurrentClock(path string) (uint, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[2] != "*" {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSuffix(strings.ToLower(fields[1]), "mhz"), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return uint(value), nil
+	}
+	return 0, errors.New("amdgpu: no current clock level")
+}
@@ -0,0 +1,69 @@
+package amdgpu_test
+
+import (
+	"testing"
+
+	"github.com/Azure/batch-insights/amdgpu"
+	"github.com/Azure/batch-insights/nvml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverCards(t *testing.T) {
+	assert.True(t, amdgpu.Available("testdata/sys"))
+	assert.False(t, amdgpu.Available("testdata/missing"))
+
+	client := amdgpu.New("testdata/sys")
+	assert.Nil(t, client.Init())
+
+	// Connectors and non AMD cards are skipped
+	count, err := client.GetDeviceCount()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), count)
+}
+
+func TestDeviceStats(t *testing.T) {
+	client := amdgpu.New("testdata/sys")
+	assert.Nil(t, client.Init())
+	device, err := client.DeviceGetHandleByIndex(0)
+	assert.Nil(t, err)
+
+	uuid, _ := client.DeviceGetUUID(device)
+	assert.Equal(t, "GPU-8d3a6a5e0f8c4b43", uuid)
+	name, _ := client.DeviceGetName(device)
+	assert.Equal(t, "AMD GPU 0x738c", name)
+	busID, _ := client.DeviceGetPCIBusID(device)
+	assert.Equal(t, "0000:c1:00.0", busID)
+
+	memory, err := client.DeviceGetMemoryInfo(device)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(34342961152), memory.Total)
+	assert.Equal(t, uint64(8585740288), memory.Used)
+
+	use, err := client.DeviceGetUtilizationRates(device)
+	assert.Nil(t, err)
+	assert.Equal(t, nvml.GPUUtilization{GPU: 42, Memory: 7}, use)
+
+	temperature, _ := client.DeviceGetTemperature(device)
+	assert.Equal(t, uint(45), temperature)
+	power, _ := client.DeviceGetPowerUsage(device)
+	assert.Equal(t, uint(91000), power)
+	limit, _ := client.DeviceGetPowerLimit(device)
+	assert.Equal(t, uint(290000), limit)
+	clocks, _ := client.DeviceGetClocks(device)
+	assert.Equal(t, nvml.Clocks{SM: 1000, Memory: 1200}, clocks)
+}
+
+func TestDeviceWithoutHwmon(t *testing.T) {
+	client := amdgpu.New("testdata/sys")
+	assert.Nil(t, client.Init())
+	device, err := client.DeviceGetHandleByIndex(1)
+	assert.Nil(t, err)
+
+	uuid, _ := client.DeviceGetUUID(device)
+	assert.Equal(t, "GPU-0000:c2:00.0", uuid)
+
+	_, err = client.DeviceGetTemperature(device)
+	assert.Equal(t, nvml.ErrNotSupported, err)
+	_, err = client.DeviceGetClocks(device)
+	assert.Equal(t, nvml.ErrNotSupported, err)
+}
@@ -15,4 +15,5 @@ require (
 	github.com/shirou/w32 v0.0.0-20160930032740-bb4de0191aa4 // indirect
 	github.com/sirupsen/logrus v1.3.0
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/crypto v0.0.0-20180904163835-0709b304e793
 )
@@ -25,6 +25,96 @@ func getenv(key string) *string {
 	return &value
 }
 
+// Flags used for demos and tests which are not shown in the usage
+var hiddenFlags = map[string]bool{
+	"simulateGpus": true,
+}
+
+func printUsage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	flag.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "  -%s\n    \t%s (default %q)\n", f.Name, f.Usage, f.DefValue)
+	})
+	fmt.Fprintf(flag.CommandLine.Output(), "Commands:\n  collect --once [--format json|text]\n    \tTake a single sample, print it and exit. See %s collect --help\n", os.Args[0])
+}
+
+func getEnvConfig() batchinsights.UserConfig {
+	envConfig := batchinsights.UserConfig{
+		InstrumentationKey: getenv("APP_INSIGHTS_INSTRUMENTATION_KEY"),
+		PoolID:             getenv("AZ_BATCH_POOL_ID"),
+		NodeID:             getenv("AZ_BATCH_NODE_ID"),
+		ProcRoot:           getenv("HOST_PROC"),
+		SysRoot:            getenv("HOST_SYS"),
+	}
+	processEnv := getenv("AZ_BATCH_MONITOR_PROCESSES")
+	if processEnv != nil {
+		envConfig.Processes = parseListArgs(*processEnv)
+	}
+	return envConfig
+}
+
+// collect take a single sample and print it. Exits with 1 if a collector failed
+func collect(args []string) {
+	flags := flag.NewFlagSet("collect", flag.ExitOnError)
+	once := flags.Bool("once", false, "Take a single sample and exit")
+	format := flags.String("format", batchinsights.FormatText, "Output format: json or text")
+	disableArg := flags.String("disable", "", "List of metrics to disable")
+	processArg := flags.String("processes", "", "List of process name to watch")
+	argsConfig := batchinsights.UserConfig{
+		ProcRoot:     flags.String("procRoot", "", "Path where procfs is mounted"),
+		CgroupRoot:   flags.String("cgroupRoot", "", "Path where the cgroup filesystem is mounted"),
+		SysRoot:      flags.String("sysRoot", "", "Path where sysfs is mounted"),
+		SamplingRate: flags.String("samplingRate", "", "Time between the two readings of the rate based metrics. e.g. 5s"),
+	}
+	flags.Parse(args)
+
+	if !*once {
+		fmt.Fprintln(os.Stderr, "collect requires --once, run batch-insights without a command to sample continuously")
+		os.Exit(2)
+	}
+	if err := batchinsights.ValidateFormat(*format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	argsConfig.Processes = parseListArgs(*processArg)
+	argsConfig.Disable = parseListArgs(*disableArg)
+
+	// The pool and node IDs are only used to label the uploaded metrics
+	config := getEnvConfig().Merge(argsConfig)
+	if config.PoolID == nil {
+		config.PoolID = new(string)
+	}
+	if config.NodeID == nil {
+		config.NodeID = new(string)
+	}
+	computedConfig, err := batchinsights.ValidateAndBuildConfig(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config", err)
+		os.Exit(2)
+	}
+
+	// The collectors print diagnostics, keep the standard output for the sample
+	stats := batchinsights.CollectOnce(computedConfig, os.Stderr)
+
+	if err := batchinsights.PrintSample(os.Stdout, stats, *format); err != nil {
+		fmt.Fprintln(os.Stderr, "Error while printing the sample", err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, status := range stats.Collectors {
+		if status.Failed() {
+			fmt.Fprintln(os.Stderr, "Collector failed:", status)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
 func initLogger() {
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp:    false,
@@ -35,27 +125,39 @@ func initLogger() {
 
 func main() {
 	initLogger()
+	if len(os.Args) > 1 && os.Args[1] == "collect" {
+		collect(os.Args[2:])
+		return
+	}
+
 	disableArg := flag.String("disable", "", "List of metrics to disable")
 	processArg := flag.String("processes", "", "List of process name to watch")
+	intervalsArg := flag.String("intervals", "", "Sampling interval of specific collectors. e.g. cpu=1s,gpu=1s,diskUsage=1m")
 
-	envConfig := batchinsights.UserConfig{
-		InstrumentationKey: getenv("APP_INSIGHTS_INSTRUMENTATION_KEY"),
-		PoolID:             getenv("AZ_BATCH_POOL_ID"),
-		NodeID:             getenv("AZ_BATCH_NODE_ID"),
-	}
-	processEnv := getenv("AZ_BATCH_MONITOR_PROCESSES")
-	if processEnv != nil {
-		envConfig.Processes = parseListArgs(*processEnv)
-	}
+	envConfig := getEnvConfig()
 	argsConfig := batchinsights.UserConfig{
 		PoolID:             flag.String("poolID", "", "Batch pool ID"),
 		NodeID:             flag.String("nodeID", "", "Batch node ID"),
 		Aggregation:        flag.Int("aggregation", 1, "Aggregation in minutes"),
 		InstrumentationKey: flag.String("instKey", "", "Application Insights instrumentation KEY"),
+		ProcRoot:           flag.String("procRoot", "", "Path where procfs is mounted"),
+		CgroupRoot:         flag.String("cgroupRoot", "", "Path where the cgroup filesystem is mounted"),
+		SysRoot:            flag.String("sysRoot", "", "Path where sysfs is mounted"),
+		SimulatedGPUs:      flag.Int("simulateGpus", 0, "Number of simulated GPUs to report instead of the real ones"),
+		SamplingRate:       flag.String("samplingRate", "", "Default time between samples. e.g. 5s"),
+		HTTPAddress:        flag.String("httpAddress", "", "Port or loopback address of the local HTTP status API. e.g. 9471 or 127.0.0.1:9471"),
+		History:            flag.String("history", "", "Time range of samples kept in memory for the HTTP API. e.g. 10m"),
+		TUI:                flag.Bool("tui", false, "Show a live dashboard in the terminal"),
+		Alerts:             flag.String("alerts", "", "Path of a JSON file with alert rules evaluated on every sample"),
+		IdleWindow:         flag.String("idleWindow", "", "Time range over which the utilization is averaged to detect idle nodes. e.g. 5m"),
+		Record:             flag.String("record", "", "Append the raw samples of the collectors to the given file"),
+		Replay:             flag.String("replay", "", "Replay a recorded file instead of running the collectors"),
+		ReplaySpeed:        flag.Float64("replaySpeed", 1, "Speed of the replay. e.g. 10 for 10 times faster, 0 as fast as possible"),
 	}
 
 	version := flag.Bool("version", false, "Print current batch insights version")
 
+	flag.Usage = printUsage
 	flag.Parse()
 
 	if *version {
@@ -69,6 +171,9 @@ func main() {
 	if disableArg != nil {
 		argsConfig.Disable = parseListArgs(*disableArg)
 	}
+	if intervalsArg != nil && *intervalsArg != "" {
+		argsConfig.Intervals = parseListArgs(*intervalsArg)
+	}
 
 	config := envConfig.Merge(argsConfig)
 
@@ -1,13 +1,43 @@
 package nvml
 
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNotSupported returned when the device or the driver doesn't support the requested query
+var ErrNotSupported = errors.New("nvml: operation not supported")
+
+// ErrGPULost returned when the GPU has fallen off the bus or has otherwise become inaccessible
+var ErrGPULost = errors.New("nvml: GPU is lost")
+
+// IsGPULost returns true if the error means the device is no longer reachable.
+// gonvml doesn't expose the return codes so its errors are matched on the NVML message
+func IsGPULost(err error) bool {
+	return err != nil && (err == ErrGPULost || strings.Contains(err.Error(), "GPU is lost"))
+}
+
 type NvmlClient interface {
 	Init() error
 	Shutdown() error
 	GetDeviceCount() (uint, error)
 
 	DeviceGetHandleByIndex(index uint) (Device, error)
+	DeviceGetUUID(device Device) (string, error)
+	DeviceGetName(device Device) (string, error)
+	DeviceGetPCIBusID(device Device) (string, error)
 	DeviceGetMemoryInfo(device Device) (Memory, error)
 	DeviceGetUtilizationRates(device Device) (GPUUtilization, error)
+	DeviceGetTemperature(device Device) (uint, error) // GPU die temperature in degrees C
+	DeviceGetPowerUsage(device Device) (uint, error)  // Power draw in milliwatts
+	DeviceGetPowerLimit(device Device) (uint, error)  // Enforced power limit in milliwatts
+	DeviceGetClocks(device Device) (Clocks, error)
+	DeviceGetThrottleReasons(device Device) (ThrottleReasons, error)
+	DeviceGetECCErrors(device Device) (ECCErrors, error)
+	DeviceGetPCIeThroughput(device Device) (PCIeThroughput, error)
+	DeviceGetComputeRunningProcesses(device Device) ([]ProcessInfo, error)
+	DeviceGetProcessUtilization(device Device, since time.Time) ([]ProcessUtilization, error)
 }
 
 type GPUUtilization struct {
@@ -21,5 +51,71 @@ type Memory struct {
 	Used  uint64 // Allocated FB memory (in bytes).
 }
 
+// Clocks current clock speeds in MHz
+type Clocks struct {
+	SM     uint
+	Memory uint
+}
+
+// ECCErrors volatile ECC error counts(reset when the driver reloads)
+type ECCErrors struct {
+	Corrected   uint64 // Single bit errors
+	Uncorrected uint64 // Double bit errors
+}
+
+// PCIeThroughput PCIe utilization in KB/s
+type PCIeThroughput struct {
+	TX uint
+	RX uint
+}
+
+// ProcessInfo compute process running on a device
+type ProcessInfo struct {
+	PID        uint32
+	UsedMemory uint64 // Bytes. 0 when not available(e.g. windows WDDM driver)
+}
+
+// ProcessUtilization utilization sample of a process on a device
+type ProcessUtilization struct {
+	PID       uint32
+	Timestamp uint64 // CPU timestamp in microseconds
+	SM        uint   // Percent of the SM used by the process
+	Memory    uint   // Percent of the memory bandwidth used by the process
+}
+
+// ThrottleReasons bit mask of the reasons the clocks are being held down
+type ThrottleReasons uint64
+
+const (
+	ThrottleReasonGPUIdle                   = ThrottleReasons(0x0000000000000001)
+	ThrottleReasonApplicationsClocksSetting = ThrottleReasons(0x0000000000000002)
+	ThrottleReasonSWPowerCap                = ThrottleReasons(0x0000000000000004)
+	ThrottleReasonHWSlowdown                = ThrottleReasons(0x0000000000000008)
+	ThrottleReasonSyncBoost                 = ThrottleReasons(0x0000000000000010)
+	ThrottleReasonSWThermalSlowdown         = ThrottleReasons(0x0000000000000020)
+	ThrottleReasonHWThermalSlowdown         = ThrottleReasons(0x0000000000000040)
+	ThrottleReasonHWPowerBrakeSlowdown      = ThrottleReasons(0x0000000000000080)
+)
+
+// ThrottleReasonNames name of each throttle reason
+var ThrottleReasonNames = []struct {
+	Reason ThrottleReasons
+	Name   string
+}{
+	{ThrottleReasonGPUIdle, "GPU idle"},
+	{ThrottleReasonApplicationsClocksSetting, "Applications clocks setting"},
+	{ThrottleReasonSWPowerCap, "SW power cap"},
+	{ThrottleReasonHWSlowdown, "HW slowdown"},
+	{ThrottleReasonSyncBoost, "Sync boost"},
+	{ThrottleReasonSWThermalSlowdown, "SW thermal slowdown"},
+	{ThrottleReasonHWThermalSlowdown, "HW thermal slowdown"},
+	{ThrottleReasonHWPowerBrakeSlowdown, "HW power brake slowdown"},
+}
+
+// Has returns true if the given reason is part of the mask
+func (reasons ThrottleReasons) Has(reason ThrottleReasons) bool {
+	return reasons&reason != 0
+}
+
 type Device interface {
 }
@@ -3,10 +3,15 @@
 package nvml
 
 import (
+	"time"
+
 	nvml_linux "github.com/mindprince/gonvml"
 )
 
-type LinuxDevice = nvml_linux.Device
+type LinuxDevice struct {
+	device nvml_linux.Device
+	handle deviceHandle // Handle used by the functions gonvml doesn't expose
+}
 
 type LinuxNvmlClient struct {
 }
@@ -36,7 +41,7 @@ func (client *LinuxNvmlClient) GetDeviceCount() (uint, error) {
 
 func (client *LinuxNvmlClient) DeviceGetUtilizationRates(device Device) (GPUUtilization, error) {
 	linuxDevice := device.(LinuxDevice)
-	gpu, memory, err := linuxDevice.UtilizationRates()
+	gpu, memory, err := linuxDevice.device.UtilizationRates()
 	if err != nil {
 		return GPUUtilization{GPU: 0, Memory: 0}, err
 	}
@@ -50,7 +55,7 @@ func (client *LinuxNvmlClient) DeviceGetUtilizationRates(device Device) (GPUUtil
 
 func (client *LinuxNvmlClient) DeviceGetMemoryInfo(device Device) (Memory, error) {
 	linuxDevice := device.(LinuxDevice)
-	total, used, err := linuxDevice.MemoryInfo()
+	total, used, err := linuxDevice.device.MemoryInfo()
 	if err != nil {
 		return Memory{Used: used, Total: total}, err
 	}
@@ -60,7 +65,93 @@ func (client *LinuxNvmlClient) DeviceGetMemoryInfo(device Device) (Memory, error
 func (client *LinuxNvmlClient) DeviceGetHandleByIndex(index uint) (Device, error) {
 	device, err := nvml_linux.DeviceHandleByIndex(uint(index))
 	if err != nil {
-		return Device(device), err
+		return Device(LinuxDevice{device: device}), err
+	}
+	// Failing to get the extended handle only disable the extended queries
+	handle, _ := extDeviceGetHandleByIndex(index)
+	return Device(LinuxDevice{device: device, handle: handle}), nil
+}
+
+func (client *LinuxNvmlClient) DeviceGetUUID(device Device) (string, error) {
+	linuxDevice := device.(LinuxDevice)
+	return linuxDevice.device.UUID()
+}
+
+func (client *LinuxNvmlClient) DeviceGetName(device Device) (string, error) {
+	linuxDevice := device.(LinuxDevice)
+	return linuxDevice.device.Name()
+}
+
+func (client *LinuxNvmlClient) DeviceGetPCIBusID(device Device) (string, error) {
+	linuxDevice := device.(LinuxDevice)
+	if linuxDevice.handle == nil {
+		return "", ErrNotSupported
+	}
+	return extDeviceGetPCIBusID(linuxDevice.handle)
+}
+
+func (client *LinuxNvmlClient) DeviceGetTemperature(device Device) (uint, error) {
+	linuxDevice := device.(LinuxDevice)
+	return linuxDevice.device.Temperature()
+}
+
+func (client *LinuxNvmlClient) DeviceGetPowerUsage(device Device) (uint, error) {
+	linuxDevice := device.(LinuxDevice)
+	return linuxDevice.device.PowerUsage()
+}
+
+func (client *LinuxNvmlClient) DeviceGetPowerLimit(device Device) (uint, error) {
+	linuxDevice := device.(LinuxDevice)
+	if linuxDevice.handle == nil {
+		return 0, ErrNotSupported
+	}
+	return extDeviceGetPowerLimit(linuxDevice.handle)
+}
+
+func (client *LinuxNvmlClient) DeviceGetClocks(device Device) (Clocks, error) {
+	linuxDevice := device.(LinuxDevice)
+	if linuxDevice.handle == nil {
+		return Clocks{}, ErrNotSupported
+	}
+	return extDeviceGetClocks(linuxDevice.handle)
+}
+
+func (client *LinuxNvmlClient) DeviceGetThrottleReasons(device Device) (ThrottleReasons, error) {
+	linuxDevice := device.(LinuxDevice)
+	if linuxDevice.handle == nil {
+		return 0, ErrNotSupported
+	}
+	return extDeviceGetThrottleReasons(linuxDevice.handle)
+}
+
+func (client *LinuxNvmlClient) DeviceGetECCErrors(device Device) (ECCErrors, error) {
+	linuxDevice := device.(LinuxDevice)
+	if linuxDevice.handle == nil {
+		return ECCErrors{}, ErrNotSupported
+	}
+	return extDeviceGetECCErrors(linuxDevice.handle)
+}
+
+func (client *LinuxNvmlClient) DeviceGetPCIeThroughput(device Device) (PCIeThroughput, error) {
+	linuxDevice := device.(LinuxDevice)
+	if linuxDevice.handle == nil {
+		return PCIeThroughput{}, ErrNotSupported
+	}
+	return extDeviceGetPCIeThroughput(linuxDevice.handle)
+}
+
+func (client *LinuxNvmlClient) DeviceGetComputeRunningProcesses(device Device) ([]ProcessInfo, error) {
+	linuxDevice := device.(LinuxDevice)
+	if linuxDevice.handle == nil {
+		return nil, ErrNotSupported
+	}
+	return extDeviceGetComputeRunningProcesses(linuxDevice.handle)
+}
+
+func (client *LinuxNvmlClient) DeviceGetProcessUtilization(device Device, since time.Time) ([]ProcessUtilization, error) {
+	linuxDevice := device.(LinuxDevice)
+	if linuxDevice.handle == nil {
+		return nil, ErrNotSupported
 	}
-	return Device(device), nil
+	return extDeviceGetProcessUtilization(linuxDevice.handle, since)
 }
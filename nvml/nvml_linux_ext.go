@@ -0,0 +1,297 @@
+// +build linux
+
+package nvml
+
+// Bindings for the NVML functions which are not exposed by gonvml.
+// libnvidia-ml is loaded dynamically so the binary still runs on nodes without the Nvidia driver.
+
+// #cgo LDFLAGS: -ldl
+/*
+#include <stddef.h>
+#include <dlfcn.h>
+
+typedef struct nvmlDevice_st* nvmlDevice_t;
+typedef int nvmlReturn_t;
+
+typedef struct {
+	char busIdLegacy[16];
+	unsigned int domain;
+	unsigned int bus;
+	unsigned int device;
+	unsigned int pciDeviceId;
+	unsigned int pciSubSystemId;
+	char busId[32];
+} nvmlPciInfo_v3_t;
+
+typedef struct {
+	unsigned int pid;
+	unsigned long long usedGpuMemory;
+} nvmlProcessInfo_v1_t;
+
+typedef struct {
+	unsigned int pid;
+	unsigned long long timeStamp;
+	unsigned int smUtil;
+	unsigned int memUtil;
+	unsigned int encUtil;
+	unsigned int decUtil;
+} nvmlProcessUtilizationSample_t;
+
+#define NVML_SUCCESS 0
+#define NVML_ERROR_NOT_SUPPORTED 3
+#define NVML_ERROR_NOT_FOUND 6
+#define NVML_ERROR_INSUFFICIENT_SIZE 7
+#define NVML_ERROR_LIBRARY_NOT_FOUND 12
+#define NVML_ERROR_FUNCTION_NOT_FOUND 13
+#define NVML_ERROR_GPU_IS_LOST 15
+
+static void *extNvmlHandle;
+
+static int extLoad() {
+	if (extNvmlHandle == NULL) {
+		extNvmlHandle = dlopen("libnvidia-ml.so.1", RTLD_LAZY);
+	}
+	return extNvmlHandle != NULL;
+}
+
+static void *extSymbol(const char *name) {
+	if (!extLoad()) {
+		return NULL;
+	}
+	return dlsym(extNvmlHandle, name);
+}
+
+static const char *extErrorString(nvmlReturn_t result) {
+	const char *(*f)(nvmlReturn_t) = extSymbol("nvmlErrorString");
+	if (f == NULL) {
+		return "unknown error";
+	}
+	return f(result);
+}
+
+static nvmlReturn_t extDeviceGetHandleByIndex(unsigned int index, nvmlDevice_t *device) {
+	nvmlReturn_t (*f)(unsigned int, nvmlDevice_t *) = extSymbol("nvmlDeviceGetHandleByIndex_v2");
+	if (f == NULL) {
+		return extNvmlHandle == NULL ? NVML_ERROR_LIBRARY_NOT_FOUND : NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return f(index, device);
+}
+
+static nvmlReturn_t extDeviceGetPciInfo(nvmlDevice_t device, nvmlPciInfo_v3_t *pci) {
+	nvmlReturn_t (*f)(nvmlDevice_t, nvmlPciInfo_v3_t *) = extSymbol("nvmlDeviceGetPciInfo_v3");
+	if (f == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return f(device, pci);
+}
+
+static nvmlReturn_t extDeviceGetEnforcedPowerLimit(nvmlDevice_t device, unsigned int *limit) {
+	nvmlReturn_t (*f)(nvmlDevice_t, unsigned int *) = extSymbol("nvmlDeviceGetEnforcedPowerLimit");
+	if (f == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return f(device, limit);
+}
+
+static nvmlReturn_t extDeviceGetClockInfo(nvmlDevice_t device, int type, unsigned int *clock) {
+	nvmlReturn_t (*f)(nvmlDevice_t, int, unsigned int *) = extSymbol("nvmlDeviceGetClockInfo");
+	if (f == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return f(device, type, clock);
+}
+
+static nvmlReturn_t extDeviceGetCurrentClocksThrottleReasons(nvmlDevice_t device, unsigned long long *reasons) {
+	nvmlReturn_t (*f)(nvmlDevice_t, unsigned long long *) = extSymbol("nvmlDeviceGetCurrentClocksThrottleReasons");
+	if (f == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return f(device, reasons);
+}
+
+static nvmlReturn_t extDeviceGetTotalEccErrors(nvmlDevice_t device, int errorType, int counterType, unsigned long long *count) {
+	nvmlReturn_t (*f)(nvmlDevice_t, int, int, unsigned long long *) = extSymbol("nvmlDeviceGetTotalEccErrors");
+	if (f == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return f(device, errorType, counterType, count);
+}
+
+static nvmlReturn_t extDeviceGetPcieThroughput(nvmlDevice_t device, int counter, unsigned int *value) {
+	nvmlReturn_t (*f)(nvmlDevice_t, int, unsigned int *) = extSymbol("nvmlDeviceGetPcieThroughput");
+	if (f == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return f(device, counter, value);
+}
+
+static nvmlReturn_t extDeviceGetComputeRunningProcesses(nvmlDevice_t device, unsigned int *count, nvmlProcessInfo_v1_t *infos) {
+	nvmlReturn_t (*f)(nvmlDevice_t, unsigned int *, nvmlProcessInfo_v1_t *) = extSymbol("nvmlDeviceGetComputeRunningProcesses");
+	if (f == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return f(device, count, infos);
+}
+
+static nvmlReturn_t extDeviceGetProcessUtilization(nvmlDevice_t device, nvmlProcessUtilizationSample_t *samples, unsigned int *count, unsigned long long lastSeen) {
+	nvmlReturn_t (*f)(nvmlDevice_t, nvmlProcessUtilizationSample_t *, unsigned int *, unsigned long long) = extSymbol("nvmlDeviceGetProcessUtilization");
+	if (f == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return f(device, samples, count, lastSeen);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	clockSM  = 1
+	clockMem = 2
+
+	memoryErrorCorrected   = 0
+	memoryErrorUncorrected = 1
+	volatileECC            = 0
+
+	pcieUtilTX = 0
+	pcieUtilRX = 1
+)
+
+type deviceHandle = C.nvmlDevice_t
+
+func extError(ret C.nvmlReturn_t) error {
+	switch ret {
+	case C.NVML_SUCCESS:
+		return nil
+	case C.NVML_ERROR_NOT_SUPPORTED, C.NVML_ERROR_FUNCTION_NOT_FOUND, C.NVML_ERROR_LIBRARY_NOT_FOUND:
+		return ErrNotSupported
+	case C.NVML_ERROR_GPU_IS_LOST:
+		return ErrGPULost
+	}
+	return fmt.Errorf("nvml: %v", C.GoString(C.extErrorString(ret)))
+}
+
+func extDeviceGetHandleByIndex(index uint) (deviceHandle, error) {
+	var handle C.nvmlDevice_t
+	err := extError(C.extDeviceGetHandleByIndex(C.uint(index), &handle))
+	return handle, err
+}
+
+func extDeviceGetPCIBusID(handle deviceHandle) (string, error) {
+	var pci C.nvmlPciInfo_v3_t
+	if err := extError(C.extDeviceGetPciInfo(handle, &pci)); err != nil {
+		return "", err
+	}
+	return C.GoString(&pci.busId[0]), nil
+}
+
+func extDeviceGetPowerLimit(handle deviceHandle) (uint, error) {
+	var limit C.uint
+	err := extError(C.extDeviceGetEnforcedPowerLimit(handle, &limit))
+	return uint(limit), err
+}
+
+func extDeviceGetClocks(handle deviceHandle) (Clocks, error) {
+	var sm, memory C.uint
+	if err := extError(C.extDeviceGetClockInfo(handle, clockSM, &sm)); err != nil {
+		return Clocks{}, err
+	}
+	if err := extError(C.extDeviceGetClockInfo(handle, clockMem, &memory)); err != nil {
+		return Clocks{}, err
+	}
+	return Clocks{SM: uint(sm), Memory: uint(memory)}, nil
+}
+
+func extDeviceGetThrottleReasons(handle deviceHandle) (ThrottleReasons, error) {
+	var reasons C.ulonglong
+	err := extError(C.extDeviceGetCurrentClocksThrottleReasons(handle, &reasons))
+	return ThrottleReasons(reasons), err
+}
+
+func extDeviceGetECCErrors(handle deviceHandle) (ECCErrors, error) {
+	var corrected, uncorrected C.ulonglong
+	if err := extError(C.extDeviceGetTotalEccErrors(handle, memoryErrorCorrected, volatileECC, &corrected)); err != nil {
+		return ECCErrors{}, err
+	}
+	if err := extError(C.extDeviceGetTotalEccErrors(handle, memoryErrorUncorrected, volatileECC, &uncorrected)); err != nil {
+		return ECCErrors{}, err
+	}
+	return ECCErrors{Corrected: uint64(corrected), Uncorrected: uint64(uncorrected)}, nil
+}
+
+func extDeviceGetPCIeThroughput(handle deviceHandle) (PCIeThroughput, error) {
+	var tx, rx C.uint
+	if err := extError(C.extDeviceGetPcieThroughput(handle, pcieUtilTX, &tx)); err != nil {
+		return PCIeThroughput{}, err
+	}
+	if err := extError(C.extDeviceGetPcieThroughput(handle, pcieUtilRX, &rx)); err != nil {
+		return PCIeThroughput{}, err
+	}
+	return PCIeThroughput{TX: uint(tx), RX: uint(rx)}, nil
+}
+
+func extDeviceGetComputeRunningProcesses(handle deviceHandle) ([]ProcessInfo, error) {
+	// Processes can start between the 2 calls so leave some room
+	var count C.uint
+	ret := C.extDeviceGetComputeRunningProcesses(handle, &count, nil)
+	if ret == C.NVML_SUCCESS {
+		return nil, nil
+	}
+	if ret != C.NVML_ERROR_INSUFFICIENT_SIZE {
+		return nil, extError(ret)
+	}
+
+	count += 8
+	infos := make([]C.nvmlProcessInfo_v1_t, count)
+	if err := extError(C.extDeviceGetComputeRunningProcesses(handle, &count, &infos[0])); err != nil {
+		return nil, err
+	}
+
+	processes := make([]ProcessInfo, 0, count)
+	for _, info := range infos[:count] {
+		usedMemory := uint64(info.usedGpuMemory)
+		if usedMemory == math.MaxUint64 {
+			usedMemory = 0
+		}
+		processes = append(processes, ProcessInfo{PID: uint32(info.pid), UsedMemory: usedMemory})
+	}
+	return processes, nil
+}
+
+func extDeviceGetProcessUtilization(handle deviceHandle, since time.Time) ([]ProcessUtilization, error) {
+	lastSeen := C.ulonglong(since.UnixNano() / 1000)
+
+	var count C.uint
+	ret := C.extDeviceGetProcessUtilization(handle, nil, &count, lastSeen)
+	if ret == C.NVML_SUCCESS || ret == C.NVML_ERROR_NOT_FOUND {
+		// No process sampled since the given time
+		return nil, nil
+	}
+	if ret != C.NVML_ERROR_INSUFFICIENT_SIZE {
+		return nil, extError(ret)
+	}
+
+	samples := make([]C.nvmlProcessUtilizationSample_t, count)
+	ret = C.extDeviceGetProcessUtilization(handle, &samples[0], &count, lastSeen)
+	if ret == C.NVML_ERROR_NOT_FOUND {
+		return nil, nil
+	}
+	if err := extError(ret); err != nil {
+		return nil, err
+	}
+
+	utilizations := make([]ProcessUtilization, 0, count)
+	for _, sample := range samples[:count] {
+		utilizations = append(utilizations, ProcessUtilization{
+			PID:       uint32(sample.pid),
+			Timestamp: uint64(sample.timeStamp),
+			SM:        uint(sample.smUtil),
+			Memory:    uint(sample.memUtil),
+		})
+	}
+	return utilizations, nil
+}
@@ -3,6 +3,8 @@
 package nvml
 
 import (
+	"time"
+
 	nvml_win "github.com/mxpv/nvml-go"
 )
 
@@ -49,7 +51,7 @@ func (client *WinNvmlClient) DeviceGetUtilizationRates(device Device) (GPUUtiliz
 	winDevice := device.(WinDevice)
 	value, err := client.api.DeviceGetUtilizationRates(winDevice.handle)
 	if err != nil {
-		return GPUUtilization{GPU: 0, Memory: 0}, err
+		return GPUUtilization{GPU: 0, Memory: 0}, winError(err)
 	}
 
 	use := GPUUtilization{
@@ -63,15 +65,133 @@ func (client *WinNvmlClient) DeviceGetMemoryInfo(device Device) (Memory, error)
 	winDevice := device.(WinDevice)
 	use, err := client.api.DeviceGetMemoryInfo(winDevice.handle)
 	if err != nil {
-		return Memory(use), err
+		return Memory(use), winError(err)
 	}
 	return Memory(use), nil
 }
 
+func (client *WinNvmlClient) DeviceGetUUID(device Device) (string, error) {
+	winDevice := device.(WinDevice)
+	uuid, err := client.api.DeviceGetUUID(winDevice.handle)
+	return uuid, winError(err)
+}
+
+func (client *WinNvmlClient) DeviceGetName(device Device) (string, error) {
+	winDevice := device.(WinDevice)
+	name, err := client.api.DeviceGetName(winDevice.handle)
+	return name, winError(err)
+}
+
+func (client *WinNvmlClient) DeviceGetPCIBusID(device Device) (string, error) {
+	winDevice := device.(WinDevice)
+	pci, err := client.api.DeviceGetPCIInfo(winDevice.handle)
+	if err != nil {
+		return "", winError(err)
+	}
+	return pci.BusID, nil
+}
+
+func (client *WinNvmlClient) DeviceGetTemperature(device Device) (uint, error) {
+	winDevice := device.(WinDevice)
+	temperature, err := client.api.DeviceGetTemperature(winDevice.handle, nvml_win.TemperatureGPU)
+	return uint(temperature), winError(err)
+}
+
+func (client *WinNvmlClient) DeviceGetPowerUsage(device Device) (uint, error) {
+	winDevice := device.(WinDevice)
+	power, err := client.api.DeviceGetPowerUsage(winDevice.handle)
+	return uint(power), winError(err)
+}
+
+func (client *WinNvmlClient) DeviceGetPowerLimit(device Device) (uint, error) {
+	winDevice := device.(WinDevice)
+	limit, err := client.api.DeviceGetEnforcedPowerLimit(winDevice.handle)
+	return uint(limit), winError(err)
+}
+
+func (client *WinNvmlClient) DeviceGetClocks(device Device) (Clocks, error) {
+	winDevice := device.(WinDevice)
+	sm, err := client.api.DeviceGetClockInfo(winDevice.handle, nvml_win.ClockSM)
+	if err != nil {
+		return Clocks{}, winError(err)
+	}
+	memory, err := client.api.DeviceGetClockInfo(winDevice.handle, nvml_win.ClockMem)
+	if err != nil {
+		return Clocks{}, winError(err)
+	}
+	return Clocks{SM: uint(sm), Memory: uint(memory)}, nil
+}
+
+func (client *WinNvmlClient) DeviceGetThrottleReasons(device Device) (ThrottleReasons, error) {
+	winDevice := device.(WinDevice)
+	reasons, err := client.api.DeviceGetCurrentClocksThrottleReasons(winDevice.handle)
+	return ThrottleReasons(reasons), winError(err)
+}
+
+func (client *WinNvmlClient) DeviceGetECCErrors(device Device) (ECCErrors, error) {
+	winDevice := device.(WinDevice)
+	corrected, err := client.api.DeviceGetTotalECCErrors(winDevice.handle, nvml_win.MemoryErrorTypeCorrected, nvml_win.VolatileECC)
+	if err != nil {
+		return ECCErrors{}, winError(err)
+	}
+	uncorrected, err := client.api.DeviceGetTotalECCErrors(winDevice.handle, nvml_win.MemoryErrorTypeUncorrected, nvml_win.VolatileECC)
+	if err != nil {
+		return ECCErrors{}, winError(err)
+	}
+	return ECCErrors{Corrected: corrected, Uncorrected: uncorrected}, nil
+}
+
+func (client *WinNvmlClient) DeviceGetPCIeThroughput(device Device) (PCIeThroughput, error) {
+	winDevice := device.(WinDevice)
+	tx, err := client.api.DeviceGetPCIeThroughput(winDevice.handle, nvml_win.PCIeUtilTXBytes)
+	if err != nil {
+		return PCIeThroughput{}, winError(err)
+	}
+	rx, err := client.api.DeviceGetPCIeThroughput(winDevice.handle, nvml_win.PCIeUtilRXBytes)
+	if err != nil {
+		return PCIeThroughput{}, winError(err)
+	}
+	return PCIeThroughput{TX: uint(tx), RX: uint(rx)}, nil
+}
+
+func (client *WinNvmlClient) DeviceGetComputeRunningProcesses(device Device) ([]ProcessInfo, error) {
+	winDevice := device.(WinDevice)
+	infos, err := client.api.DeviceGetComputeRunningProcesses(winDevice.handle)
+	if err != nil {
+		return nil, winError(err)
+	}
+
+	var processes []ProcessInfo
+	for _, info := range infos {
+		process := ProcessInfo{PID: info.PID}
+		if info.MemoryInfoAvailable() {
+			process.UsedMemory = info.UsedGPUMemory
+		}
+		processes = append(processes, process)
+	}
+	return processes, nil
+}
+
+// DeviceGetProcessUtilization is not exposed by nvml-go
+func (client *WinNvmlClient) DeviceGetProcessUtilization(device Device, since time.Time) ([]ProcessUtilization, error) {
+	return nil, ErrNotSupported
+}
+
+// winError convert the nvml-go errors which have an equivalent in this package
+func winError(err error) error {
+	if err == nvml_win.ErrNotSupported || err == nvml_win.ErrFunctionNotFound {
+		return ErrNotSupported
+	}
+	if err == nvml_win.ErrGPULost {
+		return ErrGPULost
+	}
+	return err
+}
+
 func (client *WinNvmlClient) DeviceGetHandleByIndex(index uint) (Device, error) {
 	handle, err := client.api.DeviceGetHandleByIndex(uint32(index))
 	if err != nil {
-		return Device(WinDevice{handle: handle}), err
+		return Device(WinDevice{handle: handle}), winError(err)
 	}
 	return Device(WinDevice{handle: handle}), nil
 }
@@ -0,0 +1,282 @@
+package nvml
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Curve value of a simulated metric at the given time since the client was initialized
+type Curve func(elapsed time.Duration) float64
+
+// ConstantCurve curve always returning the same value
+func ConstantCurve(value float64) Curve {
+	return func(elapsed time.Duration) float64 {
+		return value
+	}
+}
+
+// SineCurve curve oscillating between min and max over the given period
+func SineCurve(min float64, max float64, period time.Duration) Curve {
+	return func(elapsed time.Duration) float64 {
+		phase := 2 * math.Pi * float64(elapsed) / float64(period)
+		return min + (max-min)*(1+math.Sin(phase))/2
+	}
+}
+
+// SimulatedDevice configuration of a simulated GPU
+type SimulatedDevice struct {
+	UUID        string
+	Name        string
+	PCIBusID    string
+	MemoryTotal uint64 // Bytes
+	MemoryUsed  Curve  // Bytes
+	Utilization Curve  // Percent
+	Temperature Curve  // Degrees C
+	PowerUsage  Curve  // Milliwatts
+	PowerLimit  uint   // Milliwatts
+	Processes   []ProcessInfo
+	// Utilization samples of the processes. When empty the device utilization is split evenly between the processes
+	ProcessSamples []ProcessUtilization
+	// Errors returned by the device queries, by method name. e.g. "DeviceGetMemoryInfo"
+	Errors map[string]error
+}
+
+// SimulatedClient NvmlClient returning the metrics of simulated devices. Used for tests and demos on nodes without GPU
+type SimulatedClient struct {
+	Devices []SimulatedDevice
+	// Errors returned by the client queries, by method name. e.g. "Init" or "GetDeviceCount"
+	Errors map[string]error
+	// Clock used to evaluate the curves. Defaults to time.Now
+	Clock func() time.Time
+
+	start time.Time
+}
+
+type simulatedDevice struct {
+	index uint
+}
+
+// NewSimulatedClient Create a new simulated client with the given devices
+func NewSimulatedClient(devices ...SimulatedDevice) *SimulatedClient {
+	return &SimulatedClient{
+		Devices: devices,
+		Errors:  make(map[string]error),
+		Clock:   time.Now,
+	}
+}
+
+// DefaultSimulatedDevices Create count simulated devices with varying utilization
+func DefaultSimulatedDevices(count int) []SimulatedDevice {
+	var devices []SimulatedDevice
+	for i := 0; i < count; i++ {
+		period := time.Duration(i+1) * time.Minute
+		devices = append(devices, SimulatedDevice{
+			UUID:        fmt.Sprintf("GPU-00000000-0000-0000-0000-%012d", i),
+			Name:        "Simulated GPU",
+			PCIBusID:    fmt.Sprintf("00000000:%02X:00.0", i+1),
+			MemoryTotal: 16 * 1024 * 1024 * 1024,
+			MemoryUsed:  SineCurve(1*1024*1024*1024, 12*1024*1024*1024, period),
+			Utilization: SineCurve(0, 100, period),
+			Temperature: SineCurve(40, 80, period),
+			PowerUsage:  SineCurve(50000, 250000, period),
+			PowerLimit:  300000,
+		})
+	}
+	return devices
+}
+
+func (client *SimulatedClient) Init() error {
+	if err := client.Errors["Init"]; err != nil {
+		return err
+	}
+	client.start = client.now()
+	return nil
+}
+
+func (client *SimulatedClient) Shutdown() error {
+	return client.Errors["Shutdown"]
+}
+
+func (client *SimulatedClient) GetDeviceCount() (uint, error) {
+	if err := client.Errors["GetDeviceCount"]; err != nil {
+		return 0, err
+	}
+	return uint(len(client.Devices)), nil
+}
+
+func (client *SimulatedClient) DeviceGetHandleByIndex(index uint) (Device, error) {
+	if index >= uint(len(client.Devices)) {
+		return nil, fmt.Errorf("nvml: invalid device index %d", index)
+	}
+	if err := client.Devices[index].Errors["DeviceGetHandleByIndex"]; err != nil {
+		return nil, err
+	}
+	return Device(simulatedDevice{index: index}), nil
+}
+
+func (client *SimulatedClient) DeviceGetUUID(device Device) (string, error) {
+	simulated, err := client.device(device, "DeviceGetUUID")
+	if err != nil {
+		return "", err
+	}
+	return simulated.UUID, nil
+}
+
+func (client *SimulatedClient) DeviceGetName(device Device) (string, error) {
+	simulated, err := client.device(device, "DeviceGetName")
+	if err != nil {
+		return "", err
+	}
+	return simulated.Name, nil
+}
+
+func (client *SimulatedClient) DeviceGetPCIBusID(device Device) (string, error) {
+	simulated, err := client.device(device, "DeviceGetPCIBusID")
+	if err != nil {
+		return "", err
+	}
+	return simulated.PCIBusID, nil
+}
+
+func (client *SimulatedClient) DeviceGetMemoryInfo(device Device) (Memory, error) {
+	simulated, err := client.device(device, "DeviceGetMemoryInfo")
+	if err != nil {
+		return Memory{}, err
+	}
+	used := uint64(client.evaluate(simulated.MemoryUsed))
+	return Memory{Total: simulated.MemoryTotal, Used: used, Free: simulated.MemoryTotal - used}, nil
+}
+
+func (client *SimulatedClient) DeviceGetUtilizationRates(device Device) (GPUUtilization, error) {
+	simulated, err := client.device(device, "DeviceGetUtilizationRates")
+	if err != nil {
+		return GPUUtilization{}, err
+	}
+	gpu := uint(client.evaluate(simulated.Utilization))
+	return GPUUtilization{GPU: gpu, Memory: gpu / 2}, nil
+}
+
+func (client *SimulatedClient) DeviceGetTemperature(device Device) (uint, error) {
+	simulated, err := client.device(device, "DeviceGetTemperature")
+	if err != nil {
+		return 0, err
+	}
+	if simulated.Temperature == nil {
+		return 0, ErrNotSupported
+	}
+	return uint(client.evaluate(simulated.Temperature)), nil
+}
+
+func (client *SimulatedClient) DeviceGetPowerUsage(device Device) (uint, error) {
+	simulated, err := client.device(device, "DeviceGetPowerUsage")
+	if err != nil {
+		return 0, err
+	}
+	if simulated.PowerUsage == nil {
+		return 0, ErrNotSupported
+	}
+	return uint(client.evaluate(simulated.PowerUsage)), nil
+}
+
+func (client *SimulatedClient) DeviceGetPowerLimit(device Device) (uint, error) {
+	simulated, err := client.device(device, "DeviceGetPowerLimit")
+	if err != nil {
+		return 0, err
+	}
+	if simulated.PowerLimit == 0 {
+		return 0, ErrNotSupported
+	}
+	return simulated.PowerLimit, nil
+}
+
+func (client *SimulatedClient) DeviceGetClocks(device Device) (Clocks, error) {
+	_, err := client.device(device, "DeviceGetClocks")
+	if err != nil {
+		return Clocks{}, err
+	}
+	return Clocks{}, ErrNotSupported
+}
+
+func (client *SimulatedClient) DeviceGetThrottleReasons(device Device) (ThrottleReasons, error) {
+	simulated, err := client.device(device, "DeviceGetThrottleReasons")
+	if err != nil {
+		return 0, err
+	}
+	if client.evaluate(simulated.Utilization) == 0 {
+		return ThrottleReasonGPUIdle, nil
+	}
+	return 0, nil
+}
+
+func (client *SimulatedClient) DeviceGetECCErrors(device Device) (ECCErrors, error) {
+	_, err := client.device(device, "DeviceGetECCErrors")
+	if err != nil {
+		return ECCErrors{}, err
+	}
+	return ECCErrors{}, nil
+}
+
+func (client *SimulatedClient) DeviceGetPCIeThroughput(device Device) (PCIeThroughput, error) {
+	_, err := client.device(device, "DeviceGetPCIeThroughput")
+	if err != nil {
+		return PCIeThroughput{}, err
+	}
+	return PCIeThroughput{}, ErrNotSupported
+}
+
+func (client *SimulatedClient) DeviceGetComputeRunningProcesses(device Device) ([]ProcessInfo, error) {
+	simulated, err := client.device(device, "DeviceGetComputeRunningProcesses")
+	if err != nil {
+		return nil, err
+	}
+	return simulated.Processes, nil
+}
+
+func (client *SimulatedClient) DeviceGetProcessUtilization(device Device, since time.Time) ([]ProcessUtilization, error) {
+	simulated, err := client.device(device, "DeviceGetProcessUtilization")
+	if err != nil {
+		return nil, err
+	}
+	if len(simulated.ProcessSamples) > 0 {
+		return simulated.ProcessSamples, nil
+	}
+	if len(simulated.Processes) == 0 {
+		return nil, nil
+	}
+
+	// Split the device utilization evenly between its processes
+	share := uint(client.evaluate(simulated.Utilization)) / uint(len(simulated.Processes))
+	timestamp := uint64(client.now().UnixNano() / 1000)
+	var samples []ProcessUtilization
+	for _, process := range simulated.Processes {
+		samples = append(samples, ProcessUtilization{PID: process.PID, Timestamp: timestamp, SM: share, Memory: share / 2})
+	}
+	return samples, nil
+}
+
+func (client *SimulatedClient) device(device Device, method string) (SimulatedDevice, error) {
+	handle, ok := device.(simulatedDevice)
+	if !ok || handle.index >= uint(len(client.Devices)) {
+		return SimulatedDevice{}, fmt.Errorf("nvml: invalid device %v", device)
+	}
+	simulated := client.Devices[handle.index]
+	if err := simulated.Errors[method]; err != nil {
+		return SimulatedDevice{}, err
+	}
+	return simulated, nil
+}
+
+func (client *SimulatedClient) evaluate(curve Curve) float64 {
+	if curve == nil {
+		return 0
+	}
+	return curve(client.now().Sub(client.start))
+}
+
+func (client *SimulatedClient) now() time.Time {
+	if client.Clock == nil {
+		return time.Now()
+	}
+	return client.Clock()
+}
@@ -0,0 +1,62 @@
+package batchinsights
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// AgentStats resource usage and delivery health of batch insights itself
+type AgentStats struct {
+	CPUPercent float64 // Percent of a single core
+	RSS        uint64
+	Goroutines int
+	Uptime     time.Duration
+	Sinks      []SinkStats
+}
+
+// AgentMonitor monitor of the batch insights process
+type AgentMonitor struct {
+	process *process.Process
+	start   time.Time
+}
+
+// NewAgentMonitor Create a monitor of the current process
+func NewAgentMonitor() *AgentMonitor {
+	monitor := AgentMonitor{start: time.Now()}
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err == nil {
+		monitor.process = proc
+		// The first CPU percent is a baseline
+		proc.Percent(0)
+	}
+	return &monitor
+}
+
+// GetStats Get the resource usage of the agent since the last call and the stats of the given sinks
+func (monitor *AgentMonitor) GetStats(sinks []Sink) (*AgentStats, error) {
+	stats := AgentStats{
+		Goroutines: runtime.NumGoroutine(),
+		Uptime:     time.Since(monitor.start),
+	}
+	for _, sink := range sinks {
+		stats.Sinks = append(stats.Sinks, sink.Stats())
+	}
+
+	if monitor.process == nil {
+		return &stats, nil
+	}
+	cpu, err := monitor.process.Percent(0)
+	if err != nil {
+		return &stats, err
+	}
+	stats.CPUPercent = cpu
+	memory, err := monitor.process.MemoryInfo()
+	if err != nil {
+		return &stats, err
+	}
+	stats.RSS = memory.RSS
+	return &stats, nil
+}
@@ -0,0 +1,479 @@
+package batchinsights
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/events"
+)
+
+// DefaultAlertActionTimeout default time allowed to the alert commands and webhooks
+const DefaultAlertActionTimeout = time.Minute
+
+// DefaultAlertSeriesExpiry time after which a series missing from the samples is dropped, e.g. an unmounted disk
+const DefaultAlertSeriesExpiry = 3 * DefaultSamplingRate
+
+// AlertState state of an alert rule on a series
+type AlertState string
+
+const (
+	// AlertOK the condition doesn't hold
+	AlertOK AlertState = "ok"
+	// AlertPending the condition holds but not for long enough yet
+	AlertPending AlertState = "pending"
+	// AlertFiring the condition held for the configured duration
+	AlertFiring AlertState = "firing"
+	// AlertResolved the metric got back past the clear threshold, or the series expired
+	AlertResolved AlertState = "resolved"
+)
+
+// AlertRule threshold rule evaluated on every sample. e.g. "Memory used percent" > 95 for 2m
+type AlertRule struct {
+	Name       string
+	Metric     string            // Name of the metric as uploaded to Application Insights, case insensitive
+	Properties map[string]string // Only the series with these properties. e.g. {"Disk": "/mnt"}
+	Operator   string            // >, >=, < or <=
+	Threshold  float64
+	Clear      *float64 // Value the metric must get back to before the alert resolves(hysteresis). Defaults to the threshold
+	For        string   // Time the condition must hold before firing. e.g. 2m
+	Window     string   // Compare the average over this time range instead of the last value. e.g. 1m
+	Actions    []AlertAction
+
+	forDuration    time.Duration
+	windowDuration time.Duration
+}
+
+// AlertAction action run when an alert fires
+type AlertAction struct {
+	Type     string // file, event, command or webhook
+	Path     string // file: file where the alerts are appended as JSON lines
+	Command  string // command: shell command. The ALERT_* environment variables describe the alert
+	URL      string // webhook: URL receiving the alert as JSON in a POST request
+	Timeout  string // command and webhook: time allowed to the action (default: 1m)
+	Resolved bool   // Also run the action when the alert resolves
+
+	timeout time.Duration
+}
+
+// Alert state change of a rule on a series
+type Alert struct {
+	Time       time.Time
+	Rule       string
+	State      AlertState
+	Metric     string
+	Properties map[string]string `json:",omitempty"`
+	Value      float64
+	Threshold  float64
+	Since      time.Time // Time the condition started to hold
+
+	rule int
+}
+
+// AlertEngine evaluate the alert rules on every sample and run their actions
+type AlertEngine struct {
+	rules  []AlertRule
+	out    io.Writer // Alerts and errors of the actions
+	series map[string]*alertSeries
+	ids    []string
+	lock   sync.Mutex
+
+	// Series not updated for this long are dropped, their firing alerts resolve
+	SeriesExpiry time.Duration
+}
+
+// alertSeries state of a rule on a series
+type alertSeries struct {
+	rule    int
+	metric  Metric
+	state   AlertState
+	since   time.Time
+	updated time.Time // Time of the last value
+	value   float64
+	points  []Point // Points in the rule window
+}
+
+// LoadAlertRules read the alert rules of a JSON file
+func LoadAlertRules(path string) ([]AlertRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAlertRules(data)
+}
+
+// ParseAlertRules parse and validate a JSON list of alert rules
+func ParseAlertRules(data []byte) ([]AlertRule, error) {
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("Rule %q: %v", rules[i].Name, err)
+		}
+	}
+	return rules, nil
+}
+
+func (rule *AlertRule) validate() error {
+	if rule.Metric == "" {
+		return fmt.Errorf("Missing metric")
+	}
+	if rule.Name == "" {
+		rule.Name = rule.Metric
+	}
+	switch rule.Operator {
+	case ">", ">=":
+		if rule.Clear != nil && *rule.Clear > rule.Threshold {
+			return fmt.Errorf("Clear must be below the threshold")
+		}
+	case "<", "<=":
+		if rule.Clear != nil && *rule.Clear < rule.Threshold {
+			return fmt.Errorf("Clear must be above the threshold")
+		}
+	default:
+		return fmt.Errorf("Invalid operator %q, expected >, >=, < or <=", rule.Operator)
+	}
+	var err error
+	if rule.forDuration, err = parseOptionalDuration(rule.For); err != nil {
+		return fmt.Errorf("Invalid for: %v", err)
+	}
+	if rule.windowDuration, err = parseOptionalDuration(rule.Window); err != nil {
+		return fmt.Errorf("Invalid window: %v", err)
+	}
+	for i := range rule.Actions {
+		if err := rule.Actions[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (action *AlertAction) validate() error {
+	switch action.Type {
+	case "file":
+		if action.Path == "" {
+			return fmt.Errorf("Missing path of the file action")
+		}
+	case "event":
+	case "command":
+		if action.Command == "" {
+			return fmt.Errorf("Missing command of the command action")
+		}
+	case "webhook":
+		if action.URL == "" {
+			return fmt.Errorf("Missing URL of the webhook action")
+		}
+	default:
+		return fmt.Errorf("Invalid action %q, expected file, event, command or webhook", action.Type)
+	}
+	action.timeout = DefaultAlertActionTimeout
+	if action.Timeout != "" {
+		timeout, err := time.ParseDuration(action.Timeout)
+		if err != nil {
+			return fmt.Errorf("Invalid timeout of the %s action: %v", action.Type, err)
+		}
+		action.timeout = timeout
+	}
+	return nil
+}
+
+func parseOptionalDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// breached returns true if the value is past the given threshold
+func (rule AlertRule) breached(value float64, threshold float64) bool {
+	switch rule.Operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	default:
+		return value <= threshold
+	}
+}
+
+func (rule AlertRule) clearThreshold() float64 {
+	if rule.Clear != nil {
+		return *rule.Clear
+	}
+	return rule.Threshold
+}
+
+func (rule AlertRule) matches(metric Metric) bool {
+	if !strings.EqualFold(rule.Metric, metric.Name) {
+		return false
+	}
+	for key, value := range rule.Properties {
+		if metric.Properties[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// NewAlertEngine Create an engine evaluating the given validated rules. The alerts and the errors of their actions are
+// printed to out
+func NewAlertEngine(rules []AlertRule, out io.Writer) *AlertEngine {
+	return &AlertEngine{rules: rules, out: out, series: make(map[string]*alertSeries), SeriesExpiry: DefaultAlertSeriesExpiry}
+}
+
+// Evaluate update the state of the rules with a sample and returns the alerts which fired or resolved.
+// Series missing from the sample, e.g. collected at a longer interval, keep their state until they expire
+func (engine *AlertEngine) Evaluate(now time.Time, stats NodeStats) []Alert {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	var alerts []Alert
+	metrics := FlattenStats(stats)
+	for i, rule := range engine.rules {
+		for _, metric := range metrics {
+			if !rule.matches(metric) {
+				continue
+			}
+			id := strconv.Itoa(i) + "/" + metric.ID()
+			series, ok := engine.series[id]
+			if !ok {
+				series = &alertSeries{rule: i, state: AlertOK}
+				engine.series[id] = series
+				engine.ids = append(engine.ids, id)
+			}
+			series.metric = metric
+			series.updated = now
+			series.add(now, metric.Value, rule.windowDuration)
+			if alert := series.update(rule, i, now); alert != nil {
+				alerts = append(alerts, *alert)
+			}
+		}
+	}
+	return append(alerts, engine.expire(now)...)
+}
+
+// expire drop the series which weren't updated for SeriesExpiry and resolve their firing alerts
+func (engine *AlertEngine) expire(now time.Time) []Alert {
+	var alerts []Alert
+	ids := engine.ids[:0]
+	for _, id := range engine.ids {
+		series := engine.series[id]
+		if now.Sub(series.updated) <= engine.SeriesExpiry {
+			ids = append(ids, id)
+			continue
+		}
+		if series.state == AlertFiring {
+			alerts = append(alerts, *series.alert(engine.rules[series.rule], series.rule, now, AlertResolved))
+		}
+		delete(engine.series, id)
+	}
+	engine.ids = ids
+	return alerts
+}
+
+// add record a value and compute the value compared to the thresholds
+func (series *alertSeries) add(now time.Time, value float64, window time.Duration) {
+	if window == 0 {
+		series.value = value
+		return
+	}
+	series.points = append(series.points, Point{Time: now, Value: value})
+	for len(series.points) > 1 && now.Sub(series.points[0].Time) > window {
+		series.points = series.points[1:]
+	}
+	sum := 0.0
+	for _, point := range series.points {
+		sum += point.Value
+	}
+	series.value = sum / float64(len(series.points))
+}
+
+func (series *alertSeries) update(rule AlertRule, ruleIndex int, now time.Time) *Alert {
+	switch series.state {
+	case AlertFiring:
+		// Hysteresis, only resolve once the value is back past the clear threshold
+		if !rule.breached(series.value, rule.clearThreshold()) {
+			series.state = AlertOK
+			return series.alert(rule, ruleIndex, now, AlertResolved)
+		}
+	default:
+		if !rule.breached(series.value, rule.Threshold) {
+			series.state = AlertOK
+			return nil
+		}
+		if series.state == AlertOK {
+			series.state = AlertPending
+			series.since = now
+		}
+		if now.Sub(series.since) >= rule.forDuration {
+			series.state = AlertFiring
+			return series.alert(rule, ruleIndex, now, AlertFiring)
+		}
+	}
+	return nil
+}
+
+func (series *alertSeries) alert(rule AlertRule, ruleIndex int, now time.Time, state AlertState) *Alert {
+	return &Alert{
+		Time:       now,
+		Rule:       rule.Name,
+		State:      state,
+		Metric:     series.metric.Name,
+		Properties: series.metric.Properties,
+		Value:      series.value,
+		Threshold:  rule.Threshold,
+		Since:      series.since,
+		rule:       ruleIndex,
+	}
+}
+
+// Active alerts pending or firing
+func (engine *AlertEngine) Active() []Alert {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+	alerts := []Alert{}
+	for _, id := range engine.ids {
+		series := engine.series[id]
+		if series.state != AlertOK {
+			alerts = append(alerts, *series.alert(engine.rules[series.rule], series.rule, series.updated, series.state))
+		}
+	}
+	return alerts
+}
+
+// Process evaluate the rules on a sample and run the actions of the alerts. Alerts with an event action are added to the sample events
+func (engine *AlertEngine) Process(now time.Time, stats *NodeStats) {
+	for _, alert := range engine.Evaluate(now, *stats) {
+		fmt.Fprintln(engine.out, alert)
+		for _, action := range engine.rules[alert.rule].Actions {
+			if alert.State == AlertResolved && !action.Resolved {
+				continue
+			}
+			switch action.Type {
+			case "file":
+				if err := appendAlert(action.Path, alert); err != nil {
+					fmt.Fprintln(engine.out, "Error while writing the alert to", action.Path, err)
+				}
+			case "event":
+				stats.Events = append(stats.Events, alert.Event())
+			case "command":
+				go runAlertCommand(engine.out, action, alert)
+			case "webhook":
+				go postAlert(engine.out, action, alert)
+			}
+		}
+	}
+}
+
+// HandleAlerts serve the pending and firing alerts
+func (engine *AlertEngine) HandleAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, engine.Active())
+}
+
+func (alert Alert) String() string {
+	return fmt.Sprintf("Alert %s %s: %s%s = %g, threshold %g", alert.Rule, alert.State, alert.Metric, formatProperties(alert.Properties), alert.Value, alert.Threshold)
+}
+
+// Event event reporting the alert to the sinks
+func (alert Alert) Event() events.Event {
+	properties := map[string]string{
+		"Rule":      alert.Rule,
+		"Metric":    alert.Metric,
+		"Value":     strconv.FormatFloat(alert.Value, 'g', -1, 64),
+		"Threshold": strconv.FormatFloat(alert.Threshold, 'g', -1, 64),
+	}
+	for key, value := range alert.Properties {
+		properties[key] = value
+	}
+	return events.Event{
+		Time:       alert.Time,
+		Type:       events.Type("Alert " + string(alert.State)),
+		Message:    alert.String(),
+		Count:      1,
+		Properties: properties,
+	}
+}
+
+// formatProperties sorted properties of a metric. e.g. {Disk=/mnt}
+func formatProperties(properties map[string]string) string {
+	if len(properties) == 0 {
+		return ""
+	}
+	var pairs []string
+	for key, value := range properties {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func appendAlert(path string, alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+func runAlertCommand(out io.Writer, action AlertAction, alert Alert) {
+	ctx, cancel := context.WithTimeout(context.Background(), action.timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", action.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", action.Command)
+	}
+	cmd.Env = append(os.Environ(),
+		"ALERT_RULE="+alert.Rule,
+		"ALERT_STATE="+string(alert.State),
+		"ALERT_METRIC="+alert.Metric,
+		"ALERT_PROPERTIES="+formatProperties(alert.Properties),
+		"ALERT_VALUE="+strconv.FormatFloat(alert.Value, 'g', -1, 64),
+		"ALERT_THRESHOLD="+strconv.FormatFloat(alert.Threshold, 'g', -1, 64),
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(out, "Alert command of %s failed: %v\n%s", alert.Rule, err, output)
+	}
+}
+
+func postAlert(out io.Writer, action AlertAction, alert Alert) {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		fmt.Fprintln(out, "Error while encoding the alert", err)
+		return
+	}
+	client := http.Client{Timeout: action.timeout}
+	response, err := client.Post(action.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(out, "Alert webhook of %s failed: %v\n", alert.Rule, err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		fmt.Fprintf(out, "Alert webhook of %s failed: %s\n", alert.Rule, response.Status)
+	}
+}
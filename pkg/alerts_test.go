@@ -0,0 +1,122 @@
+package batchinsights_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/stretchr/testify/assert"
+)
+
+func memoryStats(usedPercent float64) batchinsights.NodeStats {
+	return batchinsights.NodeStats{Memory: &mem.VirtualMemoryStat{Total: 1000, Used: uint64(usedPercent * 10), UsedPercent: usedPercent}}
+}
+
+func TestAlertHysteresis(t *testing.T) {
+	rules, err := batchinsights.ParseAlertRules([]byte(`[
+		{"name": "memory", "metric": "Memory used percent", "operator": ">", "threshold": 95, "clear": 90, "for": "2m"}
+	]`))
+	assert.Nil(t, err)
+	engine := batchinsights.NewAlertEngine(rules, ioutil.Discard)
+	start := time.Now()
+
+	assert.Equal(t, 0, len(engine.Evaluate(start, memoryStats(96))))
+	assert.Equal(t, batchinsights.AlertPending, engine.Active()[0].State)
+	// The condition must hold for 2m
+	assert.Equal(t, 0, len(engine.Evaluate(start.Add(time.Minute), memoryStats(50))))
+	assert.Equal(t, 0, len(engine.Active()))
+	assert.Equal(t, 0, len(engine.Evaluate(start.Add(2*time.Minute), memoryStats(97))))
+
+	alerts := engine.Evaluate(start.Add(4*time.Minute), memoryStats(98))
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, batchinsights.AlertFiring, alerts[0].State)
+	assert.Equal(t, 98.0, alerts[0].Value)
+
+	// Still firing until the memory usage gets below 90%
+	assert.Equal(t, 0, len(engine.Evaluate(start.Add(5*time.Minute), memoryStats(92))))
+	alerts = engine.Evaluate(start.Add(6*time.Minute), memoryStats(85))
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, batchinsights.AlertResolved, alerts[0].State)
+}
+
+func TestAlertActions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alerts")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "alerts.json")
+
+	rules, err := batchinsights.ParseAlertRules([]byte(`[{
+		"name": "mnt-full", "metric": "Disk free", "properties": {"Disk": "/mnt"}, "operator": "<", "threshold": 5000000000,
+		"actions": [{"type": "event"}, {"type": "file", "path": "` + filepath.ToSlash(path) + `", "resolved": true}]
+	}]`))
+	assert.Nil(t, err)
+	var out bytes.Buffer
+	engine := batchinsights.NewAlertEngine(rules, &out)
+
+	stats := batchinsights.NodeStats{DiskUsage: []*disk.UsageStat{
+		{Path: "/", Free: 1000000000},
+		{Path: "/mnt", Free: 4000000000},
+	}}
+	engine.Process(time.Now(), &stats)
+	assert.Equal(t, 1, len(stats.Events))
+	assert.Equal(t, "Alert firing", string(stats.Events[0].Type))
+	assert.Equal(t, "/mnt", stats.Events[0].Properties["Disk"])
+
+	stats = batchinsights.NodeStats{DiskUsage: []*disk.UsageStat{{Path: "/mnt", Free: 8000000000}}}
+	engine.Process(time.Now(), &stats)
+	// The event action doesn't run on resolve
+	assert.Equal(t, 0, len(stats.Events))
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0], `"State":"firing"`)
+	assert.Contains(t, lines[1], `"State":"resolved"`)
+
+	assert.Equal(t, "Alert mnt-full firing: Disk free{Disk=/mnt} = 4e+09, threshold 5e+09\n"+
+		"Alert mnt-full resolved: Disk free{Disk=/mnt} = 8e+09, threshold 5e+09\n", out.String())
+}
+
+func TestAlertSeriesExpiry(t *testing.T) {
+	rules, err := batchinsights.ParseAlertRules([]byte(`[{"name": "full", "metric": "Disk free", "operator": "<", "threshold": 5000000000}]`))
+	assert.Nil(t, err)
+	engine := batchinsights.NewAlertEngine(rules, ioutil.Discard)
+	engine.SeriesExpiry = time.Minute
+	start := time.Now()
+
+	alerts := engine.Evaluate(start, batchinsights.NodeStats{DiskUsage: []*disk.UsageStat{
+		{Path: "/", Free: 8000000000},
+		{Path: "/mnt", Free: 4000000000},
+	}})
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, batchinsights.AlertFiring, alerts[0].State)
+
+	// /mnt is unmounted
+	root := batchinsights.NodeStats{DiskUsage: []*disk.UsageStat{{Path: "/", Free: 8000000000}}}
+	assert.Equal(t, 0, len(engine.Evaluate(start.Add(time.Minute), root)))
+	assert.Equal(t, 1, len(engine.Active()))
+
+	alerts = engine.Evaluate(start.Add(2*time.Minute), root)
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, batchinsights.AlertResolved, alerts[0].State)
+	assert.Equal(t, "/mnt", alerts[0].Properties["Disk"])
+	assert.Equal(t, 0, len(engine.Active()))
+	assert.Equal(t, 0, len(engine.Evaluate(start.Add(3*time.Minute), root)))
+}
+
+func TestInvalidAlertRules(t *testing.T) {
+	_, err := batchinsights.ParseAlertRules([]byte(`[{"metric": "Gpu temperature", "operator": "=", "threshold": 85}]`))
+	assert.NotNil(t, err)
+	_, err = batchinsights.ParseAlertRules([]byte(`[{"metric": "Gpu temperature", "operator": ">", "threshold": 85, "clear": 90}]`))
+	assert.NotNil(t, err)
+	_, err = batchinsights.ParseAlertRules([]byte(`[{"metric": "Gpu temperature", "operator": ">", "threshold": 85, "actions": [{"type": "email"}]}]`))
+	assert.NotNil(t, err)
+}
@@ -3,9 +3,13 @@ package batchinsights
 import (
 	"bytes"
 	"fmt"
+	"runtime"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/Azure/batch-insights/nvml"
+	"github.com/Azure/batch-insights/pkg/events"
 	"github.com/Microsoft/ApplicationInsights-Go/appinsights"
 )
 
@@ -15,6 +19,8 @@ type AppInsightsService struct {
 	aggregation              time.Duration
 	aggregateCollectionStart *time.Time
 	aggregates               map[string]*appinsights.AggregateMetricTelemetry
+	transmissions            *TransmissionTracker
+	listener                 appinsights.DiagnosticsMessageListener
 }
 
 // NewAppInsightsService create a new instance of the AppInsightsService
@@ -22,23 +28,64 @@ func NewAppInsightsService(instrumentationKey string, poolID string, nodeID stri
 	client := appinsights.NewTelemetryClient(instrumentationKey)
 	client.Context().Tags.Cloud().SetRole(poolID)
 	client.Context().Tags.Cloud().SetRoleInstance(nodeID)
+	return NewAppInsightsServiceWithClient(client, aggregation)
+}
 
+// NewAppInsightsServiceWithClient create a new instance of the AppInsightsService uploading through the given client
+func NewAppInsightsServiceWithClient(client appinsights.TelemetryClient, aggregation time.Duration) AppInsightsService {
+	transmissions := NewTransmissionTracker("appInsights")
 	return AppInsightsService{
-		client:      client,
-		aggregation: aggregation,
-		aggregates:  make(map[string]*appinsights.AggregateMetricTelemetry),
+		client:        client,
+		aggregation:   aggregation,
+		aggregates:    make(map[string]*appinsights.AggregateMetricTelemetry),
+		transmissions: transmissions,
+		listener:      appinsights.NewDiagnosticsMessageListener(transmissions.Handle),
+	}
+}
+
+// Name name of the sink
+func (service *AppInsightsService) Name() string {
+	return "appInsights"
+}
+
+// Write aggregate the sample, metrics are uploaded at the end of the aggregation interval
+func (service *AppInsightsService) Write(now time.Time, stats NodeStats) error {
+	service.UploadStats(now, stats)
+	return nil
+}
+
+// Stats delivery counters of the telemetry items
+func (service *AppInsightsService) Stats() SinkStats {
+	return service.transmissions.Stats()
+}
+
+// Close upload the pending telemetry
+func (service *AppInsightsService) Close() {
+	for _, aggregate := range service.aggregates {
+		service.send(aggregate)
+	}
+	service.aggregates = make(map[string]*appinsights.AggregateMetricTelemetry)
+	select {
+	case <-service.client.Channel().Close(10 * time.Second):
+	case <-time.After(30 * time.Second):
 	}
+	service.listener.Remove()
 }
 
-func (service *AppInsightsService) track(metric *appinsights.MetricTelemetry) {
-	t := time.Now()
+func (service *AppInsightsService) send(telemetry appinsights.Telemetry) {
+	service.transmissions.queued(1)
+	service.client.Track(telemetry)
+}
 
+// track add the metric of a sample taken at the given time to its aggregate. The aggregation windows follow the
+// sample time so replayed samples are aggregated as they were recorded
+func (service *AppInsightsService) track(t time.Time, metric *appinsights.MetricTelemetry) {
 	if service.aggregateCollectionStart != nil {
 		elapsed := t.Sub(*service.aggregateCollectionStart)
 
 		if elapsed > service.aggregation {
 			for _, aggregate := range service.aggregates {
-				service.client.Track(aggregate)
+				service.send(aggregate)
 			}
 			service.aggregates = make(map[string]*appinsights.AggregateMetricTelemetry)
 			service.aggregateCollectionStart = &t
@@ -52,58 +99,215 @@ func (service *AppInsightsService) track(metric *appinsights.MetricTelemetry) {
 	aggregate, ok := service.aggregates[id]
 	if !ok {
 		aggregate = appinsights.NewAggregateMetricTelemetry(metric.Name)
+		aggregate.Timestamp = *service.aggregateCollectionStart
 		aggregate.Properties = metric.Properties
 		service.aggregates[id] = aggregate
 	}
 	aggregate.AddData([]float64{metric.Value})
 }
 
-// UploadStats will register the given stats for upload. They will be first aggregated during the given aggregation interval
-func (service *AppInsightsService) UploadStats(stats NodeStats) {
-	client := service.client
+// UploadStats will register the given stats, taken at the given time, for upload. They will be first aggregated during the given aggregation interval
+func (service *AppInsightsService) UploadStats(now time.Time, stats NodeStats) {
+	writeMetrics(stats, func(metric *appinsights.MetricTelemetry) {
+		service.track(now, metric)
+	})
+
+	for _, event := range stats.Events {
+		service.trackEvent(event)
+	}
+
+	service.client.Channel().Flush()
+}
+
+// metricWriter receive the metrics computed from a sample
+type metricWriter func(metric *appinsights.MetricTelemetry)
+
+// Metric single value of a sample as uploaded to Application Insights
+type Metric struct {
+	Name       string
+	Value      float64
+	Properties map[string]string `json:",omitempty"`
+}
+
+// ID identify the series of the metric, like GetMetricID
+func (metric Metric) ID() string {
+	return fmt.Sprintf("%s/%s", metric.Name, createKeyValuePairs(metric.Properties))
+}
+
+// FlattenStats list the metrics of a sample
+func FlattenStats(stats NodeStats) []Metric {
+	var metrics []Metric
+	writeMetrics(stats, func(metric *appinsights.MetricTelemetry) {
+		metrics = append(metrics, Metric{Name: metric.Name, Value: metric.Value, Properties: metric.Properties})
+	})
+	return metrics
+}
+
+func (write metricWriter) track(metric *appinsights.MetricTelemetry) {
+	write(metric)
+}
+
+// writeMetrics compute the metrics of a sample
+func writeMetrics(stats NodeStats, write metricWriter) {
 
 	for cpuN, percent := range stats.CPUPercents {
 		metric := appinsights.NewMetricTelemetry("Cpu usage", percent)
 		metric.Properties["CPU #"] = strconv.Itoa(cpuN)
 		metric.Properties["Core count"] = strconv.Itoa(len(stats.CPUPercents))
-		service.track(metric)
+		write.track(metric)
+	}
+
+	if stats.CPUFrequency != nil {
+		for _, core := range stats.CPUFrequency.Cores {
+			properties := map[string]string{"CPU #": strconv.Itoa(core.CPU)}
+			write.trackWithProperties("Cpu frequency", core.CurrentMHz, properties)
+			if core.MaxMHz > 0 {
+				write.trackWithProperties("Cpu frequency percent", core.PercentOfMax(), properties)
+			}
+			write.trackWithProperties("Cpu core throttles", float64(core.CoreThrottles), properties)
+			write.trackWithProperties("Cpu package throttles", float64(core.PackageThrottles), properties)
+		}
+		for _, zone := range stats.CPUFrequency.Thermal {
+			write.trackWithProperties("Thermal zone temperature", zone.Temperature, map[string]string{"Zone": zone.Zone, "Type": zone.Type})
+		}
+	}
+
+	if stats.CPUTimes != nil {
+		for _, mode := range stats.CPUTimes.Total.Modes() {
+			metric := appinsights.NewMetricTelemetry("Cpu time", mode.Percent)
+			metric.Properties["Mode"] = mode.Mode
+			write.track(metric)
+		}
+		for cpuN, times := range stats.CPUTimes.PerCPU {
+			for _, mode := range times.Modes() {
+				metric := appinsights.NewMetricTelemetry("Cpu core time", mode.Percent)
+				metric.Properties["Mode"] = mode.Mode
+				metric.Properties["CPU #"] = strconv.Itoa(cpuN)
+				write.track(metric)
+			}
+		}
+	}
+
+	if stats.Load != nil {
+		write.track(appinsights.NewMetricTelemetry("Load average 1m", stats.Load.Load1))
+		write.track(appinsights.NewMetricTelemetry("Load average 5m", stats.Load.Load5))
+		write.track(appinsights.NewMetricTelemetry("Load average 15m", stats.Load.Load15))
+		write.track(appinsights.NewMetricTelemetry("Processes running", float64(stats.Load.ProcsRunning)))
+		write.track(appinsights.NewMetricTelemetry("Processes blocked", float64(stats.Load.ProcsBlocked)))
+
+		for _, pressure := range stats.Load.Pressure {
+			avgMetric := appinsights.NewMetricTelemetry("Pressure avg10", pressure.Avg10)
+			avgMetric.Properties["Resource"] = pressure.Resource
+			avgMetric.Properties["Kind"] = pressure.Kind
+			write.track(avgMetric)
+
+			avg60Metric := appinsights.NewMetricTelemetry("Pressure avg60", pressure.Avg60)
+			avg60Metric.Properties["Resource"] = pressure.Resource
+			avg60Metric.Properties["Kind"] = pressure.Kind
+			write.track(avg60Metric)
+
+			avg300Metric := appinsights.NewMetricTelemetry("Pressure avg300", pressure.Avg300)
+			avg300Metric.Properties["Resource"] = pressure.Resource
+			avg300Metric.Properties["Kind"] = pressure.Kind
+			write.track(avg300Metric)
+
+			stallMetric := appinsights.NewMetricTelemetry("Pressure stall percent", pressure.StallPercent)
+			stallMetric.Properties["Resource"] = pressure.Resource
+			stallMetric.Properties["Kind"] = pressure.Kind
+			write.track(stallMetric)
+		}
 	}
 
 	for _, usage := range stats.DiskUsage {
 		usedMetric := appinsights.NewMetricTelemetry("Disk usage", float64(usage.Used))
 		usedMetric.Properties["Disk"] = usage.Path
-		service.track(usedMetric)
+		write.track(usedMetric)
 		freeMetric := appinsights.NewMetricTelemetry("Disk free", float64(usage.Free))
 		freeMetric.Properties["Disk"] = usage.Path
-		service.track(freeMetric)
+		write.track(freeMetric)
+		percentMetric := appinsights.NewMetricTelemetry("Disk usage percent", usage.UsedPercent)
+		percentMetric.Properties["Disk"] = usage.Path
+		write.track(percentMetric)
 	}
 
 	if stats.Memory != nil {
-		service.track(appinsights.NewMetricTelemetry("Memory used", float64(stats.Memory.Used)))
-		service.track(appinsights.NewMetricTelemetry("Memory available", float64(stats.Memory.Total-stats.Memory.Used)))
+		write.track(appinsights.NewMetricTelemetry("Memory used", float64(stats.Memory.Used)))
+		// Kept as Total-Used as this is what Batch Explorer expect. "Memory available (kernel)" is the real available memory.
+		write.track(appinsights.NewMetricTelemetry("Memory available", float64(stats.Memory.Total-stats.Memory.Used)))
+		write.track(appinsights.NewMetricTelemetry("Memory available (kernel)", float64(stats.Memory.Available)))
+		write.track(appinsights.NewMetricTelemetry("Memory used percent", stats.Memory.UsedPercent))
+
+		if runtime.GOOS == "linux" {
+			write.track(appinsights.NewMetricTelemetry("Memory buffers", float64(stats.Memory.Buffers)))
+			write.track(appinsights.NewMetricTelemetry("Memory cached", float64(stats.Memory.Cached)))
+			write.track(appinsights.NewMetricTelemetry("Memory shared", float64(stats.Memory.Shared)))
+			write.track(appinsights.NewMetricTelemetry("Memory slab", float64(stats.Memory.Slab)))
+			write.track(appinsights.NewMetricTelemetry("Memory dirty", float64(stats.Memory.Dirty)))
+			write.track(appinsights.NewMetricTelemetry("Memory writeback", float64(stats.Memory.Writeback)))
+			if stats.Memory.HugePagesTotal > 0 {
+				write.track(appinsights.NewMetricTelemetry("Memory hugepages total", float64(stats.Memory.HugePagesTotal*stats.Memory.HugePageSize)))
+				write.track(appinsights.NewMetricTelemetry("Memory hugepages free", float64(stats.Memory.HugePagesFree*stats.Memory.HugePageSize)))
+			}
+		}
+	}
+
+	if stats.Swap != nil {
+		write.track(appinsights.NewMetricTelemetry("Swap used", float64(stats.Swap.Used)))
+		write.track(appinsights.NewMetricTelemetry("Swap total", float64(stats.Swap.Total)))
+	}
+
+	if stats.Paging != nil {
+		write.track(appinsights.NewMetricTelemetry("Swap in", float64(stats.Paging.SwapInBps)))
+		write.track(appinsights.NewMetricTelemetry("Swap out", float64(stats.Paging.SwapOutBps)))
+		write.track(appinsights.NewMetricTelemetry("Page faults", stats.Paging.PageFaultsPerSec))
+		write.track(appinsights.NewMetricTelemetry("Major page faults", stats.Paging.MajorPageFaultsPerSec))
 	}
 	if stats.DiskIO != nil {
-		service.track(appinsights.NewMetricTelemetry("Disk read", float64(stats.DiskIO.ReadBps)))
-		service.track(appinsights.NewMetricTelemetry("Disk write", float64(stats.DiskIO.WriteBps)))
+		write.track(appinsights.NewMetricTelemetry("Disk read", float64(stats.DiskIO.ReadBps)))
+		write.track(appinsights.NewMetricTelemetry("Disk write", float64(stats.DiskIO.WriteBps)))
 	}
 
 	if stats.NetIO != nil {
-		service.track(appinsights.NewMetricTelemetry("Network read", float64(stats.NetIO.ReadBps)))
-		service.track(appinsights.NewMetricTelemetry("Network write", float64(stats.NetIO.WriteBps)))
+		write.track(appinsights.NewMetricTelemetry("Network read", float64(stats.NetIO.ReadBps)))
+		write.track(appinsights.NewMetricTelemetry("Network write", float64(stats.NetIO.WriteBps)))
+	}
+
+	if stats.Netstat != nil {
+		for _, connections := range stats.Netstat.TCPConnections {
+			write.trackWithProperties("TCP connections", float64(connections.Count), map[string]string{"State": connections.State})
+		}
+		write.track(appinsights.NewMetricTelemetry("TCP listening sockets", float64(stats.Netstat.Listening())))
+		write.track(appinsights.NewMetricTelemetry("TCP retransmits", stats.Netstat.TCPRetransmitsPerSec))
+		write.track(appinsights.NewMetricTelemetry("TCP retransmit percent", stats.Netstat.TCPRetransmitPercent))
+		write.track(appinsights.NewMetricTelemetry("TCP resets", stats.Netstat.TCPResetsPerSec))
+		write.track(appinsights.NewMetricTelemetry("TCP established resets", stats.Netstat.TCPEstablishedResetsPerSec))
+		write.track(appinsights.NewMetricTelemetry("TCP failed connection attempts", stats.Netstat.TCPAttemptFailsPerSec))
+		write.track(appinsights.NewMetricTelemetry("TCP listen overflows", stats.Netstat.TCPListenOverflowsPerSec))
+		write.track(appinsights.NewMetricTelemetry("UDP receive errors", stats.Netstat.UDPReceiveErrorsPerSec))
+		write.track(appinsights.NewMetricTelemetry("UDP receive buffer errors", stats.Netstat.UDPReceiveBufferErrorsPerSec))
+		write.track(appinsights.NewMetricTelemetry("UDP no ports", stats.Netstat.UDPNoPortsPerSec))
 	}
 
 	if len(stats.Gpus) > 0 {
-		for cpuN, usage := range stats.Gpus {
-			gpuMetric := appinsights.NewMetricTelemetry("Gpu usage", usage.GPU)
-			gpuMetric.Properties["GPU #"] = strconv.Itoa(cpuN)
-			service.track(gpuMetric)
+		for _, usage := range stats.Gpus {
+			properties := gpuProperties(usage.Index, usage.GPUIdentity)
+			write.trackWithProperties("Gpu usage", usage.GPU, properties)
+			write.trackWithProperties("Gpu memory usage", usage.Memory, properties)
+			write.trackWithProperties("Gpu memory used", float64(usage.MemoryUsed), properties)
+			write.trackWithProperties("Gpu memory total", float64(usage.MemoryTotal), properties)
 
-			gpuMemoryMetric := appinsights.NewMetricTelemetry("Gpu memory usage", usage.Memory)
-			gpuMemoryMetric.Properties["GPU #"] = strconv.Itoa(cpuN)
-			service.track(gpuMemoryMetric)
+			write.trackGPUDetails(properties, usage)
+			write.trackGPUProcesses(properties, usage.Processes)
 		}
 	}
 
+	for _, health := range stats.GPUHealth {
+		properties := gpuProperties(health.Index, health.GPUIdentity)
+		write.trackWithProperties("Gpu healthy", boolToFloat(health.State == GPUHealthy), properties)
+		write.trackWithProperties("Gpu lost", boolToFloat(health.State == GPULost), properties)
+		write.trackWithProperties("Gpu errors", float64(health.Errors), properties)
+	}
+
 	if len(stats.Processes) > 0 {
 		for _, processStats := range stats.Processes {
 
@@ -113,38 +317,231 @@ func (service *AppInsightsService) UploadStats(stats NodeStats) {
 				cpuMetric := appinsights.NewMetricTelemetry("Process CPU", processStats.cpu)
 				cpuMetric.Properties["Process Name"] = processStats.name
 				cpuMetric.Properties["PID"] = pidStr
-				service.track(cpuMetric)
+				write.track(cpuMetric)
 			}
 
 			{
 				memMetric := appinsights.NewMetricTelemetry("Process Memory", float64(processStats.memory))
 				memMetric.Properties["Process Name"] = processStats.name
 				memMetric.Properties["PID"] = pidStr
-				service.track(memMetric)
+				write.track(memMetric)
 			}
 
+			if processStats.gpuMemory > 0 {
+				gpuMemMetric := appinsights.NewMetricTelemetry("Process GPU Memory", float64(processStats.gpuMemory))
+				gpuMemMetric.Properties["Process Name"] = processStats.name
+				gpuMemMetric.Properties["PID"] = pidStr
+				write.track(gpuMemMetric)
+
+				gpuMetric := appinsights.NewMetricTelemetry("Process GPU", processStats.gpuUsage)
+				gpuMetric.Properties["Process Name"] = processStats.name
+				gpuMetric.Properties["PID"] = pidStr
+				write.track(gpuMetric)
+			}
+
+		}
+	}
+
+	for _, container := range stats.Containers {
+		properties := map[string]string{"Container ID": container.ID}
+		if container.Name != "" {
+			properties["Container Name"] = container.Name
+		}
+
+		write.trackWithProperties("Container CPU", container.CPUPercent, properties)
+		write.trackWithProperties("Container throttled", container.ThrottledPercent, properties)
+		write.trackWithProperties("Container throttled time", container.ThrottledTimeMsps, properties)
+		write.trackWithProperties("Container memory", float64(container.MemoryCurrent), properties)
+		if container.MemoryMax > 0 {
+			write.trackWithProperties("Container memory limit", float64(container.MemoryMax), properties)
+		}
+		write.trackWithProperties("Container OOM kills", float64(container.NewOOMKills), properties)
+		write.trackWithProperties("Container disk read", float64(container.IOReadBps), properties)
+		write.trackWithProperties("Container disk write", float64(container.IOWriteBps), properties)
+		write.trackWithProperties("Container disk read ops", container.IOReadOpsPerSec, properties)
+		write.trackWithProperties("Container disk write ops", container.IOWriteOpsPerSec, properties)
+	}
+
+	for _, port := range stats.InfiniBand {
+		properties := map[string]string{"HCA": port.HCA, "Port": port.Port}
+		write.trackWithProperties("InfiniBand transmit", float64(port.TransmitBps), properties)
+		write.trackWithProperties("InfiniBand receive", float64(port.ReceiveBps), properties)
+		write.trackWithProperties("InfiniBand transmit packets", port.TransmitPacketsPerSec, properties)
+		write.trackWithProperties("InfiniBand receive packets", port.ReceivePacketsPerSec, properties)
+		write.trackWithProperties("InfiniBand symbol errors", float64(port.SymbolErrors), properties)
+		write.trackWithProperties("InfiniBand link error recoveries", float64(port.LinkErrorRecoveries), properties)
+		write.trackWithProperties("InfiniBand link downed", float64(port.LinkDowned), properties)
+		write.trackWithProperties("InfiniBand receive errors", float64(port.ReceiveErrors), properties)
+		write.trackWithProperties("InfiniBand link active", boolToFloat(port.Active), properties)
+		write.trackWithProperties("InfiniBand rate", port.RateGbps, properties)
+	}
+
+	for _, mount := range stats.NFS {
+		properties := map[string]string{"Mount": mount.MountPoint, "Server": mount.Device}
+		write.trackWithProperties("NFS read", float64(mount.ReadBps), properties)
+		write.trackWithProperties("NFS write", float64(mount.WriteBps), properties)
+		write.trackWithProperties("NFS ops", mount.OpsPerSec, properties)
+		write.trackWithProperties("NFS retransmissions", float64(mount.Retransmissions), properties)
+		for _, operation := range mount.Operations {
+			operationProperties := map[string]string{"Operation": operation.Name}
+			for key, value := range properties {
+				operationProperties[key] = value
+			}
+			write.trackWithProperties("NFS operation ops", operation.OpsPerSec, operationProperties)
+			write.trackWithProperties("NFS operation RTT", operation.RTTMs, operationProperties)
+			write.trackWithProperties("NFS operation execution time", operation.ExecuteMs, operationProperties)
 		}
 	}
 
-	client.Channel().Flush()
+	if stats.Agent != nil {
+		write.track(appinsights.NewMetricTelemetry("Agent cpu usage", stats.Agent.CPUPercent))
+		write.track(appinsights.NewMetricTelemetry("Agent memory", float64(stats.Agent.RSS)))
+		write.track(appinsights.NewMetricTelemetry("Agent goroutines", float64(stats.Agent.Goroutines)))
+		write.track(appinsights.NewMetricTelemetry("Agent uptime", stats.Agent.Uptime.Seconds()))
+		for _, sink := range stats.Agent.Sinks {
+			properties := map[string]string{"Sink": sink.Name}
+			write.trackWithProperties("Telemetry queued", float64(sink.Queued), properties)
+			write.trackWithProperties("Telemetry sent", float64(sink.Sent), properties)
+			write.trackWithProperties("Telemetry failed", float64(sink.Failed), properties)
+			write.trackWithProperties("Telemetry dropped", float64(sink.Dropped), properties)
+			write.trackWithProperties("Telemetry pending", float64(sink.Pending()), properties)
+		}
+	}
+
+	if stats.Idle != nil {
+		metric := appinsights.NewMetricTelemetry("Time idle", stats.Idle.TimeIdle.Seconds())
+		metric.Properties["State"] = string(stats.Idle.State)
+		write.track(metric)
+	}
+
+	for _, status := range stats.Collectors {
+		properties := map[string]string{"Collector": status.Name}
+		write.trackWithProperties("Collector duration", float64(status.Duration)/float64(time.Millisecond), properties)
+		write.trackWithProperties("Collector timeouts", boolToFloat(status.TimedOut || status.Skipped), properties)
+		write.trackWithProperties("Collector errors", boolToFloat(status.Error != ""), properties)
+	}
+
+}
+
+// trackEvent upload an event right away. Events are not aggregated
+func (service *AppInsightsService) trackEvent(event events.Event) {
+	telemetry := appinsights.NewEventTelemetry(string(event.Type))
+	telemetry.Timestamp = event.Time
+	telemetry.Properties["Message"] = event.Message
+	telemetry.Properties["Count"] = strconv.FormatUint(event.Count, 10)
+	for key, value := range event.Properties {
+		telemetry.Properties[key] = value
+	}
+	service.send(telemetry)
+}
+
+// gpuProperties dimensions identifying a GPU. The index alone is not stable across reboots
+func gpuProperties(gpuN uint, identity GPUIdentity) map[string]string {
+	properties := map[string]string{"GPU #": strconv.Itoa(int(gpuN))}
+	if identity.UUID != "" {
+		properties["GPU UUID"] = identity.UUID
+	}
+	if identity.Name != "" {
+		properties["GPU name"] = identity.Name
+	}
+	if identity.PCIBusID != "" {
+		properties["PCI bus ID"] = identity.PCIBusID
+	}
+	return properties
+}
+
+func (write metricWriter) trackGPUDetails(properties map[string]string, usage GPUUsage) {
+	optionalMetrics := []struct {
+		name  string
+		value *float64
+	}{
+		{"Gpu temperature", usage.Temperature},
+		{"Gpu power draw", usage.PowerDraw},
+		{"Gpu power limit", usage.PowerLimit},
+		{"Gpu SM clock", usage.SMClock},
+		{"Gpu memory clock", usage.MemoryClock},
+		{"Gpu PCIe TX", usage.PCIeTXBps},
+		{"Gpu PCIe RX", usage.PCIeRXBps},
+	}
+	for _, metric := range optionalMetrics {
+		if metric.value != nil {
+			write.trackWithProperties(metric.name, *metric.value, properties)
+		}
+	}
+
+	if usage.ThrottleReasons != nil {
+		for _, reason := range nvml.ThrottleReasonNames {
+			value := 0.0
+			if usage.ThrottleReasons.Has(reason.Reason) {
+				value = 1
+			}
+			metric := appinsights.NewMetricTelemetry("Gpu throttle", value)
+			for key, value := range properties {
+				metric.Properties[key] = value
+			}
+			metric.Properties["Reason"] = reason.Name
+			write.track(metric)
+		}
+	}
+
+	if usage.ECCErrors != nil {
+		write.trackWithProperties("Gpu ECC errors corrected", float64(usage.ECCErrors.Corrected), properties)
+		write.trackWithProperties("Gpu ECC errors uncorrected", float64(usage.ECCErrors.Uncorrected), properties)
+	}
+}
+
+func (write metricWriter) trackGPUProcesses(gpuProperties map[string]string, processes []GPUProcessUsage) {
+	for _, process := range processes {
+		properties := map[string]string{
+			"PID":          strconv.FormatInt(int64(process.PID), 10),
+			"Process Name": process.Name,
+			"Watched":      strconv.FormatBool(process.Watched),
+		}
+		for key, value := range gpuProperties {
+			properties[key] = value
+		}
+
+		write.trackWithProperties("Gpu process memory", float64(process.MemoryUsed), properties)
+		if process.SMUtilization != nil {
+			write.trackWithProperties("Gpu process usage", *process.SMUtilization, properties)
+			write.trackWithProperties("Gpu process memory usage", *process.MemoryUtilization, properties)
+		}
+	}
+}
+
+func (write metricWriter) trackWithProperties(name string, value float64, properties map[string]string) {
+	metric := appinsights.NewMetricTelemetry(name, value)
+	for key, value := range properties {
+		metric.Properties[key] = value
+	}
+	write.track(metric)
 }
 
 // GetMetricID compute an group id for this metric so it can be aggregated
 func GetMetricID(metric *appinsights.MetricTelemetry) string {
-	groupBy := createKeyValuePairs(metric.Properties)
-	return fmt.Sprintf("%s/%s", metric.Name, groupBy)
+	return Metric{Name: metric.Name, Properties: metric.Properties}.ID()
 }
 
+// createKeyValuePairs sorted key=value pairs, the same properties always give the same string
 func createKeyValuePairs(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 	b := new(bytes.Buffer)
-	first := true
-	for key, value := range m {
-		if first {
-			first = false
-		} else {
+	for i, key := range keys {
+		if i > 0 {
 			fmt.Fprintf(b, ",")
 		}
-		fmt.Fprintf(b, "%s=%s", key, value)
+		fmt.Fprintf(b, "%s=%s", key, m[key])
 	}
 	return b.String()
 }
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -2,9 +2,11 @@ package batchinsights_test
 
 import (
 	"github.com/Azure/batch-insights/pkg"
+	"github.com/Azure/batch-insights/pkg/events"
 	"github.com/Microsoft/ApplicationInsights-Go/appinsights"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestGetMetricID(t *testing.T) {
@@ -13,8 +15,76 @@ func TestGetMetricID(t *testing.T) {
 	metric.Properties["Other #"] = "5"
 
 	metricID := batchinsights.GetMetricID(metric)
-	assert.True(t, metricID == "Disk usage/Other #=5,Some #=4" || metricID == "Disk usage/Some #=4,Other #=5")
+	assert.Equal(t, "Disk usage/Other #=5,Some #=4", metricID)
+	metric.Properties["A #"] = "6"
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "Disk usage/A #=6,Other #=5,Some #=4", batchinsights.GetMetricID(metric))
+	}
 
 	metric = appinsights.NewMetricTelemetry("Disk IO", 543)
 	assert.Equal(t, "Disk IO/", batchinsights.GetMetricID(metric))
 }
+
+// fakeClient telemetry client recording the tracked items instead of sending them
+type fakeClient struct {
+	appinsights.TelemetryClient
+	items []appinsights.Telemetry
+}
+
+func (client *fakeClient) Track(item appinsights.Telemetry) {
+	client.items = append(client.items, item)
+}
+
+func (client *fakeClient) Channel() appinsights.TelemetryChannel {
+	return fakeChannel{}
+}
+
+type fakeChannel struct {
+	appinsights.TelemetryChannel
+}
+
+func (channel fakeChannel) Flush() {
+}
+
+func (channel fakeChannel) Close(retryTimeout ...time.Duration) <-chan struct{} {
+	closed := make(chan struct{})
+	close(closed)
+	return closed
+}
+
+func TestAppInsightsServiceFlush(t *testing.T) {
+	client := &fakeClient{}
+	service := batchinsights.NewAppInsightsServiceWithClient(client, time.Minute)
+	// The aggregation windows follow the sample time, e.g. while replaying a recording
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats := batchinsights.NodeStats{
+		CPUPercents: []float64{10, 30},
+		Events:      []events.Event{{Type: events.OOMKill, Message: "Killed process 42 (python)"}},
+	}
+	assert.Nil(t, service.Write(start, stats))
+	stats.CPUPercents = []float64{20, 50}
+	stats.Events = nil
+	assert.Nil(t, service.Write(start.Add(30*time.Second), stats))
+	// Only the event is sent before the end of the aggregation interval
+	assert.Equal(t, 1, len(client.items))
+
+	assert.Nil(t, service.Write(start.Add(90*time.Second), stats))
+	// The event and one aggregate per CPU
+	assert.Equal(t, 3, len(client.items))
+	for _, item := range client.items[1:] {
+		aggregate := item.(*appinsights.AggregateMetricTelemetry)
+		assert.Equal(t, "Cpu usage", aggregate.Name)
+		assert.Equal(t, 2, aggregate.Count)
+		assert.Equal(t, start, aggregate.Timestamp)
+	}
+
+	service.Close()
+	assert.Equal(t, 5, len(client.items))
+	for _, item := range client.items[3:] {
+		aggregate := item.(*appinsights.AggregateMetricTelemetry)
+		assert.Equal(t, 1, aggregate.Count)
+		assert.Equal(t, start.Add(90*time.Second), aggregate.Timestamp)
+	}
+	assert.Equal(t, uint64(5), service.Stats().Queued)
+	assert.Equal(t, uint64(5), service.Stats().Pending())
+}
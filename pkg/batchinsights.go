@@ -1,12 +1,23 @@
 package batchinsights
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"runtime"
 	"time"
 
+	"github.com/Azure/batch-insights/pkg/cgroup"
 	"github.com/Azure/batch-insights/pkg/cpu"
 	"github.com/Azure/batch-insights/pkg/disk"
+	"github.com/Azure/batch-insights/pkg/events"
+	"github.com/Azure/batch-insights/pkg/infiniband"
+	"github.com/Azure/batch-insights/pkg/load"
+	"github.com/Azure/batch-insights/pkg/memory"
+	"github.com/Azure/batch-insights/pkg/netstat"
+	"github.com/Azure/batch-insights/pkg/nfs"
 	"github.com/Azure/batch-insights/pkg/utils"
 	"github.com/dustin/go-humanize"
 	"github.com/shirou/gopsutil/mem"
@@ -20,74 +31,354 @@ func getSamplingRate(rate time.Duration) time.Duration {
 	return rate
 }
 
+// diagnosticsOutput writer of the diagnostics printed while sampling. They are discarded with --tui as the dashboard
+// owns the terminal
+func diagnosticsOutput(config Config) io.Writer {
+	if config.TUI {
+		return ioutil.Discard
+	}
+	return os.Stdout
+}
+
+// longestInterval sampling interval of the slowest collector
+func longestInterval(config Config) time.Duration {
+	longest := getSamplingRate(config.SamplingRate)
+	for _, interval := range config.Intervals {
+		if interval > longest {
+			longest = interval
+		}
+	}
+	return longest
+}
+
 // ListenForStats Start the sanpling of node metrics
 func ListenForStats(config Config) {
-	var netIO = utils.IOAggregator{}
-
-	var gpuStatsCollector = NewGPUStatsCollector()
-	defer gpuStatsCollector.Shutdown()
+	var sinks []Sink
+	appInsightsService := createAppInsightsService(config)
+	if appInsightsService != nil {
+		sinks = append(sinks, appInsightsService)
+	}
+	if config.TUI {
+		sinks = append(sinks, NewTerminalDashboard(config))
+	} else if appInsightsService == nil {
+		sinks = append(sinks, NewConsoleSink())
+	}
 
-	var appInsightsService = createAppInsightsService(config)
+	if config.Replay != "" {
+		replayStats(config, sinks)
+		return
+	}
 
-	for range time.Tick(getSamplingRate(config.SamplingRate)) {
-		gpuStatsCollector.GetStats()
+	out := diagnosticsOutput(config)
+	registry, shutdown := createCollectorRegistry(config, sinks, out)
+	defer shutdown()
+	pipeline := newPipeline(config, sinks, registry.TickInterval())
 
-		var stats = NodeStats{}
+	var recorder *Recorder
+	if config.Record != "" {
+		var err error
+		recorder, err = NewRecorder(config.Record)
+		if err != nil {
+			fmt.Fprintln(out, "Couldn't open the record file", err)
+		} else {
+			defer recorder.Close()
+		}
+	}
 
-		if !config.Disable.Memory {
-			v, err := mem.VirtualMemory()
-			if err == nil {
-				stats.Memory = v
-			} else {
-				fmt.Println(err)
-			}
+	ticker := time.NewTicker(registry.TickInterval())
+	defer ticker.Stop()
+	quit := quitRequested(sinks)
+	for {
+		var now time.Time
+		select {
+		case <-quit:
+			return
+		case now = <-ticker.C:
 		}
-		if !config.Disable.CPU {
-			cpus, err := cpu.PerCpuPercent()
-			if err == nil {
-				stats.CPUPercents = cpus
-			} else {
-				fmt.Println(err)
+		stats, statuses := registry.Collect(context.Background(), now)
+		stats.Collectors = statuses
+		if recorder != nil {
+			if err := recorder.Record(now, stats); err != nil {
+				fmt.Fprintln(out, "Error while recording the sample", err)
 			}
 		}
-		if !config.Disable.DiskUsage {
-			stats.DiskUsage = disk.GetDiskUsage()
+		pipeline.process(now, stats)
+	}
+}
+
+// quitRequested closed when the user quit the dashboard. nil, which never fires, without dashboard
+func quitRequested(sinks []Sink) <-chan struct{} {
+	for _, sink := range sinks {
+		if dashboard, ok := sink.(*Dashboard); ok {
+			return dashboard.Done()
 		}
-		if !config.Disable.DiskIO {
-			stats.DiskIO = disk.DiskIO()
+	}
+	return nil
+}
+
+// replayStats feed the samples of a recording to the sinks instead of the live collectors
+func replayStats(config Config, sinks []Sink) {
+	defer func() {
+		for _, sink := range sinks {
+			sink.Close()
 		}
-		if !config.Disable.NetworkIO {
-			stats.NetIO = getNetIO(&netIO)
+	}()
+	out := diagnosticsOutput(config)
+	file, err := os.Open(config.Replay)
+	if err != nil {
+		fmt.Fprintln(out, "Couldn't open the replay file", err)
+		return
+	}
+	defer file.Close()
+
+	pipeline := newPipeline(config, sinks, getSamplingRate(config.SamplingRate))
+	replayer := NewReplayer(file, config.ReplaySpeed)
+	if quit := quitRequested(sinks); quit != nil {
+		go func() {
+			<-quit
+			replayer.Stop()
+		}()
+	}
+	count, err := replayer.Run(pipeline.process)
+	if err != nil {
+		fmt.Fprintln(out, "Error while reading the replay file", err)
+	}
+	fmt.Fprintf(out, "Replayed %d samples from %s\n", count, config.Replay)
+}
+
+// pipeline process the collected samples and hand them to the sinks. Shared by the live collection and the replay
+type pipeline struct {
+	config       Config
+	sinks        []Sink
+	out          io.Writer // Diagnostics, discarded while the dashboard owns the terminal
+	gpuProcesses *GPUProcessTracker
+	idleAnalyzer *IdleAnalyzer
+	alertEngine  *AlertEngine
+	statusServer *StatusServer
+	history      *History
+}
+
+func newPipeline(config Config, sinks []Sink, tickInterval time.Duration) *pipeline {
+	p := pipeline{config: config, sinks: sinks, out: diagnosticsOutput(config), gpuProcesses: NewGPUProcessTracker(config.Processes)}
+	if !config.Disable.Idle {
+		p.idleAnalyzer = NewIdleAnalyzer(config.IdleWindow)
+	}
+	if len(config.AlertRules) > 0 {
+		p.alertEngine = NewAlertEngine(config.AlertRules, p.out)
+		// A few samples of the slowest collector
+		p.alertEngine.SeriesExpiry = 3 * longestInterval(config)
+	}
+
+	if config.HTTPAddress != "" {
+		p.statusServer = NewStatusServer(config, sinks, tickInterval, p.out)
+		p.history = NewHistory(config.History, tickInterval)
+		p.statusServer.HandleFunc("/metrics", p.history.HandleMetrics)
+		p.statusServer.HandleFunc("/samples", p.history.HandleSamples)
+		if p.alertEngine != nil {
+			p.statusServer.HandleFunc("/alerts", p.alertEngine.HandleAlerts)
 		}
-		if !config.Disable.GPU {
-			stats.Gpus = gpuStatsCollector.GetStats()
+		if err := p.statusServer.ListenAndServe(config.HTTPAddress); err != nil {
+			fmt.Fprintln(p.out, "Couldn't start the status API", err)
 		}
+	}
+	return &p
+}
 
-		processes, err := ListProcesses(config.Processes)
-		if err == nil {
-			stats.Processes = processes
-		} else {
-			fmt.Println(err)
+// process the raw sample of the collectors
+func (p *pipeline) process(now time.Time, stats NodeStats) {
+	p.gpuProcesses.Attribute(&stats)
+	for _, status := range stats.Collectors {
+		// The dashboard shows the failing collectors
+		if status.Failed() {
+			fmt.Fprintln(p.out, status)
+		}
+	}
+	if p.idleAnalyzer != nil {
+		idle, event := p.idleAnalyzer.Analyze(now, stats)
+		stats.Idle = idle
+		if event != nil {
+			stats.Events = append(stats.Events, *event)
 		}
+	}
+	if p.alertEngine != nil {
+		p.alertEngine.Process(now, &stats)
+	}
 
-		if appInsightsService != nil {
-			appInsightsService.UploadStats(stats)
-		} else {
-			printStats(stats)
+	for _, sink := range p.sinks {
+		if err := sink.Write(now, stats); err != nil {
+			fmt.Fprintln(p.out, "Error while writing to", sink.Name(), err)
 		}
 	}
+	if p.statusServer != nil {
+		p.statusServer.Update(stats, now)
+		p.history.Add(now, stats)
+	}
 }
 
-func getNetIO(diskIO *utils.IOAggregator) *utils.IOStats {
-	var counters, err = net.IOCounters(false)
+// createCollectorRegistry register every collector, printing their diagnostics to out. The returned function release
+// the resources held by the collectors
+func createCollectorRegistry(config Config, sinks []Sink, out io.Writer) (*CollectorRegistry, func()) {
+	linux := runtime.GOOS == "linux"
+	registry := NewCollectorRegistry(getSamplingRate(config.SamplingRate), config.Intervals)
+
+	registry.Register(NewCollector("memory", !config.Disable.Memory, func(ctx context.Context, stats *NodeStats) error {
+		v, err := mem.VirtualMemoryWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		stats.Memory = v
+		swap, err := mem.SwapMemoryWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		stats.Swap = swap
+		paging, err := memory.Paging(ctx, config.ProcRoot)
+		stats.Paging = paging
+		return err
+	}), 0)
+
+	registry.Register(NewCollector("cpu", !config.Disable.CPU, func(ctx context.Context, stats *NodeStats) error {
+		cpus, err := cpu.PerCpuPercent(ctx)
+		stats.CPUPercents = cpus
+		return err
+	}), 0)
+
+	registry.Register(NewCollector("cpuTimes", !config.Disable.CPUTimes, func(ctx context.Context, stats *NodeStats) error {
+		times, err := cpu.Times(ctx)
+		stats.CPUTimes = times
+		return err
+	}), 0)
+
+	if linux && !config.Disable.CPUFrequency {
+		frequencyCollector := cpu.NewFrequencyCollector(config.SysRoot)
+		registry.Register(NewCollector("cpuFrequency", true, func(ctx context.Context, stats *NodeStats) error {
+			stats.CPUFrequency = frequencyCollector.GetStats()
+			return nil
+		}), 0)
+	}
+
+	if linux && !config.Disable.Load {
+		loadCollector := load.NewCollector(config.ProcRoot, out)
+		registry.Register(NewCollector("load", true, func(ctx context.Context, stats *NodeStats) error {
+			loadStats, err := loadCollector.GetStats()
+			stats.Load = loadStats
+			return err
+		}), 0)
+	}
+
+	registry.Register(NewCollector("diskUsage", !config.Disable.DiskUsage, func(ctx context.Context, stats *NodeStats) error {
+		usage, err := disk.GetDiskUsage(ctx)
+		stats.DiskUsage = usage
+		return err
+	}), 0)
+
+	registry.Register(NewCollector("diskIO", !config.Disable.DiskIO, func(ctx context.Context, stats *NodeStats) error {
+		diskIO, err := disk.DiskIO(ctx)
+		stats.DiskIO = diskIO
+		return err
+	}), 0)
+
+	var netIO = utils.IOAggregator{}
+	registry.Register(NewCollector("networkIO", !config.Disable.NetworkIO, func(ctx context.Context, stats *NodeStats) error {
+		netIOStats, err := getNetIO(ctx, &netIO)
+		stats.NetIO = netIOStats
+		return err
+	}), 0)
+
+	if linux && !config.Disable.Netstat {
+		netstatCollector := netstat.NewCollector(config.ProcRoot)
+		registry.Register(NewCollector("netstat", true, func(ctx context.Context, stats *NodeStats) error {
+			sockets, err := netstatCollector.GetStats()
+			stats.Netstat = sockets
+			return err
+		}), 0)
+	}
+
+	nvmlFactory := NewGPUClientFactory(config.SysRoot, out)
+	if config.SimulatedGPUs > 0 {
+		nvmlFactory = SimulatedNvmlClientFactory(config.SimulatedGPUs)
+	}
+	var gpuStatsCollector = NewGPUStatsCollector(nvmlFactory, out)
+	registry.Register(NewCollector("gpu", !config.Disable.GPU, func(ctx context.Context, stats *NodeStats) error {
+		stats.Gpus = gpuStatsCollector.GetStats()
+		stats.GPUHealth = gpuStatsCollector.Health()
+		return gpuStatsCollector.Err()
+	}), 0)
+
+	registry.Register(NewCollector("processes", true, func(ctx context.Context, stats *NodeStats) error {
+		processes, err := ListProcesses(ctx, config.Processes)
+		stats.Processes = processes
+		return err
+	}), 0)
+
+	if linux && !config.Disable.Cgroups {
+		cgroupCollector := cgroup.NewCollector(config.CgroupRoot, cgroup.DefaultDockerRoot)
+		registry.Register(NewCollector("cgroups", true, func(ctx context.Context, stats *NodeStats) error {
+			containers, err := cgroupCollector.GetStats()
+			stats.Containers = containers
+			return err
+		}), 0)
+	}
+
+	if linux && !config.Disable.InfiniBand && infiniband.Available(config.SysRoot) {
+		infinibandCollector := infiniband.NewCollector(config.SysRoot)
+		registry.Register(NewCollector("infiniband", true, func(ctx context.Context, stats *NodeStats) error {
+			ports, err := infinibandCollector.GetStats()
+			stats.InfiniBand = ports
+			return err
+		}), 0)
+	}
+
+	if linux && !config.Disable.NFS {
+		nfsCollector := nfs.NewCollector(config.ProcRoot)
+		registry.Register(NewCollector("nfs", true, func(ctx context.Context, stats *NodeStats) error {
+			mounts, err := nfsCollector.GetStats()
+			stats.NFS = mounts
+			return err
+		}), 0)
+	}
+
+	var eventWatcher *events.Watcher
+	if linux && !config.Disable.Events {
+		eventWatcher = events.NewWatcher(config.ProcRoot, config.SysRoot, events.DefaultKernelLog, out)
+		registry.Register(NewCollector("events", true, func(ctx context.Context, stats *NodeStats) error {
+			stats.Events = eventWatcher.Poll()
+			return nil
+		}), 0)
+	}
+
+	agentMonitor := NewAgentMonitor()
+	registry.Register(NewCollector("agent", true, func(ctx context.Context, stats *NodeStats) error {
+		agent, err := agentMonitor.GetStats(sinks)
+		stats.Agent = agent
+		return err
+	}), 0)
+
+	if unknown := registry.UnknownIntervals(); len(unknown) > 0 {
+		fmt.Fprintf(out, "Intervals configured for unknown or disabled collectors are ignored: %v\n", unknown)
+	}
+
+	return registry, func() {
+		gpuStatsCollector.Shutdown()
+		for _, sink := range sinks {
+			sink.Close()
+		}
+		if eventWatcher != nil {
+			eventWatcher.Close()
+		}
+	}
+}
+
+func getNetIO(ctx context.Context, diskIO *utils.IOAggregator) (*utils.IOStats, error) {
+	var counters, err = net.IOCountersWithContext(ctx, false)
 
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	} else if len(counters) >= 1 {
 		var stats = diskIO.UpdateAggregates(counters[0].BytesRecv, counters[0].BytesSent)
-		return &stats
+		return &stats, nil
 	}
-	return nil
+	return nil, nil
 }
 
 // PrintSystemInfo print system info needed
@@ -100,42 +391,172 @@ func getConfiguration() {
 
 }
 
-func printStats(stats NodeStats) {
-	fmt.Printf("========================= Stats =========================\n")
-	fmt.Printf("Cpu percent:           %f%%, %v cpu(s)\n", avg(stats.CPUPercents), len(stats.CPUPercents))
-	fmt.Printf("Memory used:           %s/%s\n", humanize.Bytes(stats.Memory.Used), humanize.Bytes(stats.Memory.Total))
+func printStats(out io.Writer, stats NodeStats) {
+	fmt.Fprintf(out, "========================= Stats =========================\n")
+	if len(stats.CPUPercents) > 0 {
+		fmt.Fprintf(out, "Cpu percent:           %f%%, %v cpu(s)\n", avg(stats.CPUPercents), len(stats.CPUPercents))
+	}
+	if stats.CPUTimes != nil {
+		fmt.Fprintf(out, "Cpu time:             ")
+		for _, mode := range stats.CPUTimes.Total.Modes() {
+			fmt.Fprintf(out, " %s: %.1f%%", mode.Mode, mode.Percent)
+		}
+		fmt.Fprintln(out)
+	}
+
+	if stats.CPUFrequency != nil {
+		if len(stats.CPUFrequency.Cores) > 0 {
+			fmt.Fprintf(out, "Cpu frequency:        ")
+			for _, core := range stats.CPUFrequency.Cores {
+				fmt.Fprintf(out, " cpu%d: %.0fMHz", core.CPU, core.CurrentMHz)
+				if core.CoreThrottles > 0 || core.PackageThrottles > 0 {
+					fmt.Fprintf(out, " (throttled %d/%d)", core.CoreThrottles, core.PackageThrottles)
+				}
+			}
+			fmt.Fprintln(out)
+		}
+		for _, zone := range stats.CPUFrequency.Thermal {
+			fmt.Fprintf(out, "  - Thermal %s (%s): %.1f°C\n", zone.Zone, zone.Type, zone.Temperature)
+		}
+	}
+
+	if stats.Load != nil {
+		fmt.Fprintf(out, "Load average:          %.2f, %.2f, %.2f, running: %d, blocked: %d\n", stats.Load.Load1, stats.Load.Load5, stats.Load.Load15, stats.Load.ProcsRunning, stats.Load.ProcsBlocked)
+		for _, pressure := range stats.Load.Pressure {
+			fmt.Fprintf(out, "  - Pressure %s %s: %.2f%%, %.2f%%, %.2f%%\n", pressure.Resource, pressure.Kind, pressure.Avg10, pressure.Avg60, pressure.Avg300)
+		}
+	}
+
+	if stats.Memory != nil {
+		fmt.Fprintf(out, "Memory used:           %s/%s, available: %s\n", humanize.Bytes(stats.Memory.Used), humanize.Bytes(stats.Memory.Total), humanize.Bytes(stats.Memory.Available))
+		if runtime.GOOS == "linux" {
+			fmt.Fprintf(out, "Memory cache:          buffers: %s, cached: %s, dirty: %s\n", humanize.Bytes(stats.Memory.Buffers), humanize.Bytes(stats.Memory.Cached), humanize.Bytes(stats.Memory.Dirty))
+		}
+	}
+
+	if stats.Swap != nil {
+		fmt.Fprintf(out, "Swap used:             %s/%s\n", humanize.Bytes(stats.Swap.Used), humanize.Bytes(stats.Swap.Total))
+	}
+
+	if stats.Paging != nil {
+		fmt.Fprintf(out, "Paging: In:%sps, Out:%sps, Faults: %.0f/s (%.0f major)\n", humanize.Bytes(stats.Paging.SwapInBps), humanize.Bytes(stats.Paging.SwapOutBps), stats.Paging.PageFaultsPerSec, stats.Paging.MajorPageFaultsPerSec)
+	}
 
 	if len(stats.DiskUsage) > 0 {
-		fmt.Printf("Disk usage:\n")
+		fmt.Fprintf(out, "Disk usage:\n")
 		for _, usage := range stats.DiskUsage {
-			fmt.Printf("  - %s: %s/%s (%v%%)\n", usage.Path, humanize.Bytes(usage.Used), humanize.Bytes(usage.Total), usage.UsedPercent)
+			fmt.Fprintf(out, "  - %s: %s/%s (%v%%)\n", usage.Path, humanize.Bytes(usage.Used), humanize.Bytes(usage.Total), usage.UsedPercent)
 		}
 	}
 
 	if stats.DiskIO != nil {
-		fmt.Printf("Disk IO: R:%sps, W:%sps\n", humanize.Bytes(stats.DiskIO.ReadBps), humanize.Bytes(stats.DiskIO.WriteBps))
+		fmt.Fprintf(out, "Disk IO: R:%sps, W:%sps\n", humanize.Bytes(stats.DiskIO.ReadBps), humanize.Bytes(stats.DiskIO.WriteBps))
 	}
 
 	if stats.NetIO != nil {
-		fmt.Printf("NET IO: R:%sps, S:%sps\n", humanize.Bytes(stats.NetIO.ReadBps), humanize.Bytes(stats.NetIO.WriteBps))
+		fmt.Fprintf(out, "NET IO: R:%sps, S:%sps\n", humanize.Bytes(stats.NetIO.ReadBps), humanize.Bytes(stats.NetIO.WriteBps))
+	}
+
+	if stats.Netstat != nil {
+		fmt.Fprintf(out, "TCP connections:")
+		for _, connections := range stats.Netstat.TCPConnections {
+			if connections.Count > 0 {
+				fmt.Fprintf(out, " %s: %d", connections.State, connections.Count)
+			}
+		}
+		fmt.Fprintln(out)
+		fmt.Fprintf(out, "TCP retransmits: %.1f/s (%.2f%%), resets: %.1f/s, UDP receive errors: %.1f/s\n", stats.Netstat.TCPRetransmitsPerSec, stats.Netstat.TCPRetransmitPercent, stats.Netstat.TCPResetsPerSec, stats.Netstat.UDPReceiveErrorsPerSec)
 	}
 
 	if len(stats.Gpus) > 0 {
-		fmt.Printf("GPU(s) usage:\n")
+		fmt.Fprintf(out, "GPU(s) usage:\n")
 		for _, usage := range stats.Gpus {
-			fmt.Printf("  - GPU: %f%%, Memory: %f%%\n", usage.GPU, usage.Memory)
+			fmt.Fprintf(out, "  - %s %s (%s)\n", usage.Name, usage.UUID, usage.PCIBusID)
+			fmt.Fprintf(out, "    GPU: %f%%, Memory: %f%% (%s/%s)", usage.GPU, usage.Memory, humanize.Bytes(usage.MemoryUsed), humanize.Bytes(usage.MemoryTotal))
+			if usage.Temperature != nil {
+				fmt.Fprintf(out, ", Temperature: %.0fC", *usage.Temperature)
+			}
+			if usage.PowerDraw != nil && usage.PowerLimit != nil {
+				fmt.Fprintf(out, ", Power: %.0fW/%.0fW", *usage.PowerDraw, *usage.PowerLimit)
+			}
+			if usage.SMClock != nil {
+				fmt.Fprintf(out, ", Clocks: SM %.0fMHz, Memory %.0fMHz", *usage.SMClock, *usage.MemoryClock)
+			}
+			fmt.Fprintln(out)
+			for _, process := range usage.Processes {
+				fmt.Fprintf(out, "    - %s (%d), GPU memory: %s\n", process.Name, process.PID, humanize.Bytes(process.MemoryUsed))
+			}
+		}
+	}
+
+	for _, health := range stats.GPUHealth {
+		if health.State != GPUHealthy {
+			fmt.Fprintf(out, "GPU %d %s is %v: %s\n", health.Index, health.UUID, health.State, health.LastError)
 		}
 	}
 
 	if len(stats.Processes) > 0 {
-		fmt.Printf("Tracked processes:\n")
+		fmt.Fprintf(out, "Tracked processes:\n")
 		for _, process := range stats.Processes {
-			fmt.Printf("  - %s (%d), CPU: %f%%, Memory: %s\n", process.name, process.pid, process.cpu, humanize.Bytes(process.memory))
+			fmt.Fprintf(out, "  - %s (%d), CPU: %f%%, Memory: %s", process.name, process.pid, process.cpu, humanize.Bytes(process.memory))
+			if process.gpuMemory > 0 {
+				fmt.Fprintf(out, ", GPU memory: %s", humanize.Bytes(process.gpuMemory))
+			}
+			fmt.Fprintln(out)
+		}
+	}
+
+	if len(stats.Containers) > 0 {
+		fmt.Fprintf(out, "Containers:\n")
+		for _, container := range stats.Containers {
+			fmt.Fprintf(out, "  - %s %s, CPU: %f%% (throttled %f%%), Memory: %s/%s, OOM kills: %d\n", container.ID, container.Name, container.CPUPercent, container.ThrottledPercent, humanize.Bytes(container.MemoryCurrent), humanize.Bytes(container.MemoryMax), container.OOMKills)
+		}
+	}
+
+	if len(stats.InfiniBand) > 0 {
+		fmt.Fprintf(out, "InfiniBand:\n")
+		for _, port := range stats.InfiniBand {
+			fmt.Fprintf(out, "  - %s port %s: %s %v Gb/s, TX:%sps, RX:%sps, symbol errors: %d\n", port.HCA, port.Port, port.State, port.RateGbps, humanize.Bytes(port.TransmitBps), humanize.Bytes(port.ReceiveBps), port.SymbolErrors)
+		}
+	}
+
+	if len(stats.NFS) > 0 {
+		fmt.Fprintf(out, "NFS mounts:\n")
+		for _, mount := range stats.NFS {
+			fmt.Fprintf(out, "  - %s (%s) R:%sps, W:%sps, %.1f ops/s, retransmissions: %d\n", mount.MountPoint, mount.Device, humanize.Bytes(mount.ReadBps), humanize.Bytes(mount.WriteBps), mount.OpsPerSec, mount.Retransmissions)
+			for _, operation := range mount.Operations {
+				fmt.Fprintf(out, "    - %s: %.1f ops/s, RTT: %.2fms, execution: %.2fms\n", operation.Name, operation.OpsPerSec, operation.RTTMs, operation.ExecuteMs)
+			}
+		}
+	}
+
+	if len(stats.Events) > 0 {
+		fmt.Fprintf(out, "Events:\n")
+		for _, event := range stats.Events {
+			fmt.Fprintf(out, "  - %s [%s] %s %v\n", event.Time.Format(time.RFC3339), event.Type, event.Message, event.Properties)
+		}
+	}
+
+	if stats.Idle != nil {
+		fmt.Fprintf(out, "Node %s since %s\n", stats.Idle.State, stats.Idle.Since.Format(time.RFC3339))
+	}
+
+	if stats.Agent != nil {
+		fmt.Fprintf(out, "Agent: cpu: %.2f%%, memory: %s, goroutines: %d, uptime: %v\n", stats.Agent.CPUPercent, humanize.Bytes(stats.Agent.RSS), stats.Agent.Goroutines, stats.Agent.Uptime.Round(time.Second))
+		for _, sink := range stats.Agent.Sinks {
+			fmt.Fprintf(out, "  - Sink %s: queued: %d, sent: %d, failed: %d, dropped: %d\n", sink.Name, sink.Queued, sink.Sent, sink.Failed, sink.Dropped)
+		}
+	}
+	if len(stats.Collectors) > 0 {
+		fmt.Fprintf(out, "Collectors:")
+		for _, status := range stats.Collectors {
+			fmt.Fprintf(out, " %s: %v", status.Name, status.Duration.Round(time.Microsecond))
 		}
+		fmt.Fprintln(out)
 	}
 
-	fmt.Println()
-	fmt.Println()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out)
 }
 
 func avg(array []float64) float64 {
@@ -0,0 +1,412 @@
+package cgroup
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/utils"
+)
+
+// DefaultRoot default mount point of the cgroup filesystem
+const DefaultRoot = "/sys/fs/cgroup"
+
+// DefaultDockerRoot default directory where docker store the container configurations
+const DefaultDockerRoot = "/var/lib/docker/containers"
+
+// Values above this limit mean the cgroup v1 memory is not limited
+const unlimitedMemory = uint64(1) << 62
+
+// Container cgroups are named after the 64 hex digit container ID. e.g. docker/<id>, docker-<id>.scope or
+// cri-containerd-<id>.scope. Other cgroups, like the systemd slices or the tasks run without container, aren't reported
+var containerIDPattern = regexp.MustCompile(`([0-9a-f]{64})(\.scope)?$`)
+
+// Version cgroup hierarchy layout
+type Version int
+
+const (
+	// Unknown no cgroup filesystem was found
+	Unknown Version = 0
+	// V1 legacy layout with one hierarchy per controller
+	V1 Version = 1
+	// V2 unified hierarchy
+	V2 Version = 2
+)
+
+// Stats resource usage of a single container cgroup
+type Stats struct {
+	ID                 string // Short container ID
+	Name               string // Container name when it can be resolved
+	Path               string // Path of the cgroup relative to the hierarchy root
+	CPUPercent         float64
+	ThrottledPercent   float64 // Percent of the cpu periods where the cgroup was throttled
+	ThrottledTimeMsps  float64 // Milliseconds throttled per second
+	MemoryCurrent      uint64
+	MemoryMax          uint64 // 0 when the memory is not limited
+	OOMKills           uint64 // Total number of processes killed by the OOM killer in this cgroup
+	NewOOMKills        uint64 // Number of processes killed by the OOM killer since the last sample
+	IOReadBps          uint64
+	IOWriteBps         uint64
+	IOReadOpsPerSec    float64
+	IOWriteOpsPerSec   float64
+	cpuUsageNs         uint64
+	nrPeriods          uint64
+	nrThrottled        uint64
+	throttledTimeNs    uint64
+	ioReadBytes        uint64
+	ioWriteBytes       uint64
+	ioReadOps          uint64
+	ioWriteOps         uint64
+	hasIO              bool
+	hasCPUThrottleInfo bool
+}
+
+// Collector collector that discover container cgroups and retrieve their resource usage
+type Collector struct {
+	root       string
+	dockerRoot string
+	version    Version
+	rates      utils.RateAggregator
+	oomKills   map[string]uint64
+	names      map[string]string
+}
+
+// NewCollector Create a new cgroup collector for the cgroup filesystem mounted at root
+func NewCollector(root string, dockerRoot string) *Collector {
+	return &Collector{
+		root:       root,
+		dockerRoot: dockerRoot,
+		version:    DetectVersion(root),
+		oomKills:   make(map[string]uint64),
+		names:      make(map[string]string),
+	}
+}
+
+// DetectVersion detect which cgroup layout is mounted at the given root
+func DetectVersion(root string) Version {
+	if exists(filepath.Join(root, "cgroup.controllers")) {
+		return V2
+	}
+	if exists(filepath.Join(root, "memory")) || exists(filepath.Join(root, "cpuacct")) {
+		return V1
+	}
+	return Unknown
+}
+
+// Version returns the cgroup layout used by this collector
+func (collector *Collector) Version() Version {
+	return collector.version
+}
+
+// GetStats Get the resource usage of every container cgroup
+func (collector *Collector) GetStats() ([]Stats, error) {
+	if collector.version == Unknown {
+		return nil, nil
+	}
+
+	paths, err := collector.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var result []Stats
+	for _, path := range paths {
+		var stats Stats
+		var err error
+		if collector.version == V2 {
+			stats, err = collector.readV2(path)
+		} else {
+			stats, err = collector.readV1(path)
+		}
+		if err != nil {
+			// cgroup probably removed since discovery
+			continue
+		}
+
+		stats.Path = path
+		stats.ID = shortID(path)
+		stats.Name = collector.containerName(path)
+		collector.computeRates(&stats, now)
+		result = append(result, stats)
+	}
+
+	collector.rates.Prune(now)
+	collector.prune(paths)
+	return result, nil
+}
+
+// prune forget the OOM kill counters and names of the containers which were not discovered in this scan
+func (collector *Collector) prune(paths []string) {
+	seenPaths := make(map[string]bool)
+	seenIDs := make(map[string]bool)
+	for _, path := range paths {
+		seenPaths[path] = true
+		if match := containerIDPattern.FindStringSubmatch(path); match != nil {
+			seenIDs[match[1]] = true
+		}
+	}
+	for path := range collector.oomKills {
+		if !seenPaths[path] {
+			delete(collector.oomKills, path)
+		}
+	}
+	for id := range collector.names {
+		if !seenIDs[id] {
+			delete(collector.names, id)
+		}
+	}
+}
+
+// discover list the cgroups which belong to a container relative to the hierarchy root
+func (collector *Collector) discover() ([]string, error) {
+	hierarchy := collector.root
+	if collector.version == V1 {
+		hierarchy = filepath.Join(collector.root, "memory")
+	}
+
+	var paths []string
+	err := filepath.Walk(hierarchy, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// cgroup removed while walking
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if containerIDPattern.MatchString(info.Name()) {
+			relative, err := filepath.Rel(hierarchy, path)
+			if err == nil {
+				paths = append(paths, relative)
+			}
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return paths, err
+}
+
+func (collector *Collector) readV2(path string) (Stats, error) {
+	dir := filepath.Join(collector.root, path)
+	stats := Stats{}
+
+	memoryCurrent, err := readUint(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return stats, err
+	}
+	stats.MemoryCurrent = memoryCurrent
+	stats.MemoryMax, _ = readUint(filepath.Join(dir, "memory.max"))
+
+	if events, err := readKeyValues(filepath.Join(dir, "memory.events")); err == nil {
+		stats.OOMKills = events["oom_kill"]
+	}
+
+	if cpuStat, err := readKeyValues(filepath.Join(dir, "cpu.stat")); err == nil {
+		stats.cpuUsageNs = cpuStat["usage_usec"] * 1000
+		stats.nrPeriods = cpuStat["nr_periods"]
+		stats.nrThrottled = cpuStat["nr_throttled"]
+		stats.throttledTimeNs = cpuStat["throttled_usec"] * 1000
+		_, stats.hasCPUThrottleInfo = cpuStat["nr_periods"]
+	}
+
+	if lines, err := readLines(filepath.Join(dir, "io.stat")); err == nil {
+		stats.hasIO = true
+		for _, line := range lines {
+			for _, field := range strings.Fields(line)[1:] {
+				parts := strings.SplitN(field, "=", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				value, _ := strconv.ParseUint(parts[1], 10, 64)
+				switch parts[0] {
+				case "rbytes":
+					stats.ioReadBytes += value
+				case "wbytes":
+					stats.ioWriteBytes += value
+				case "rios":
+					stats.ioReadOps += value
+				case "wios":
+					stats.ioWriteOps += value
+				}
+			}
+		}
+	}
+	return stats, nil
+}
+
+func (collector *Collector) readV1(path string) (Stats, error) {
+	stats := Stats{}
+
+	memoryDir := filepath.Join(collector.root, "memory", path)
+	memoryCurrent, err := readUint(filepath.Join(memoryDir, "memory.usage_in_bytes"))
+	if err != nil {
+		return stats, err
+	}
+	stats.MemoryCurrent = memoryCurrent
+	if limit, err := readUint(filepath.Join(memoryDir, "memory.limit_in_bytes")); err == nil && limit < unlimitedMemory {
+		stats.MemoryMax = limit
+	}
+	if oomControl, err := readKeyValues(filepath.Join(memoryDir, "memory.oom_control")); err == nil {
+		stats.OOMKills = oomControl["oom_kill"]
+	}
+
+	stats.cpuUsageNs, _ = readUint(filepath.Join(collector.root, "cpuacct", path, "cpuacct.usage"))
+	if cpuStat, err := readKeyValues(filepath.Join(collector.root, "cpu", path, "cpu.stat")); err == nil {
+		stats.nrPeriods = cpuStat["nr_periods"]
+		stats.nrThrottled = cpuStat["nr_throttled"]
+		stats.throttledTimeNs = cpuStat["throttled_time"]
+		stats.hasCPUThrottleInfo = true
+	}
+
+	blkioDir := filepath.Join(collector.root, "blkio", path)
+	if lines, err := readLines(filepath.Join(blkioDir, "blkio.throttle.io_service_bytes")); err == nil {
+		stats.hasIO = true
+		stats.ioReadBytes, stats.ioWriteBytes = sumBlkio(lines)
+	}
+	if lines, err := readLines(filepath.Join(blkioDir, "blkio.throttle.io_serviced")); err == nil {
+		stats.ioReadOps, stats.ioWriteOps = sumBlkio(lines)
+	}
+	return stats, nil
+}
+
+func (collector *Collector) computeRates(stats *Stats, now time.Time) {
+	key := stats.Path + "/"
+	if rate, ok := collector.rates.UpdateRate(key+"cpu", stats.cpuUsageNs, now); ok {
+		stats.CPUPercent = rate / 1e7
+	}
+
+	if stats.hasCPUThrottleInfo {
+		periods, ok := collector.rates.UpdateRate(key+"periods", stats.nrPeriods, now)
+		throttled, _ := collector.rates.UpdateRate(key+"throttled", stats.nrThrottled, now)
+		if ok && periods > 0 {
+			stats.ThrottledPercent = throttled / periods * 100
+		}
+		if rate, ok := collector.rates.UpdateRate(key+"throttledTime", stats.throttledTimeNs, now); ok {
+			stats.ThrottledTimeMsps = rate / 1e6
+		}
+	}
+
+	if stats.hasIO {
+		if rate, ok := collector.rates.UpdateRate(key+"ioReadBytes", stats.ioReadBytes, now); ok {
+			stats.IOReadBps = uint64(rate)
+		}
+		if rate, ok := collector.rates.UpdateRate(key+"ioWriteBytes", stats.ioWriteBytes, now); ok {
+			stats.IOWriteBps = uint64(rate)
+		}
+		stats.IOReadOpsPerSec, _ = collector.rates.UpdateRate(key+"ioReadOps", stats.ioReadOps, now)
+		stats.IOWriteOpsPerSec, _ = collector.rates.UpdateRate(key+"ioWriteOps", stats.ioWriteOps, now)
+	}
+
+	if last, ok := collector.oomKills[stats.Path]; ok && stats.OOMKills > last {
+		stats.NewOOMKills = stats.OOMKills - last
+	}
+	collector.oomKills[stats.Path] = stats.OOMKills
+}
+
+// containerName resolve the name of a docker container from its configuration. Returns an empty string if unknown
+func (collector *Collector) containerName(path string) string {
+	match := containerIDPattern.FindStringSubmatch(path)
+	if match == nil {
+		return ""
+	}
+	id := match[1]
+	if name, ok := collector.names[id]; ok {
+		return name
+	}
+
+	name := ""
+	content, err := ioutil.ReadFile(filepath.Join(collector.dockerRoot, id, "config.v2.json"))
+	if err == nil {
+		var config struct {
+			Name string
+		}
+		if json.Unmarshal(content, &config) == nil {
+			name = strings.TrimPrefix(config.Name, "/")
+		}
+	}
+	collector.names[id] = name
+	return name
+}
+
+func shortID(path string) string {
+	match := containerIDPattern.FindStringSubmatch(path)
+	if match == nil {
+		return path
+	}
+	return match[1][:12]
+}
+
+func sumBlkio(lines []string) (read uint64, write uint64) {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			read += value
+		case "Write":
+			write += value
+		}
+	}
+	return read, write
+}
+
+// readUint read a cgroup limit or counter. The "max" limit is returned as 0
+func readUint(path string) (uint64, error) {
+	value, err := utils.ReadString(path)
+	if err != nil {
+		return 0, err
+	}
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func readKeyValues(path string) (map[string]uint64, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values, nil
+}
+
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
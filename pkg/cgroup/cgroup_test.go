@@ -0,0 +1,105 @@
+package cgroup_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/cgroup"
+	"github.com/Azure/batch-insights/pkg/testfixture"
+	"github.com/stretchr/testify/assert"
+)
+
+const v2ContainerPath = "system.slice/docker-3f2a9c1e8b7d6f5a4c3b2a1908f7e6d5c4b3a29180f7e6d5c4b3a2918f7e6d5c.scope"
+
+func TestDetectVersion(t *testing.T) {
+	assert.Equal(t, cgroup.V2, cgroup.DetectVersion("testdata/v2"))
+	assert.Equal(t, cgroup.V1, cgroup.DetectVersion("testdata/v1"))
+	assert.Equal(t, cgroup.Unknown, cgroup.DetectVersion("testdata/missing"))
+}
+
+func TestGetStatsV2(t *testing.T) {
+	collector := cgroup.NewCollector("testdata/v2", "testdata/docker")
+
+	stats, err := collector.GetStats()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, "3f2a9c1e8b7d", stats[0].ID)
+	assert.Equal(t, "tensorflow-task", stats[0].Name)
+	assert.Equal(t, v2ContainerPath, stats[0].Path)
+	assert.Equal(t, uint64(104857600), stats[0].MemoryCurrent)
+	assert.Equal(t, uint64(536870912), stats[0].MemoryMax)
+	assert.Equal(t, uint64(2), stats[0].OOMKills)
+	assert.Equal(t, uint64(0), stats[0].NewOOMKills)
+}
+
+func TestGetStatsV1(t *testing.T) {
+	collector := cgroup.NewCollector("testdata/v1", "testdata/docker")
+
+	stats, err := collector.GetStats()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, "9e8d7c6b5a4f", stats[0].ID)
+	assert.Equal(t, "", stats[0].Name)
+	assert.Equal(t, uint64(209715200), stats[0].MemoryCurrent)
+	assert.Equal(t, uint64(0), stats[0].MemoryMax)
+	assert.Equal(t, uint64(1), stats[0].OOMKills)
+}
+
+func TestGetStatsRates(t *testing.T) {
+	f := testfixture.New(t)
+	defer f.Close()
+	write := func(name string, content string) {
+		f.Write(v2ContainerPath+"/"+name, content)
+	}
+	f.Write("cgroup.controllers", "cpu io memory\n")
+	write("memory.current", "1024\n")
+	write("memory.max", "max\n")
+	write("memory.events", "oom_kill 1\n")
+	write("cpu.stat", "usage_usec 1000\nnr_periods 10\nnr_throttled 0\nthrottled_usec 0\n")
+	write("io.stat", "8:0 rbytes=0 wbytes=0 rios=0 wios=0\n")
+
+	collector := cgroup.NewCollector(f.Root, f.Root)
+	start := time.Now()
+	_, err := collector.GetStats()
+	firstDone := time.Now()
+	assert.Nil(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+	write("memory.events", "oom_kill 3\n")
+	write("cpu.stat", "usage_usec 51000\nnr_periods 20\nnr_throttled 5\nthrottled_usec 10000\n")
+	write("io.stat", "8:0 rbytes=4096 wbytes=8192 rios=1 wios=2\n")
+
+	secondStart := time.Now()
+	stats, err := collector.GetStats()
+	end := time.Now()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, uint64(0), stats[0].MemoryMax)
+	assert.Equal(t, uint64(2), stats[0].NewOOMKills)
+	assert.Equal(t, float64(50), stats[0].ThrottledPercent)
+	minElapsed, maxElapsed := secondStart.Sub(firstDone).Seconds(), end.Sub(start).Seconds()
+	assertRate := func(delta float64, rate float64) {
+		assert.True(t, rate >= delta/maxElapsed && rate <= delta/minElapsed, "%v not in [%v, %v]", rate, delta/maxElapsed, delta/minElapsed)
+	}
+	// 50ms of CPU time, 10ms throttled
+	assertRate(5, stats[0].CPUPercent)
+	assertRate(10, stats[0].ThrottledTimeMsps)
+	// Truncated to whole bytes per second
+	assert.True(t, float64(stats[0].IOReadBps) >= 4096/maxElapsed-1 && float64(stats[0].IOReadBps) <= 4096/minElapsed)
+	assertRate(2, stats[0].IOWriteOpsPerSec)
+
+	// The counters of a removed container are forgotten
+	f.Remove(v2ContainerPath)
+	stats, err = collector.GetStats()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(stats))
+	write("memory.current", "1024\n")
+	write("memory.max", "max\n")
+	write("memory.events", "oom_kill 5\n")
+	stats, err = collector.GetStats()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, uint64(0), stats[0].NewOOMKills)
+}
@@ -0,0 +1,53 @@
+package batchinsights
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Output formats of a single sample
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// CollectOnce take a single sample with every enabled collector, printing their diagnostics to out. Rate based metrics,
+// e.g. CPU usage or IO rates, need two readings so the collectors run twice, one sampling interval apart
+func CollectOnce(config Config, out io.Writer) NodeStats {
+	// Every collector must run again after the sampling interval
+	config.Intervals = nil
+	registry, shutdown := createCollectorRegistry(config, nil, out)
+	defer shutdown()
+
+	registry.Collect(context.Background(), time.Now())
+	time.Sleep(registry.TickInterval())
+	stats, statuses := registry.Collect(context.Background(), time.Now())
+	stats.Collectors = statuses
+	NewGPUProcessTracker(config.Processes).Attribute(&stats)
+	return stats
+}
+
+// ValidateFormat returns an error if the output format isn't json or text
+func ValidateFormat(format string) error {
+	if format != FormatJSON && format != FormatText {
+		return fmt.Errorf("Invalid format %q, expected %s or %s", format, FormatJSON, FormatText)
+	}
+	return nil
+}
+
+// PrintSample print a sample to out in the given format
+func PrintSample(out io.Writer, stats NodeStats, format string) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	if format == FormatText {
+		printStats(out, stats)
+		return nil
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stats)
+}
@@ -0,0 +1,423 @@
+package batchinsights
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collector source of node metrics sampled at every tick
+type Collector interface {
+	// Name unique name of the collector. e.g. memory, gpu
+	Name() string
+	// Enabled returns false if the collector is disabled or not supported on this node
+	Enabled() bool
+	// Collect sample the metrics into stats. stats is owned by the collector until Collect returns
+	Collect(ctx context.Context, stats *NodeStats) error
+}
+
+// CollectFunc function sampling metrics into the given stats
+type CollectFunc func(ctx context.Context, stats *NodeStats) error
+
+type funcCollector struct {
+	name    string
+	enabled bool
+	collect CollectFunc
+}
+
+// NewCollector Create a collector from a function
+func NewCollector(name string, enabled bool, collect CollectFunc) Collector {
+	return &funcCollector{name: name, enabled: enabled, collect: collect}
+}
+
+func (collector *funcCollector) Name() string {
+	return collector.name
+}
+
+func (collector *funcCollector) Enabled() bool {
+	return collector.enabled
+}
+
+func (collector *funcCollector) Collect(ctx context.Context, stats *NodeStats) error {
+	return collector.collect(ctx, stats)
+}
+
+// CollectorStatus outcome of a collector for a single sample
+type CollectorStatus struct {
+	Name     string
+	Duration time.Duration
+	TimedOut bool   // The collector didn't complete before its timeout, its metrics are missing from the sample
+	Skipped  bool   // The collector was still running from a previous sample and wasn't started
+	Timeouts uint64 // Number of samples the collector timed out or was skipped since batch insights started
+	Errors   uint64 // Number of samples the collector failed since batch insights started
+	Error    string // Empty when the collector succeeded
+}
+
+type registeredCollector struct {
+	collector Collector
+	interval  time.Duration
+	timeout   time.Duration // 0 to use the interval
+	next      time.Time     // Time the collector is due, zero before the first run
+	running   bool          // Set until Collect receives the result of the run
+	deadline  time.Time     // Time the running collector times out
+	timedOut  bool          // The running collector was reported as timed out, its result is discarded
+	timeouts  uint64
+	errors    uint64
+}
+
+type collectorResult struct {
+	entry    *registeredCollector
+	stats    NodeStats
+	duration time.Duration
+	err      error
+}
+
+// CollectorRegistry run the registered collectors concurrently, each at its own interval and bounded by its own timeout
+type CollectorRegistry struct {
+	defaultInterval time.Duration
+	intervals       map[string]time.Duration
+	collectors      []*registeredCollector
+	results         chan collectorResult
+	lock            sync.Mutex
+}
+
+// NewCollectorRegistry Create a new registry. intervals is keyed by the lowercase collector name, defaultInterval is used for the other collectors
+func NewCollectorRegistry(defaultInterval time.Duration, intervals map[string]time.Duration) *CollectorRegistry {
+	return &CollectorRegistry{defaultInterval: defaultInterval, intervals: intervals}
+}
+
+// Register add a collector to the registry. Disabled collectors are ignored.
+// A timeout of 0 uses the collector interval. Collect doesn't wait for a slow collector longer than the tick interval
+func (registry *CollectorRegistry) Register(collector Collector, timeout time.Duration) {
+	if !collector.Enabled() {
+		return
+	}
+	interval, ok := registry.intervals[strings.ToLower(collector.Name())]
+	if !ok {
+		interval = registry.defaultInterval
+	}
+	registry.collectors = append(registry.collectors, &registeredCollector{collector: collector, interval: interval, timeout: timeout})
+}
+
+// Interval sampling interval of a registered collector
+func (registry *CollectorRegistry) Interval(name string) time.Duration {
+	for _, entry := range registry.collectors {
+		if entry.collector.Name() == name {
+			return entry.interval
+		}
+	}
+	return 0
+}
+
+// UnknownIntervals names from the configured intervals which don't match any registered collector
+func (registry *CollectorRegistry) UnknownIntervals() []string {
+	var unknown []string
+	for name := range registry.intervals {
+		found := false
+		for _, entry := range registry.collectors {
+			found = found || strings.ToLower(entry.collector.Name()) == name
+		}
+		if !found {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// TickInterval time between two calls to Collect, i.e. the greatest common divisor of the collector intervals so every
+// collector runs exactly at its interval. It is at least MinSamplingRate, or the shortest interval if it is shorter
+func (registry *CollectorRegistry) TickInterval() time.Duration {
+	tick := registry.defaultInterval
+	shortest := registry.defaultInterval
+	for i, entry := range registry.collectors {
+		if i == 0 {
+			tick = entry.interval
+			shortest = entry.interval
+			continue
+		}
+		tick = gcd(tick, entry.interval)
+		if entry.interval < shortest {
+			shortest = entry.interval
+		}
+	}
+	floor := MinSamplingRate
+	if shortest < floor {
+		floor = shortest
+	}
+	if tick < floor {
+		return floor
+	}
+	return tick
+}
+
+func gcd(a time.Duration, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// due returns true if the collector is due. Half a tick of jitter is tolerated so a late tick doesn't skip a sample
+func (entry *registeredCollector) due(now time.Time, tick time.Duration) bool {
+	return entry.next.IsZero() || !now.Before(entry.next.Add(-tick/2))
+}
+
+// schedule compute the next time the collector is due. The schedule doesn't drift with the tick jitter,
+// it is reset when the collector is late by more than an interval, e.g. after the node was suspended
+func (entry *registeredCollector) schedule(now time.Time) {
+	if entry.next.IsZero() || now.Sub(entry.next) >= entry.interval {
+		entry.next = now
+	}
+	entry.next = entry.next.Add(entry.interval)
+}
+
+func (entry *registeredCollector) effectiveTimeout() time.Duration {
+	if entry.timeout > 0 {
+		return entry.timeout
+	}
+	return entry.interval
+}
+
+// Names names of the registered collectors
+func (registry *CollectorRegistry) Names() []string {
+	var names []string
+	for _, entry := range registry.collectors {
+		names = append(names, entry.collector.Name())
+	}
+	return names
+}
+
+// Collect run every collector whose interval elapsed concurrently and merge their stats.
+// The returned stats only hold fresh metrics: collectors which aren't due are left out.
+// Collect waits at most a tick interval: collectors still running are left in the background and their stats are
+// merged in the sample they complete in. Collectors which don't complete before their timeout are reported as timed
+// out and skipped until they return, their stats are then discarded.
+func (registry *CollectorRegistry) Collect(ctx context.Context, now time.Time) (NodeStats, []CollectorStatus) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if registry.results == nil {
+		// Buffered for every collector so a run never blocks, even if nobody is waiting anymore
+		registry.results = make(chan collectorResult, len(registry.collectors))
+	}
+	tick := registry.TickInterval()
+	sample := collectorSample{statuses: make(map[*registeredCollector]*CollectorStatus)}
+
+	// Collectors from previous samples which completed or timed out since
+	registry.receiveCompleted(&sample)
+	registry.expire(&sample, time.Now())
+
+	waiting := make(map[*registeredCollector]time.Time)
+	for _, entry := range registry.collectors {
+		if !entry.due(now, tick) {
+			continue
+		}
+		entry.schedule(now)
+		if entry.running {
+			// Unless it just timed out in this sample
+			if sample.statuses[entry] == nil {
+				entry.timeouts++
+				sample.statuses[entry] = &CollectorStatus{Name: entry.collector.Name(), Skipped: true, Timeouts: entry.timeouts, Errors: entry.errors}
+			}
+			continue
+		}
+		timeout := entry.effectiveTimeout()
+		start := time.Now()
+		entry.running = true
+		entry.deadline = start.Add(timeout)
+		if timeout < tick {
+			waiting[entry] = entry.deadline
+		} else {
+			waiting[entry] = start.Add(tick)
+		}
+		go registry.run(ctx, entry, timeout)
+	}
+
+	for len(waiting) > 0 {
+		var until time.Time
+		for _, deadline := range waiting {
+			if until.IsZero() || deadline.Before(until) {
+				until = deadline
+			}
+		}
+		timer := time.NewTimer(time.Until(until))
+		select {
+		case result := <-registry.results:
+			timer.Stop()
+			delete(waiting, result.entry)
+			registry.receive(&sample, result)
+		case current := <-timer.C:
+			for entry, deadline := range waiting {
+				if !current.Before(deadline) {
+					delete(waiting, entry)
+				}
+			}
+			registry.expire(&sample, current)
+		}
+	}
+	return sample.stats, sample.list(registry.collectors)
+}
+
+// collectorSample stats and statuses of the collectors which completed, timed out or were skipped in a sample
+type collectorSample struct {
+	stats    NodeStats
+	statuses map[*registeredCollector]*CollectorStatus
+}
+
+// list statuses in the registration order
+func (sample *collectorSample) list(collectors []*registeredCollector) []CollectorStatus {
+	var statuses []CollectorStatus
+	for _, entry := range collectors {
+		if status, ok := sample.statuses[entry]; ok {
+			statuses = append(statuses, *status)
+		}
+	}
+	return statuses
+}
+
+// receiveCompleted receive the results already sent without waiting
+func (registry *CollectorRegistry) receiveCompleted(sample *collectorSample) {
+	for {
+		select {
+		case result := <-registry.results:
+			registry.receive(sample, result)
+		default:
+			return
+		}
+	}
+}
+
+func (registry *CollectorRegistry) receive(sample *collectorSample, result collectorResult) {
+	entry := result.entry
+	entry.running = false
+	if entry.timedOut {
+		// Already reported as timed out
+		entry.timedOut = false
+		return
+	}
+	if result.err != nil {
+		entry.errors++
+	}
+	status := &CollectorStatus{Name: entry.collector.Name(), Duration: result.duration, Timeouts: entry.timeouts, Errors: entry.errors}
+	if result.err != nil {
+		status.Error = result.err.Error()
+	}
+	sample.statuses[entry] = status
+	mergeStats(&sample.stats, &result.stats)
+}
+
+// expire report the running collectors whose timeout elapsed
+func (registry *CollectorRegistry) expire(sample *collectorSample, now time.Time) {
+	for _, entry := range registry.collectors {
+		if !entry.running || entry.timedOut || now.Before(entry.deadline) {
+			continue
+		}
+		entry.timedOut = true
+		entry.timeouts++
+		sample.statuses[entry] = &CollectorStatus{
+			Name:     entry.collector.Name(),
+			Duration: entry.effectiveTimeout(),
+			TimedOut: true,
+			Timeouts: entry.timeouts,
+			Errors:   entry.errors,
+		}
+	}
+}
+
+func (registry *CollectorRegistry) run(ctx context.Context, entry *registeredCollector, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	result := collectorResult{entry: entry}
+	result.err = entry.collector.Collect(ctx, &result.stats)
+	result.duration = time.Since(start)
+	registry.results <- result
+}
+
+// mergeStats copy the metrics set by a collector. A field added to NodeStats must be copied here
+func mergeStats(stats *NodeStats, partial *NodeStats) {
+	if partial.Memory != nil {
+		stats.Memory = partial.Memory
+	}
+	if partial.Swap != nil {
+		stats.Swap = partial.Swap
+	}
+	if partial.Paging != nil {
+		stats.Paging = partial.Paging
+	}
+	if partial.CPUPercents != nil {
+		stats.CPUPercents = partial.CPUPercents
+	}
+	if partial.CPUTimes != nil {
+		stats.CPUTimes = partial.CPUTimes
+	}
+	if partial.CPUFrequency != nil {
+		stats.CPUFrequency = partial.CPUFrequency
+	}
+	if partial.Load != nil {
+		stats.Load = partial.Load
+	}
+	if partial.DiskUsage != nil {
+		stats.DiskUsage = partial.DiskUsage
+	}
+	if partial.DiskIO != nil {
+		stats.DiskIO = partial.DiskIO
+	}
+	if partial.NetIO != nil {
+		stats.NetIO = partial.NetIO
+	}
+	if partial.Netstat != nil {
+		stats.Netstat = partial.Netstat
+	}
+	if partial.Gpus != nil {
+		stats.Gpus = partial.Gpus
+	}
+	if partial.GPUHealth != nil {
+		stats.GPUHealth = partial.GPUHealth
+	}
+	if partial.Processes != nil {
+		stats.Processes = partial.Processes
+	}
+	if partial.Containers != nil {
+		stats.Containers = partial.Containers
+	}
+	if partial.InfiniBand != nil {
+		stats.InfiniBand = partial.InfiniBand
+	}
+	if partial.NFS != nil {
+		stats.NFS = partial.NFS
+	}
+	if partial.Events != nil {
+		stats.Events = partial.Events
+	}
+	if partial.Agent != nil {
+		stats.Agent = partial.Agent
+	}
+	if partial.Idle != nil {
+		stats.Idle = partial.Idle
+	}
+	if partial.Collectors != nil {
+		stats.Collectors = partial.Collectors
+	}
+}
+
+// Failed returns true if the collector didn't report its metrics
+func (status CollectorStatus) Failed() bool {
+	return status.TimedOut || status.Skipped || status.Error != ""
+}
+
+func (status CollectorStatus) String() string {
+	switch {
+	case status.Skipped:
+		return fmt.Sprintf("%s: skipped, still running", status.Name)
+	case status.TimedOut:
+		return fmt.Sprintf("%s: timed out after %v", status.Name, status.Duration)
+	case status.Error != "":
+		return fmt.Sprintf("%s: %s", status.Name, status.Error)
+	}
+	return fmt.Sprintf("%s: %v", status.Name, status.Duration)
+}
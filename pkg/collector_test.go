@@ -0,0 +1,236 @@
+package batchinsights_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/Azure/batch-insights/pkg/load"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectorRegistry(t *testing.T) {
+	registry := batchinsights.NewCollectorRegistry(time.Second, nil)
+	registry.Register(batchinsights.NewCollector("cpu", true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		stats.CPUPercents = []float64{10, 20}
+		return nil
+	}), 0)
+	registry.Register(batchinsights.NewCollector("load", true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		stats.Load = &load.Stats{Load1: 1.5}
+		return errors.New("partial")
+	}), 0)
+	registry.Register(batchinsights.NewCollector("disabled", false, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		t.Error("Disabled collector shouldn't run")
+		return nil
+	}), 0)
+
+	stats, statuses := registry.Collect(context.Background(), time.Now())
+
+	assert.Equal(t, []string{"cpu", "load"}, registry.Names())
+	assert.Equal(t, []float64{10, 20}, stats.CPUPercents)
+	assert.Equal(t, 1.5, stats.Load.Load1)
+	assert.Equal(t, 2, len(statuses))
+	assert.Equal(t, "", statuses[0].Error)
+	assert.Equal(t, "partial", statuses[1].Error)
+}
+
+func TestCollectorRegistryMergesEveryField(t *testing.T) {
+	var expected batchinsights.NodeStats
+	value := reflect.ValueOf(&expected).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.Ptr:
+			field.Set(reflect.New(field.Type().Elem()))
+		case reflect.Slice:
+			field.Set(reflect.MakeSlice(field.Type(), 1, 1))
+		default:
+			t.Fatalf("Set a value for NodeStats.%s", value.Type().Field(i).Name)
+		}
+	}
+	registry := batchinsights.NewCollectorRegistry(time.Second, nil)
+	registry.Register(batchinsights.NewCollector("all", true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		*stats = expected
+		return nil
+	}), 0)
+
+	stats, _ := registry.Collect(context.Background(), time.Now())
+
+	assert.Equal(t, expected, stats)
+}
+
+func TestCollectorRegistryTimeout(t *testing.T) {
+	release := make(chan struct{})
+	registry := batchinsights.NewCollectorRegistry(time.Second, nil)
+	registry.Register(batchinsights.NewCollector("cpu", true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		stats.CPUPercents = []float64{10}
+		return nil
+	}), 0)
+	// Ignores its context like a hung NVML call or NFS mount
+	registry.Register(batchinsights.NewCollector("gpu", true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		<-release
+		stats.Gpus = []batchinsights.GPUUsage{{GPU: 50}}
+		return nil
+	}), 20*time.Millisecond)
+
+	now := time.Now()
+	stats, statuses := registry.Collect(context.Background(), now)
+
+	assert.Equal(t, []float64{10}, stats.CPUPercents)
+	assert.Nil(t, stats.Gpus)
+	assert.False(t, statuses[0].TimedOut)
+	assert.True(t, statuses[1].TimedOut)
+	assert.Equal(t, uint64(1), statuses[1].Timeouts)
+
+	// Still running, it isn't started again
+	now = now.Add(time.Second)
+	stats, statuses = registry.Collect(context.Background(), now)
+	assert.Equal(t, []float64{10}, stats.CPUPercents)
+	assert.True(t, statuses[1].Skipped)
+	assert.Equal(t, uint64(2), statuses[1].Timeouts)
+
+	close(release)
+	for i := 0; i < 100; i++ {
+		now = now.Add(time.Second)
+		stats, statuses = registry.Collect(context.Background(), now)
+		if !statuses[1].Skipped {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, statuses[1].TimedOut)
+	assert.Equal(t, 50.0, stats.Gpus[0].GPU)
+}
+
+func TestCollectorRegistryIntervals(t *testing.T) {
+	registry := batchinsights.NewCollectorRegistry(time.Second, map[string]time.Duration{"diskusage": 3 * time.Second})
+	registry.Register(batchinsights.NewCollector("cpu", true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		stats.CPUPercents = []float64{10}
+		return nil
+	}), 0)
+	registry.Register(batchinsights.NewCollector("diskUsage", true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		stats.DiskUsage = []*disk.UsageStat{{Path: "/"}}
+		return nil
+	}), 0)
+
+	assert.Equal(t, time.Second, registry.TickInterval())
+	assert.Equal(t, 3*time.Second, registry.Interval("diskUsage"))
+
+	var diskSamples []int
+	start := time.Now()
+	for i := 0; i < 7; i++ {
+		// Ticks are a bit late or early
+		jitter := time.Duration(i%2) * 100 * time.Millisecond
+		stats, statuses := registry.Collect(context.Background(), start.Add(time.Duration(i)*time.Second+jitter))
+		assert.Equal(t, []float64{10}, stats.CPUPercents)
+		if stats.DiskUsage != nil {
+			diskSamples = append(diskSamples, i)
+			assert.Equal(t, 2, len(statuses))
+		} else {
+			// Only fresh data is reported
+			assert.Equal(t, 1, len(statuses))
+		}
+	}
+	assert.Equal(t, []int{0, 3, 6}, diskSamples)
+}
+
+func TestCollectorRegistrySlowCollector(t *testing.T) {
+	tick := 50 * time.Millisecond
+	registry := batchinsights.NewCollectorRegistry(tick, map[string]time.Duration{"diskusage": time.Minute})
+	registry.Register(batchinsights.NewCollector("cpu", true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		stats.CPUPercents = []float64{10}
+		return nil
+	}), 0)
+	// Takes several ticks but completes well within its own interval
+	registry.Register(batchinsights.NewCollector("diskUsage", true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+		time.Sleep(10 * tick)
+		stats.DiskUsage = []*disk.UsageStat{{Path: "/"}}
+		return nil
+	}), 0)
+	assert.Equal(t, tick, registry.TickInterval())
+
+	var diskStatus *batchinsights.CollectorStatus
+	var diskUsage []*disk.UsageStat
+	now := time.Now()
+	for i := 0; i < 100 && diskStatus == nil; i++ {
+		start := time.Now()
+		stats, statuses := registry.Collect(context.Background(), now)
+		// The slow collector doesn't delay the others
+		assert.True(t, time.Since(start) < 5*tick)
+		assert.Equal(t, []float64{10}, stats.CPUPercents)
+		for j := range statuses {
+			if statuses[j].Name == "diskUsage" {
+				diskStatus = &statuses[j]
+				diskUsage = stats.DiskUsage
+			}
+		}
+		now = now.Add(tick)
+		time.Sleep(tick)
+	}
+
+	if assert.NotNil(t, diskStatus) {
+		assert.False(t, diskStatus.TimedOut)
+		assert.False(t, diskStatus.Skipped)
+		assert.Equal(t, uint64(0), diskStatus.Timeouts)
+		assert.Equal(t, []*disk.UsageStat{{Path: "/"}}, diskUsage)
+	}
+}
+
+func TestCollectOnce(t *testing.T) {
+	poolID, nodeID, samplingRate := "", "", "100ms"
+	config, err := batchinsights.ValidateAndBuildConfig(batchinsights.UserConfig{
+		PoolID:       &poolID,
+		NodeID:       &nodeID,
+		SamplingRate: &samplingRate,
+		Intervals:    []string{"memory=1m"},
+		Disable:      []string{"gpu", "diskUsage", "diskIO", "networkIO", "cgroups", "events"},
+	})
+	assert.Nil(t, err)
+
+	var diagnostics, out bytes.Buffer
+	stats := batchinsights.CollectOnce(config, &diagnostics)
+	// The longer interval is ignored, every collector reports
+	assert.NotNil(t, stats.Memory)
+	assert.NotEqual(t, 0, len(stats.CPUPercents))
+	for _, status := range stats.Collectors {
+		if status.Name == "cpu" || status.Name == "memory" {
+			assert.False(t, status.Failed(), status.String())
+		}
+	}
+
+	// Only the sample is written to the output
+	assert.Nil(t, batchinsights.PrintSample(&out, stats, batchinsights.FormatJSON))
+	var printed batchinsights.NodeStats
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &printed))
+	assert.Equal(t, stats.CPUPercents, printed.CPUPercents)
+}
+
+func TestCollectorRegistryMixedIntervals(t *testing.T) {
+	registry := batchinsights.NewCollectorRegistry(5*time.Second, map[string]time.Duration{"cpu": 2 * time.Second, "gpu": 1500 * time.Millisecond})
+	runs := make(map[string][]time.Duration)
+	start := time.Now()
+	for _, name := range []string{"cpu", "gpu", "memory"} {
+		registry.Register(batchinsights.NewCollector(name, true, func(ctx context.Context, stats *batchinsights.NodeStats) error {
+			return nil
+		}), 0)
+	}
+
+	// Every interval is a multiple of the tick
+	tick := registry.TickInterval()
+	assert.Equal(t, 500*time.Millisecond, tick)
+	for now := time.Duration(0); now <= 10*time.Second; now += tick {
+		_, statuses := registry.Collect(context.Background(), start.Add(now))
+		for _, status := range statuses {
+			runs[status.Name] = append(runs[status.Name], now)
+		}
+	}
+	assert.Equal(t, []time.Duration{0, 2 * time.Second, 4 * time.Second, 6 * time.Second, 8 * time.Second, 10 * time.Second}, runs["cpu"])
+	assert.Equal(t, []time.Duration{0, 1500 * time.Millisecond, 3 * time.Second, 4500 * time.Millisecond, 6 * time.Second, 7500 * time.Millisecond, 9 * time.Second}, runs["gpu"])
+	assert.Equal(t, []time.Duration{0, 5 * time.Second, 10 * time.Second}, runs["memory"])
+}
@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Azure/batch-insights/pkg/cgroup"
 )
 
 // DefaultAggregationTime default time range where metrics are preaggregated locally
@@ -14,6 +18,15 @@ const DefaultAggregationTime = time.Duration(1) * time.Minute
 // DefaultSamplingRate default time between metrics sampling
 const DefaultSamplingRate = time.Duration(5) * time.Second
 
+// MinSamplingRate shortest time allowed between two samples of a collector
+const MinSamplingRate = 100 * time.Millisecond
+
+// DefaultProcRoot default mount point of procfs
+const DefaultProcRoot = "/proc"
+
+// DefaultSysRoot default mount point of sysfs
+const DefaultSysRoot = "/sys"
+
 // UserConfig config provided by the user either via command line, file or environemnt variable.
 type UserConfig struct {
 	PoolID             *string
@@ -22,6 +35,20 @@ type UserConfig struct {
 	Processes          []string // List of process names to watch
 	Aggregation        *int     // Local aggregation of data in minutes (default: 1)
 	Disable            []string // List of metrics to disable
+	ProcRoot           *string  // Path where procfs is mounted (default: /proc)
+	CgroupRoot         *string  // Path where the cgroup filesystem is mounted (default: /sys/fs/cgroup)
+	SysRoot            *string  // Path where sysfs is mounted (default: /sys)
+	SimulatedGPUs      *int     // Number of simulated GPUs to report instead of querying the Nvidia driver
+	SamplingRate       *string  // Default time between samples. e.g. 5s (default: 5s)
+	Intervals          []string // Sampling interval of specific collectors. e.g. cpu=1s,diskUsage=1m
+	HTTPAddress        *string  // Address of the local HTTP API. e.g. 127.0.0.1:9471 or 9471 (default: disabled)
+	History            *string  // Time range of samples kept in memory for the HTTP API. e.g. 10m (default: 10m)
+	TUI                *bool    // Show a live dashboard in the terminal instead of printing the samples
+	Alerts             *string  // Path of a JSON file with the alert rules
+	IdleWindow         *string  // Time range over which the node utilization is averaged to detect idle nodes. e.g. 5m (default: 5m)
+	Record             *string  // Path of a file where the raw samples of the collectors are appended
+	Replay             *string  // Path of a recording replayed instead of running the collectors
+	ReplaySpeed        *float64 // Speed of the replay, 0 to replay as fast as possible (default: 1)
 }
 
 // Print print the config to console
@@ -35,6 +62,40 @@ func (config UserConfig) Print() {
 	fmt.Printf("   Aggregation: %d\n", *config.Aggregation)
 	fmt.Printf("   Disable: %v\n", config.Disable)
 	fmt.Printf("   Monitoring processes: %v\n", config.Processes)
+	if config.ProcRoot != nil {
+		fmt.Printf("   Proc root: %s\n", *config.ProcRoot)
+	}
+	if config.CgroupRoot != nil {
+		fmt.Printf("   Cgroup root: %s\n", *config.CgroupRoot)
+	}
+	if config.SysRoot != nil {
+		fmt.Printf("   Sys root: %s\n", *config.SysRoot)
+	}
+	if config.SamplingRate != nil {
+		fmt.Printf("   Sampling rate: %s\n", *config.SamplingRate)
+	}
+	fmt.Printf("   Intervals: %v\n", config.Intervals)
+	if config.HTTPAddress != nil {
+		fmt.Printf("   HTTP address: %s\n", *config.HTTPAddress)
+	}
+	if config.History != nil {
+		fmt.Printf("   History: %s\n", *config.History)
+	}
+	if config.Alerts != nil {
+		fmt.Printf("   Alerts: %s\n", *config.Alerts)
+	}
+	if config.IdleWindow != nil {
+		fmt.Printf("   Idle window: %s\n", *config.IdleWindow)
+	}
+	if config.Record != nil {
+		fmt.Printf("   Record: %s\n", *config.Record)
+	}
+	if config.Replay != nil {
+		fmt.Printf("   Replay: %s\n", *config.Replay)
+	}
+	if config.ReplaySpeed != nil {
+		fmt.Printf("   Replay speed: %g\n", *config.ReplaySpeed)
+	}
 }
 
 // Merge with another config
@@ -57,17 +118,68 @@ func (config UserConfig) Merge(other UserConfig) UserConfig {
 	if len(other.Disable) > 0 {
 		config.Disable = other.Disable
 	}
+	if other.ProcRoot != nil && *other.ProcRoot != "" {
+		config.ProcRoot = other.ProcRoot
+	}
+	if other.CgroupRoot != nil && *other.CgroupRoot != "" {
+		config.CgroupRoot = other.CgroupRoot
+	}
+	if other.SysRoot != nil && *other.SysRoot != "" {
+		config.SysRoot = other.SysRoot
+	}
+	if other.SimulatedGPUs != nil && *other.SimulatedGPUs > 0 {
+		config.SimulatedGPUs = other.SimulatedGPUs
+	}
+	if other.SamplingRate != nil && *other.SamplingRate != "" {
+		config.SamplingRate = other.SamplingRate
+	}
+	if len(other.Intervals) > 0 {
+		config.Intervals = other.Intervals
+	}
+	if other.HTTPAddress != nil && *other.HTTPAddress != "" {
+		config.HTTPAddress = other.HTTPAddress
+	}
+	if other.History != nil && *other.History != "" {
+		config.History = other.History
+	}
+	if other.TUI != nil && *other.TUI {
+		config.TUI = other.TUI
+	}
+	if other.Alerts != nil && *other.Alerts != "" {
+		config.Alerts = other.Alerts
+	}
+	if other.IdleWindow != nil && *other.IdleWindow != "" {
+		config.IdleWindow = other.IdleWindow
+	}
+	if other.Record != nil && *other.Record != "" {
+		config.Record = other.Record
+	}
+	if other.Replay != nil && *other.Replay != "" {
+		config.Replay = other.Replay
+	}
+	if other.ReplaySpeed != nil {
+		config.ReplaySpeed = other.ReplaySpeed
+	}
 	return config
 }
 
 // DisableConfig config showing which feature are disabled
 type DisableConfig struct {
-	DiskIO    bool `json:"diskIO"`
-	DiskUsage bool `json:"diskUsage"`
-	NetworkIO bool `json:"networkIO"`
-	GPU       bool `json:"gpu"`
-	CPU       bool `json:"cpu"`
-	Memory    bool `json:"memory"`
+	DiskIO       bool `json:"diskIO"`
+	DiskUsage    bool `json:"diskUsage"`
+	NetworkIO    bool `json:"networkIO"`
+	GPU          bool `json:"gpu"`
+	CPU          bool `json:"cpu"`
+	CPUTimes     bool `json:"cpuTimes"`
+	CPUFrequency bool `json:"cpuFrequency"`
+	Memory       bool `json:"memory"`
+	Load         bool `json:"load"`
+	Cgroups      bool `json:"cgroups"`
+	InfiniBand   bool `json:"infiniband"`
+	NFS          bool `json:"nfs"`
+	Netstat      bool `json:"netstat"`
+	Events       bool `json:"events"`
+	Idle         bool `json:"idle"`
 }
 
 func (d DisableConfig) String() string {
@@ -84,6 +196,20 @@ type Config struct {
 	Aggregation        time.Duration
 	SamplingRate       time.Duration
 	Disable            DisableConfig
+	ProcRoot           string
+	CgroupRoot         string
+	SysRoot            string
+	SimulatedGPUs      int
+	Intervals          map[string]time.Duration // Sampling interval by lowercase collector name, SamplingRate is used for the others
+	HTTPAddress        string                   // Address of the local HTTP API, empty when disabled
+	History            time.Duration            // Time range of samples kept in memory for the HTTP API
+	TUI                bool                     // Show a live dashboard in the terminal
+	Alerts             string                   // Path of the alert rules file, empty when disabled
+	AlertRules         []AlertRule              `json:"-"` // Actions may contain secrets, e.g. webhook URLs
+	IdleWindow         time.Duration            // Time range over which the node utilization is averaged to detect idle nodes
+	Record             string                   // File where the raw samples are recorded, empty when disabled
+	Replay             string                   // Recording replayed instead of running the collectors, empty when disabled
+	ReplaySpeed        float64                  // 1 replays in real time, 0 as fast as possible
 }
 
 // Print print the config to console
@@ -93,9 +219,34 @@ func (config Config) Print() {
 	fmt.Printf("   Node ID: %s\n", config.NodeID)
 	fmt.Printf("   Instrumentation Key: %s\n", hideSecret(config.InstrumentationKey))
 	fmt.Printf("   Aggregation: %v\n", config.Aggregation)
-	fmt.Printf("   Sampling rate: %d\n", config.SamplingRate)
+	fmt.Printf("   Sampling rate: %v\n", config.SamplingRate)
+	if len(config.Intervals) > 0 {
+		fmt.Printf("   Intervals: %v\n", config.Intervals)
+	}
+	if config.HTTPAddress != "" {
+		fmt.Printf("   HTTP address: %s\n", config.HTTPAddress)
+		fmt.Printf("   History: %v\n", config.History)
+	}
+	if config.Alerts != "" {
+		fmt.Printf("   Alerts: %s (%d rules)\n", config.Alerts, len(config.AlertRules))
+	}
+	if !config.Disable.Idle {
+		fmt.Printf("   Idle window: %v\n", config.IdleWindow)
+	}
+	if config.Record != "" {
+		fmt.Printf("   Record: %s\n", config.Record)
+	}
+	if config.Replay != "" {
+		fmt.Printf("   Replay: %s (speed: %g)\n", config.Replay, config.ReplaySpeed)
+	}
 	fmt.Printf("   Disable: %+v\n", config.Disable)
 	fmt.Printf("   Monitoring processes: %v\n", config.Processes)
+	fmt.Printf("   Proc root: %s\n", config.ProcRoot)
+	fmt.Printf("   Cgroup root: %s\n", config.CgroupRoot)
+	fmt.Printf("   Sys root: %s\n", config.SysRoot)
+	if config.SimulatedGPUs > 0 {
+		fmt.Printf("   Simulated GPUs: %d\n", config.SimulatedGPUs)
+	}
 }
 
 // ValidateAndBuildConfig Convert Batch insights user config into config taken by the library
@@ -112,6 +263,84 @@ func ValidateAndBuildConfig(userConfig UserConfig) (Config, error) {
 	if userConfig.InstrumentationKey != nil {
 		key = *userConfig.InstrumentationKey
 	}
+	procRoot := DefaultProcRoot
+	if userConfig.ProcRoot != nil && *userConfig.ProcRoot != "" {
+		procRoot = *userConfig.ProcRoot
+	}
+	cgroupRoot := cgroup.DefaultRoot
+	if userConfig.CgroupRoot != nil && *userConfig.CgroupRoot != "" {
+		cgroupRoot = *userConfig.CgroupRoot
+	}
+	sysRoot := DefaultSysRoot
+	if userConfig.SysRoot != nil && *userConfig.SysRoot != "" {
+		sysRoot = *userConfig.SysRoot
+	}
+	samplingRate := DefaultSamplingRate
+	if userConfig.SamplingRate != nil && *userConfig.SamplingRate != "" {
+		rate, err := parseInterval(*userConfig.SamplingRate)
+		if err != nil {
+			return Config{}, fmt.Errorf("Invalid sampling rate: %v", err)
+		}
+		samplingRate = rate
+	}
+	intervals, err := parseIntervals(userConfig.Intervals)
+	if err != nil {
+		return Config{}, err
+	}
+	httpAddress := ""
+	if userConfig.HTTPAddress != nil && *userConfig.HTTPAddress != "" {
+		httpAddress, err = parseHTTPAddress(*userConfig.HTTPAddress)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	history := DefaultHistoryRetention
+	if userConfig.History != nil && *userConfig.History != "" {
+		retention, err := parseInterval(*userConfig.History)
+		if err != nil {
+			return Config{}, fmt.Errorf("Invalid history: %v", err)
+		}
+		history = retention
+	}
+	idleWindow := DefaultIdleWindow
+	if userConfig.IdleWindow != nil && *userConfig.IdleWindow != "" {
+		window, err := parseInterval(*userConfig.IdleWindow)
+		if err != nil {
+			return Config{}, fmt.Errorf("Invalid idle window: %v", err)
+		}
+		idleWindow = window
+	}
+	record := ""
+	if userConfig.Record != nil {
+		record = *userConfig.Record
+	}
+	replay := ""
+	if userConfig.Replay != nil {
+		replay = *userConfig.Replay
+	}
+	if record != "" && replay != "" {
+		return Config{}, errors.New("Record and replay can't be used together")
+	}
+	replaySpeed := 1.0
+	if userConfig.ReplaySpeed != nil {
+		if *userConfig.ReplaySpeed < 0 {
+			return Config{}, fmt.Errorf("Invalid replay speed %g", *userConfig.ReplaySpeed)
+		}
+		replaySpeed = *userConfig.ReplaySpeed
+	}
+	alerts := ""
+	var alertRules []AlertRule
+	if userConfig.Alerts != nil && *userConfig.Alerts != "" {
+		alerts = *userConfig.Alerts
+		alertRules, err = LoadAlertRules(alerts)
+		if err != nil {
+			return Config{}, fmt.Errorf("Invalid alert rules %s: %v", alerts, err)
+		}
+	}
+	simulatedGPUs := 0
+	if userConfig.SimulatedGPUs != nil {
+		simulatedGPUs = *userConfig.SimulatedGPUs
+	}
 	return Config{
 		PoolID:             *userConfig.PoolID,
 		NodeID:             *userConfig.NodeID,
@@ -119,10 +348,76 @@ func ValidateAndBuildConfig(userConfig UserConfig) (Config, error) {
 		Processes:          userConfig.Processes,
 		Aggregation:        aggregation,
 		Disable:            parseDisableConfig(userConfig.Disable),
-		SamplingRate:       DefaultSamplingRate,
+		SamplingRate:       samplingRate,
+		ProcRoot:           procRoot,
+		CgroupRoot:         cgroupRoot,
+		SysRoot:            sysRoot,
+		SimulatedGPUs:      simulatedGPUs,
+		Intervals:          intervals,
+		HTTPAddress:        httpAddress,
+		History:            history,
+		TUI:                userConfig.TUI != nil && *userConfig.TUI,
+		Alerts:             alerts,
+		AlertRules:         alertRules,
+		IdleWindow:         idleWindow,
+		Record:             record,
+		Replay:             replay,
+		ReplaySpeed:        replaySpeed,
 	}, nil
 }
 
+// parseHTTPAddress parse the address of the HTTP API. The API has no authentication so it only listens on a loopback
+// address. A port alone listens on 127.0.0.1
+func parseHTTPAddress(value string) (string, error) {
+	if _, err := strconv.Atoi(value); err == nil {
+		return DefaultHTTPAddress + ":" + value, nil
+	}
+	host, _, err := net.SplitHostPort(value)
+	if err != nil {
+		return "", fmt.Errorf("Invalid HTTP address: %v", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("Invalid HTTP address %q, only loopback addresses are allowed. e.g. 127.0.0.1:9471", value)
+	}
+	return value, nil
+}
+
+// parseIntervals parse a list of collector intervals. e.g. ["cpu=1s", "diskUsage=1m"]
+func parseIntervals(values []string) (map[string]time.Duration, error) {
+	intervals := make(map[string]time.Duration)
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid interval %q, expected <collector>=<duration>", value)
+		}
+		interval, err := parseInterval(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid interval for %s: %v", parts[0], err)
+		}
+		intervals[strings.ToLower(parts[0])] = interval
+	}
+	return intervals, nil
+}
+
+// parseInterval parse a duration. A plain number is a number of seconds
+func parseInterval(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		value = strconv.Itoa(seconds) + "s"
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if interval < MinSamplingRate {
+		return 0, fmt.Errorf("%v is below the minimum of %v", interval, MinSamplingRate)
+	}
+	return interval, nil
+}
+
 func parseAggregation(value *int) time.Duration {
 	if value == nil {
 		return DefaultAggregationTime
@@ -136,12 +431,21 @@ func parseDisableConfig(values []string) DisableConfig {
 		disableMap[strings.ToLower(key)] = true
 	}
 	return DisableConfig{
-		DiskIO:    disableMap["diskio"],
-		DiskUsage: disableMap["diskusage"],
-		NetworkIO: disableMap["networkio"],
-		GPU:       disableMap["gpu"],
-		CPU:       disableMap["cpu"],
-		Memory:    disableMap["memory"],
+		DiskIO:       disableMap["diskio"],
+		DiskUsage:    disableMap["diskusage"],
+		NetworkIO:    disableMap["networkio"],
+		GPU:          disableMap["gpu"],
+		CPU:          disableMap["cpu"],
+		CPUTimes:     disableMap["cputimes"],
+		CPUFrequency: disableMap["cpufrequency"],
+		Memory:       disableMap["memory"],
+		Load:         disableMap["load"],
+		Cgroups:      disableMap["cgroups"],
+		InfiniBand:   disableMap["infiniband"],
+		NFS:          disableMap["nfs"],
+		Netstat:      disableMap["netstat"],
+		Events:       disableMap["events"],
+		Idle:         disableMap["idle"],
 	}
 }
 
@@ -4,6 +4,7 @@ import (
 	"github.com/Azure/batch-insights/pkg"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestBuildConfig(t *testing.T) {
@@ -42,3 +43,62 @@ func TestBuildConfig(t *testing.T) {
 	assert.Equal(t, false, result.Disable.Memory)
 	assert.Equal(t, false, result.Disable.GPU)
 }
+
+func TestBuildConfigIntervals(t *testing.T) {
+	pool1 := "pool-1"
+	node1 := "node-1"
+	rate := "2s"
+
+	result, err := batchinsights.ValidateAndBuildConfig(batchinsights.UserConfig{
+		PoolID:       &pool1,
+		NodeID:       &node1,
+		SamplingRate: &rate,
+		Intervals:    []string{"cpu=1s", "diskUsage=1m", "processes=15"},
+	})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2*time.Second, result.SamplingRate)
+	assert.Equal(t, map[string]time.Duration{"cpu": time.Second, "diskusage": time.Minute, "processes": 15 * time.Second}, result.Intervals)
+
+	_, err = batchinsights.ValidateAndBuildConfig(batchinsights.UserConfig{
+		PoolID:    &pool1,
+		NodeID:    &node1,
+		Intervals: []string{"cpu"},
+	})
+	assert.Error(t, err)
+
+	_, err = batchinsights.ValidateAndBuildConfig(batchinsights.UserConfig{
+		PoolID:    &pool1,
+		NodeID:    &node1,
+		Intervals: []string{"gpu=1ms"},
+	})
+	assert.Error(t, err)
+}
+
+func TestBuildConfigHTTPAddress(t *testing.T) {
+	pool1 := "pool-1"
+	node1 := "node-1"
+	tests := []struct {
+		address  string
+		expected string
+	}{
+		{"9471", "127.0.0.1:9471"},
+		{"127.0.0.1:9471", "127.0.0.1:9471"},
+		{"localhost:9471", "localhost:9471"},
+		{"[::1]:9471", "[::1]:9471"},
+		{":9471", ""},
+		{"0.0.0.0:9471", ""},
+		{"10.0.0.4:9471", ""},
+		{"node:9471", ""},
+	}
+	for _, test := range tests {
+		address := test.address
+		result, err := batchinsights.ValidateAndBuildConfig(batchinsights.UserConfig{PoolID: &pool1, NodeID: &node1, HTTPAddress: &address})
+		if test.expected == "" {
+			assert.Error(t, err, test.address)
+		} else {
+			assert.Nil(t, err, test.address)
+			assert.Equal(t, test.expected, result.HTTPAddress)
+		}
+	}
+}
@@ -1 +1,146 @@
 package cpu
+
+import (
+	"context"
+	"strings"
+
+	psutils_cpu "github.com/shirou/gopsutil/cpu"
+)
+
+// TimesPercent share of the cpu time spent in each mode between 2 samples(in percent)
+type TimesPercent struct {
+	CPU     string
+	User    float64
+	Nice    float64
+	System  float64
+	Idle    float64
+	Iowait  float64
+	Irq     float64
+	Softirq float64
+	Steal   float64
+	Guest   float64
+}
+
+// ModeTime share of the cpu time spent in a single mode
+type ModeTime struct {
+	Mode    string
+	Percent float64
+}
+
+// Modes list the time spent in each mode supported by the current platform
+func (times TimesPercent) Modes() []ModeTime {
+	values := map[string]float64{
+		"user":    times.User,
+		"nice":    times.Nice,
+		"system":  times.System,
+		"idle":    times.Idle,
+		"iowait":  times.Iowait,
+		"irq":     times.Irq,
+		"softirq": times.Softirq,
+		"steal":   times.Steal,
+		"guest":   times.Guest,
+	}
+	var modes []ModeTime
+	for _, mode := range supportedModes {
+		modes = append(modes, ModeTime{Mode: mode, Percent: values[mode]})
+	}
+	return modes
+}
+
+// TimesBreakdown cpu time breakdown for the whole node and for each core
+type TimesBreakdown struct {
+	Total  TimesPercent
+	PerCPU []TimesPercent
+}
+
+// TimesAggregator compute the cpu time breakdown between consecutive samples
+type TimesAggregator struct {
+	last map[string]psutils_cpu.TimesStat
+}
+
+var timesAggregator = TimesAggregator{}
+
+// Times Retrieve the cpu time breakdown since the last call. Returns nil on the first call
+func Times(ctx context.Context) (*TimesBreakdown, error) {
+	times, err := psutils_cpu.TimesWithContext(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return timesAggregator.Update(times), nil
+}
+
+// Update register the latest per cpu times and compute the breakdown since the previous update
+func (aggregator *TimesAggregator) Update(times []psutils_cpu.TimesStat) *TimesBreakdown {
+	if aggregator.last == nil {
+		aggregator.last = make(map[string]psutils_cpu.TimesStat)
+	}
+
+	var breakdown TimesBreakdown
+	var previousTotal, currentTotal psutils_cpu.TimesStat
+	complete := len(times) > 0
+
+	for _, current := range times {
+		if strings.Contains(current.CPU, "_Total") {
+			continue
+		}
+		previous, ok := aggregator.last[current.CPU]
+		aggregator.last[current.CPU] = current
+		if !ok {
+			complete = false
+			continue
+		}
+		breakdown.PerCPU = append(breakdown.PerCPU, TimesPercentBetween(previous, current))
+		previousTotal = addTimes(previousTotal, previous)
+		currentTotal = addTimes(currentTotal, current)
+	}
+
+	if !complete {
+		return nil
+	}
+	breakdown.Total = TimesPercentBetween(previousTotal, currentTotal)
+	breakdown.Total.CPU = "total"
+	return &breakdown
+}
+
+// TimesPercentBetween compute the share of time spent in each mode between 2 samples of the same cpu
+func TimesPercentBetween(previous psutils_cpu.TimesStat, current psutils_cpu.TimesStat) TimesPercent {
+	// Guest time is already accounted in user time
+	total := busyTime(current) + current.Idle - busyTime(previous) - previous.Idle
+	percent := func(previous float64, current float64) float64 {
+		if total <= 0 || current < previous {
+			return 0
+		}
+		return (current - previous) / total * 100
+	}
+
+	return TimesPercent{
+		CPU:     current.CPU,
+		User:    percent(previous.User, current.User),
+		Nice:    percent(previous.Nice, current.Nice),
+		System:  percent(previous.System, current.System),
+		Idle:    percent(previous.Idle, current.Idle),
+		Iowait:  percent(previous.Iowait, current.Iowait),
+		Irq:     percent(previous.Irq, current.Irq),
+		Softirq: percent(previous.Softirq, current.Softirq),
+		Steal:   percent(previous.Steal, current.Steal),
+		Guest:   percent(previous.Guest, current.Guest),
+	}
+}
+
+func busyTime(times psutils_cpu.TimesStat) float64 {
+	return times.User + times.Nice + times.System + times.Iowait + times.Irq + times.Softirq + times.Steal
+}
+
+func addTimes(a psutils_cpu.TimesStat, b psutils_cpu.TimesStat) psutils_cpu.TimesStat {
+	return psutils_cpu.TimesStat{
+		User:    a.User + b.User,
+		Nice:    a.Nice + b.Nice,
+		System:  a.System + b.System,
+		Idle:    a.Idle + b.Idle,
+		Iowait:  a.Iowait + b.Iowait,
+		Irq:     a.Irq + b.Irq,
+		Softirq: a.Softirq + b.Softirq,
+		Steal:   a.Steal + b.Steal,
+		Guest:   a.Guest + b.Guest,
+	}
+}
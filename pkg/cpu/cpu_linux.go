@@ -3,9 +3,13 @@
 package cpu
 
 import (
+	"context"
+
 	psutils_cpu "github.com/shirou/gopsutil/cpu"
 )
 
-func PerCpuPercent() ([]float64, error) {
-	return psutils_cpu.Percent(0, true)
+var supportedModes = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal", "guest"}
+
+func PerCpuPercent(ctx context.Context) ([]float64, error) {
+	return psutils_cpu.PercentWithContext(ctx, 0, true)
 }
@@ -0,0 +1,70 @@
+package cpu_test
+
+import (
+	"testing"
+
+	"github.com/Azure/batch-insights/pkg/cpu"
+	psutils_cpu "github.com/shirou/gopsutil/cpu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimesPercentBetween(t *testing.T) {
+	previous := psutils_cpu.TimesStat{CPU: "cpu0", User: 100, System: 50, Idle: 800, Iowait: 10, Steal: 5}
+	current := psutils_cpu.TimesStat{CPU: "cpu0", User: 140, System: 60, Idle: 830, Iowait: 20, Steal: 15, Guest: 5}
+
+	times := cpu.TimesPercentBetween(previous, current)
+
+	assert.Equal(t, "cpu0", times.CPU)
+	assert.Equal(t, float64(40), times.User)
+	assert.Equal(t, float64(10), times.System)
+	assert.Equal(t, float64(30), times.Idle)
+	assert.Equal(t, float64(10), times.Iowait)
+	assert.Equal(t, float64(10), times.Steal)
+	assert.Equal(t, float64(5), times.Guest)
+}
+
+func TestTimesAggregator(t *testing.T) {
+	aggregator := cpu.TimesAggregator{}
+
+	breakdown := aggregator.Update([]psutils_cpu.TimesStat{
+		{CPU: "cpu0", User: 10, Idle: 10},
+		{CPU: "cpu1", User: 10, Idle: 10},
+	})
+	assert.Nil(t, breakdown)
+
+	breakdown = aggregator.Update([]psutils_cpu.TimesStat{
+		{CPU: "cpu0", User: 20, Idle: 10},
+		{CPU: "cpu1", User: 10, Idle: 20},
+	})
+	assert.NotNil(t, breakdown)
+	assert.Equal(t, 2, len(breakdown.PerCPU))
+	assert.Equal(t, float64(100), breakdown.PerCPU[0].User)
+	assert.Equal(t, float64(100), breakdown.PerCPU[1].Idle)
+	assert.Equal(t, "total", breakdown.Total.CPU)
+	assert.Equal(t, float64(50), breakdown.Total.User)
+	assert.Equal(t, float64(50), breakdown.Total.Idle)
+}
+
+func TestFrequencyStats(t *testing.T) {
+	collector := cpu.NewFrequencyCollector("testdata/sys")
+
+	stats := collector.GetStats()
+
+	assert.NotNil(t, stats)
+	// cpu2 doesn't expose cpufreq nor throttle counters
+	assert.Equal(t, 2, len(stats.Cores))
+	assert.Equal(t, 0, stats.Cores[0].CPU)
+	assert.Equal(t, 1200.0, stats.Cores[0].CurrentMHz)
+	assert.Equal(t, 50.0, stats.Cores[0].PercentOfMax())
+	assert.Equal(t, uint64(0), stats.Cores[0].CoreThrottles)
+	assert.Equal(t, 2400.0, stats.Cores[1].CurrentMHz)
+
+	// thermal_zone1 has no readable temperature
+	assert.Equal(t, []cpu.ThermalZone{{Zone: "thermal_zone0", Type: "x86_pkg_temp", Temperature: 67}}, stats.Thermal)
+}
+
+func TestFrequencyStatsMissingSysfs(t *testing.T) {
+	collector := cpu.NewFrequencyCollector("testdata/missing")
+
+	assert.Nil(t, collector.GetStats())
+}
@@ -12,6 +12,8 @@ type CPUStat struct {
 	timestamp uint64
 }
 
+var supportedModes = []string{"user", "system", "idle", "irq"}
+
 var lastCpus map[string]CPUStat = make(map[string]CPUStat)
 
 type win32_PerfRawData_Counters_ProcessorInformation struct {
@@ -29,8 +31,8 @@ type win32_PerfRawData_Counters_ProcessorInformation struct {
 	DPCRate               uint32
 }
 
-func PerCpuPercent() ([]float64, error) {
-	return perCPUPercentWithContext(context.Background())
+func PerCpuPercent(ctx context.Context) ([]float64, error) {
+	return perCPUPercentWithContext(ctx)
 }
 
 func perCPUPercentWithContext(ctx context.Context) ([]float64, error) {
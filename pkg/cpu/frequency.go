@@ -0,0 +1,157 @@
+package cpu
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/Azure/batch-insights/pkg/utils"
+)
+
+var cpuDirPattern = regexp.MustCompile(`^cpu([0-9]+)$`)
+
+// FrequencyStats clock speed, throttling and temperatures of the CPUs. Values not exposed by the kernel are left empty
+type FrequencyStats struct {
+	Cores   []CoreFrequency
+	Thermal []ThermalZone
+}
+
+// CoreFrequency clock speed and throttling of a single core
+type CoreFrequency struct {
+	CPU              int
+	CurrentMHz       float64
+	MaxMHz           float64 // 0 when unknown
+	CoreThrottles    uint64  // Number of times the core was throttled since the last sample
+	PackageThrottles uint64  // Number of times the package of the core was throttled since the last sample
+}
+
+// ThermalZone temperature of a thermal zone
+type ThermalZone struct {
+	Zone        string // e.g. thermal_zone0
+	Type        string // e.g. x86_pkg_temp
+	Temperature float64
+}
+
+// PercentOfMax current frequency as a percent of the maximum frequency. 0 when the maximum is unknown
+func (core CoreFrequency) PercentOfMax() float64 {
+	if core.MaxMHz == 0 {
+		return 0
+	}
+	return core.CurrentMHz / core.MaxMHz * 100
+}
+
+// FrequencyCollector collector that retrieve the CPU frequencies, throttle counters and thermal zones from sysfs
+type FrequencyCollector struct {
+	sysRoot   string
+	throttles map[string]uint64
+}
+
+// NewFrequencyCollector Create a new frequency collector reading sysfs at the given root
+func NewFrequencyCollector(sysRoot string) *FrequencyCollector {
+	return &FrequencyCollector{
+		sysRoot:   sysRoot,
+		throttles: make(map[string]uint64),
+	}
+}
+
+// GetStats Get the frequency stats. Returns nil when sysfs exposes neither cpufreq nor thermal zones(e.g. most VMs)
+func (collector *FrequencyCollector) GetStats() *FrequencyStats {
+	stats := FrequencyStats{
+		Cores:   collector.readCores(),
+		Thermal: collector.readThermalZones(),
+	}
+	if len(stats.Cores) == 0 && len(stats.Thermal) == 0 {
+		return nil
+	}
+	return &stats
+}
+
+func (collector *FrequencyCollector) readCores() []CoreFrequency {
+	cpuRoot := filepath.Join(collector.sysRoot, "devices", "system", "cpu")
+	entries, err := ioutil.ReadDir(cpuRoot)
+	if err != nil {
+		return nil
+	}
+
+	var cores []CoreFrequency
+	for _, entry := range entries {
+		match := cpuDirPattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		dir := filepath.Join(cpuRoot, entry.Name())
+		core := CoreFrequency{}
+		core.CPU, _ = strconv.Atoi(match[1])
+
+		current, err := readKHz(filepath.Join(dir, "cpufreq", "scaling_cur_freq"))
+		if err != nil {
+			current, err = readKHz(filepath.Join(dir, "cpufreq", "cpuinfo_cur_freq"))
+		}
+		hasFrequency := err == nil
+		core.CurrentMHz = current
+		core.MaxMHz, _ = readKHz(filepath.Join(dir, "cpufreq", "cpuinfo_max_freq"))
+
+		coreThrottles, coreErr := utils.ReadUint(filepath.Join(dir, "thermal_throttle", "core_throttle_count"))
+		packageThrottles, packageErr := utils.ReadUint(filepath.Join(dir, "thermal_throttle", "package_throttle_count"))
+		if coreErr == nil {
+			core.CoreThrottles = collector.delta(entry.Name()+"/core", coreThrottles)
+		}
+		if packageErr == nil {
+			core.PackageThrottles = collector.delta(entry.Name()+"/package", packageThrottles)
+		}
+
+		if hasFrequency || coreErr == nil || packageErr == nil {
+			cores = append(cores, core)
+		}
+	}
+
+	sort.Slice(cores, func(i, j int) bool {
+		return cores[i].CPU < cores[j].CPU
+	})
+	return cores
+}
+
+func (collector *FrequencyCollector) readThermalZones() []ThermalZone {
+	zones, _ := filepath.Glob(filepath.Join(collector.sysRoot, "class", "thermal", "thermal_zone*"))
+
+	var result []ThermalZone
+	for _, dir := range zones {
+		content, err := utils.ReadString(filepath.Join(dir, "temp"))
+		if err != nil {
+			// Some zones can't be read while the sensor is disabled
+			continue
+		}
+		// Millidegrees, can be negative
+		temperature, err := strconv.ParseInt(content, 10, 64)
+		if err != nil {
+			continue
+		}
+		zoneType, _ := utils.ReadString(filepath.Join(dir, "type"))
+		result = append(result, ThermalZone{
+			Zone:        filepath.Base(dir),
+			Type:        zoneType,
+			Temperature: float64(temperature) / 1000,
+		})
+	}
+	return result
+}
+
+// delta increase of a throttle counter since the last sample. The first value is only used as a baseline
+func (collector *FrequencyCollector) delta(key string, value uint64) uint64 {
+	last, ok := collector.throttles[key]
+	collector.throttles[key] = value
+	if !ok || value < last {
+		return 0
+	}
+	return value - last
+}
+
+func readKHz(path string) (float64, error) {
+	value, err := utils.ReadUint(path)
+	if err != nil {
+		return 0, err
+	}
+	return float64(value) / 1000, nil
+}
@@ -0,0 +1,449 @@
+package batchinsights
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	colorable "github.com/mattn/go-colorable"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Size used when the output isn't a terminal
+const (
+	defaultDashboardWidth  = 100
+	defaultDashboardHeight = 50
+)
+
+// Number of values kept for the sparklines
+const sparklineLength = 30
+
+const (
+	ansiReset       = "\x1b[0m"
+	ansiBold        = "\x1b[1m"
+	ansiRed         = "\x1b[31m"
+	ansiGreen       = "\x1b[32m"
+	ansiYellow      = "\x1b[33m"
+	ansiCyan        = "\x1b[36m"
+	ansiHome        = "\x1b[H"
+	ansiClearLine   = "\x1b[K"
+	ansiClearScreen = "\x1b[J"
+	ansiHideCursor  = "\x1b[?25l"
+	ansiShowCursor  = "\x1b[?25h"
+	ansiAltScreen   = "\x1b[?1049h"
+	ansiMainScreen  = "\x1b[?1049l"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// ProcessSort column used to sort the process table of the dashboard
+type ProcessSort int
+
+const (
+	// SortByCPU sort the processes by CPU usage
+	SortByCPU ProcessSort = iota
+	// SortByMemory sort the processes by memory usage
+	SortByMemory
+	// SortByGPUMemory sort the processes by GPU memory usage
+	SortByGPUMemory
+	// SortByPID sort the processes by PID
+	SortByPID
+	// SortByName sort the processes by name
+	SortByName
+)
+
+var processSortNames = map[ProcessSort]string{
+	SortByCPU:       "cpu",
+	SortByMemory:    "memory",
+	SortByGPUMemory: "gpu memory",
+	SortByPID:       "pid",
+	SortByName:      "name",
+}
+
+func (sort ProcessSort) String() string {
+	return processSortNames[sort]
+}
+
+// Dashboard sink rendering a live top-like view of the samples in the terminal
+type Dashboard struct {
+	config   Config
+	out      io.Writer
+	in       *os.File
+	state    *terminal.State
+	counters sinkCounters
+
+	lock      sync.Mutex
+	latest    NodeStats // Latest value of every metric, collectors may have different intervals
+	updated   time.Time
+	history   map[string][]float64
+	sortBy    ProcessSort
+	reverse   bool
+	closeOnce sync.Once
+	quit      chan struct{}
+	quitOnce  sync.Once
+}
+
+// NewDashboard Create a dashboard writing to out. When in is a terminal it is switched to raw mode to read the key presses
+func NewDashboard(config Config, out io.Writer, in *os.File) *Dashboard {
+	dashboard := Dashboard{
+		config:  config,
+		out:     out,
+		history: make(map[string][]float64),
+		quit:    make(chan struct{}),
+	}
+	dashboard.counters.stats.Name = dashboard.Name()
+
+	if in != nil && terminal.IsTerminal(int(in.Fd())) {
+		state, err := terminal.MakeRaw(int(in.Fd()))
+		if err == nil {
+			dashboard.in = in
+			dashboard.state = state
+			go dashboard.readKeys()
+		}
+	}
+	fmt.Fprint(out, ansiAltScreen+ansiHideCursor)
+	return &dashboard
+}
+
+// NewTerminalDashboard Create a dashboard on the standard output, reading the keys from the standard input
+func NewTerminalDashboard(config Config) *Dashboard {
+	return NewDashboard(config, colorable.NewColorableStdout(), os.Stdin)
+}
+
+// Name name of the sink
+func (dashboard *Dashboard) Name() string {
+	return "tui"
+}
+
+// Write record the sample and redraw the dashboard
+func (dashboard *Dashboard) Write(now time.Time, stats NodeStats) error {
+	dashboard.lock.Lock()
+	mergeStats(&dashboard.latest, &stats)
+	dashboard.updated = now
+	dashboard.recordHistory(stats)
+	dashboard.lock.Unlock()
+
+	dashboard.redraw()
+	dashboard.counters.update(func(stats *SinkStats) {
+		stats.Queued++
+		stats.Sent++
+		stats.LastSuccess = time.Now()
+	})
+	return nil
+}
+
+// Stats delivery counters of the sink
+func (dashboard *Dashboard) Stats() SinkStats {
+	return dashboard.counters.get()
+}
+
+// Close restore the terminal
+func (dashboard *Dashboard) Close() {
+	dashboard.closeOnce.Do(func() {
+		fmt.Fprint(dashboard.out, ansiShowCursor+ansiMainScreen)
+		if dashboard.state != nil {
+			terminal.Restore(int(dashboard.in.Fd()), dashboard.state)
+		}
+	})
+}
+
+// Done closed when the user quit the dashboard
+func (dashboard *Dashboard) Done() <-chan struct{} {
+	return dashboard.quit
+}
+
+// HandleKey change the sort of the process table(c, m, g, p, n), reverse it(r) or quit(q, Ctrl+C)
+func (dashboard *Dashboard) HandleKey(key byte) {
+	dashboard.lock.Lock()
+	switch key {
+	case 'c':
+		dashboard.sortBy = SortByCPU
+	case 'm':
+		dashboard.sortBy = SortByMemory
+	case 'g':
+		dashboard.sortBy = SortByGPUMemory
+	case 'p':
+		dashboard.sortBy = SortByPID
+	case 'n':
+		dashboard.sortBy = SortByName
+	case 'r':
+		dashboard.reverse = !dashboard.reverse
+	case 'q', 3:
+		dashboard.lock.Unlock()
+		dashboard.Close()
+		dashboard.quitOnce.Do(func() {
+			close(dashboard.quit)
+		})
+		return
+	}
+	dashboard.lock.Unlock()
+	dashboard.redraw()
+}
+
+func (dashboard *Dashboard) readKeys() {
+	buffer := make([]byte, 16)
+	for {
+		n, err := dashboard.in.Read(buffer)
+		if err != nil {
+			return
+		}
+		for _, key := range buffer[:n] {
+			dashboard.HandleKey(key)
+		}
+	}
+}
+
+func (dashboard *Dashboard) size() (int, int) {
+	if file, ok := dashboard.out.(*os.File); ok && terminal.IsTerminal(int(file.Fd())) {
+		if width, height, err := terminal.GetSize(int(file.Fd())); err == nil {
+			return width, height
+		}
+	}
+	if dashboard.in != nil {
+		if width, height, err := terminal.GetSize(int(dashboard.in.Fd())); err == nil {
+			return width, height
+		}
+	}
+	return defaultDashboardWidth, defaultDashboardHeight
+}
+
+func (dashboard *Dashboard) redraw() {
+	width, height := dashboard.size()
+	lines := dashboard.Render(width, height)
+	// Raw mode disables the translation of \n to \r\n
+	fmt.Fprint(dashboard.out, ansiHome+strings.Join(lines, ansiClearLine+"\r\n")+ansiClearLine+ansiClearScreen)
+}
+
+func (dashboard *Dashboard) recordHistory(stats NodeStats) {
+	if len(stats.CPUPercents) > 0 {
+		dashboard.push("cpu", avg(stats.CPUPercents))
+	}
+	if stats.Memory != nil {
+		dashboard.push("memory", stats.Memory.UsedPercent)
+	}
+	if stats.DiskIO != nil {
+		dashboard.push("diskRead", float64(stats.DiskIO.ReadBps))
+		dashboard.push("diskWrite", float64(stats.DiskIO.WriteBps))
+	}
+	if stats.NetIO != nil {
+		dashboard.push("netRead", float64(stats.NetIO.ReadBps))
+		dashboard.push("netWrite", float64(stats.NetIO.WriteBps))
+	}
+	for _, usage := range stats.Gpus {
+		dashboard.push(fmt.Sprintf("gpu%d", usage.Index), usage.GPU)
+	}
+}
+
+func (dashboard *Dashboard) push(key string, value float64) {
+	values := append(dashboard.history[key], value)
+	if len(values) > sparklineLength {
+		values = values[len(values)-sparklineLength:]
+	}
+	dashboard.history[key] = values
+}
+
+// Render lines of the dashboard for a terminal of the given size
+func (dashboard *Dashboard) Render(width int, height int) []string {
+	dashboard.lock.Lock()
+	defer dashboard.lock.Unlock()
+	stats := dashboard.latest
+
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	title := func(name string) {
+		add("%s%s%s", ansiBold+ansiCyan, name, ansiReset)
+	}
+	barWidth := (width - 40) / 2
+	if barWidth < 10 {
+		barWidth = 10
+	}
+
+	updated := "waiting for the first sample"
+	if !dashboard.updated.IsZero() {
+		updated = dashboard.updated.Format("15:04:05")
+	}
+	add("%sbatch-insights %s%s  %s/%s  %s", ansiBold, Version, ansiReset, dashboard.config.PoolID, dashboard.config.NodeID, updated)
+	add("Sort: %s%s  [c]pu [m]emory [g]pu memory [p]id [n]ame [r]everse [q]uit", dashboard.sortBy, map[bool]string{true: " (reversed)", false: ""}[dashboard.reverse])
+
+	if len(stats.CPUPercents) > 0 {
+		add("")
+		title(fmt.Sprintf("CPU %5.1f%% %s", avg(stats.CPUPercents), sparkline(dashboard.history["cpu"], 100)))
+		columns := width / (barWidth + 18)
+		if columns < 1 {
+			columns = 1
+		}
+		var row []string
+		for cpuN, percent := range stats.CPUPercents {
+			row = append(row, fmt.Sprintf("%4d %s %5.1f%%", cpuN, bar(percent, barWidth), percent))
+			if len(row) == columns || cpuN == len(stats.CPUPercents)-1 {
+				add("%s", strings.Join(row, "  "))
+				row = nil
+			}
+		}
+	}
+
+	if stats.Memory != nil {
+		add("")
+		title("Memory")
+		add(" Mem %s %s/%s %s", bar(stats.Memory.UsedPercent, barWidth), humanize.Bytes(stats.Memory.Used), humanize.Bytes(stats.Memory.Total), sparkline(dashboard.history["memory"], 100))
+		if stats.Swap != nil && stats.Swap.Total > 0 {
+			add(" Swp %s %s/%s", bar(stats.Swap.UsedPercent, barWidth), humanize.Bytes(stats.Swap.Used), humanize.Bytes(stats.Swap.Total))
+		}
+	}
+
+	if stats.DiskIO != nil || stats.NetIO != nil {
+		add("")
+		title("IO")
+		if stats.DiskIO != nil {
+			add(" Disk  R: %10s/s %s  W: %10s/s %s", humanize.Bytes(stats.DiskIO.ReadBps), sparkline(dashboard.history["diskRead"], 0), humanize.Bytes(stats.DiskIO.WriteBps), sparkline(dashboard.history["diskWrite"], 0))
+		}
+		if stats.NetIO != nil {
+			add(" Net   R: %10s/s %s  S: %10s/s %s", humanize.Bytes(stats.NetIO.ReadBps), sparkline(dashboard.history["netRead"], 0), humanize.Bytes(stats.NetIO.WriteBps), sparkline(dashboard.history["netWrite"], 0))
+		}
+	}
+
+	if len(stats.DiskUsage) > 0 {
+		add("")
+		title("Disks")
+		for _, usage := range stats.DiskUsage {
+			add(" %-20s %s %s/%s", usage.Path, bar(usage.UsedPercent, barWidth), humanize.Bytes(usage.Used), humanize.Bytes(usage.Total))
+		}
+	}
+
+	if len(stats.Gpus) > 0 {
+		add("")
+		title("GPUs")
+		for _, usage := range stats.Gpus {
+			details := ""
+			if usage.Temperature != nil {
+				details += fmt.Sprintf(" %.0f°C", *usage.Temperature)
+			}
+			if usage.PowerDraw != nil {
+				details += fmt.Sprintf(" %.0fW", *usage.PowerDraw)
+			}
+			add(" %d %-16.16s %s %5.1f%% mem %s %s/%s%s %s", usage.Index, usage.Name, bar(usage.GPU, barWidth/2), usage.GPU, bar(usage.Memory, barWidth/2),
+				humanize.Bytes(usage.MemoryUsed), humanize.Bytes(usage.MemoryTotal), details, sparkline(dashboard.history[fmt.Sprintf("gpu%d", usage.Index)], 100))
+		}
+	}
+
+	if len(stats.Processes) > 0 {
+		add("")
+		title("Processes")
+		add("%s %8s %-24s %7s %10s %10s %6s%s", ansiBold, "PID", "NAME", "CPU%", "MEM", "GPU MEM", "GPU%", ansiReset)
+		for _, process := range dashboard.sortedProcesses(stats.Processes) {
+			add(" %8d %-24.24s %7.1f %10s %10s %6.1f", process.pid, process.name, process.cpu, humanize.Bytes(process.memory), humanize.Bytes(process.gpuMemory), process.gpuUsage)
+		}
+	}
+
+	var failing []string
+	for _, status := range stats.Collectors {
+		if status.Failed() {
+			failing = append(failing, status.String())
+		}
+	}
+	if len(failing) > 0 {
+		add("")
+		add("%sCollectors: %s%s", ansiRed, strings.Join(failing, ", "), ansiReset)
+	}
+
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	for i := range lines {
+		lines[i] = truncate(lines[i], width)
+	}
+	return lines
+}
+
+func (dashboard *Dashboard) sortedProcesses(processes []*ProcessPerfInfo) []*ProcessPerfInfo {
+	sorted := make([]*ProcessPerfInfo, len(processes))
+	copy(sorted, processes)
+	less := map[ProcessSort]func(a, b *ProcessPerfInfo) bool{
+		// Biggest first for the usages
+		SortByCPU:       func(a, b *ProcessPerfInfo) bool { return a.cpu > b.cpu },
+		SortByMemory:    func(a, b *ProcessPerfInfo) bool { return a.memory > b.memory },
+		SortByGPUMemory: func(a, b *ProcessPerfInfo) bool { return a.gpuMemory > b.gpuMemory },
+		SortByPID:       func(a, b *ProcessPerfInfo) bool { return a.pid < b.pid },
+		SortByName:      func(a, b *ProcessPerfInfo) bool { return strings.ToLower(a.name) < strings.ToLower(b.name) },
+	}[dashboard.sortBy]
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if dashboard.reverse {
+			return less(sorted[j], sorted[i])
+		}
+		return less(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+// bar percent bar colored by level. e.g. [|||||     ]
+func bar(percent float64, width int) string {
+	filled := int(percent / 100 * float64(width))
+	if filled > width {
+		filled = width
+	}
+	if filled < 0 {
+		filled = 0
+	}
+	color := ansiGreen
+	if percent >= 90 {
+		color = ansiRed
+	} else if percent >= 70 {
+		color = ansiYellow
+	}
+	return "[" + color + strings.Repeat("|", filled) + ansiReset + strings.Repeat(" ", width-filled) + "]"
+}
+
+// sparkline plot the values. The scale goes up to max, or to the biggest value when max is 0
+func sparkline(values []float64, max float64) string {
+	if max == 0 {
+		for _, value := range values {
+			if value > max {
+				max = value
+			}
+		}
+	}
+	var result []rune
+	for _, value := range values {
+		index := 0
+		if max > 0 {
+			index = int(value / max * float64(len(sparkBlocks)-1))
+		}
+		if index >= len(sparkBlocks) {
+			index = len(sparkBlocks) - 1
+		}
+		if index < 0 {
+			index = 0
+		}
+		result = append(result, sparkBlocks[index])
+	}
+	return string(result)
+}
+
+// truncate cut a line to the given visible width. ANSI escape sequences don't take any space
+func truncate(line string, width int) string {
+	visible := 0
+	escape := false
+	for i, char := range line {
+		switch {
+		case char == '\x1b':
+			escape = true
+		case escape:
+			if char == 'm' {
+				escape = false
+			}
+		default:
+			if visible == width {
+				return line[:i] + ansiReset
+			}
+			visible++
+		}
+	}
+	return line
+}
@@ -0,0 +1,66 @@
+package batchinsights_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/Azure/batch-insights/pkg/utils"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/stretchr/testify/assert"
+)
+
+var ansiPattern = regexp.MustCompile("\x1b\\[[0-9;?]*[a-zA-Z]")
+
+func TestDashboard(t *testing.T) {
+	var out bytes.Buffer
+	dashboard := batchinsights.NewDashboard(batchinsights.Config{PoolID: "pool-1", NodeID: "node-1"}, &out, nil)
+	defer dashboard.Close()
+
+	dashboard.Write(time.Now(), batchinsights.NodeStats{
+		CPUPercents: []float64{10, 95},
+		Memory:      &mem.VirtualMemoryStat{Used: 4000000000, Total: 8000000000, UsedPercent: 50},
+	})
+	// Collectors with a longer interval are missing from the next samples, the last values are kept
+	dashboard.Write(time.Now(), batchinsights.NodeStats{
+		CPUPercents: []float64{20, 80},
+		NetIO:       &utils.IOStats{ReadBps: 1000, WriteBps: 2000},
+		Collectors:  []batchinsights.CollectorStatus{{Name: "gpu", TimedOut: true}},
+	})
+
+	lines := dashboard.Render(80, 40)
+	screen := strings.Join(lines, "\n")
+	assert.Contains(t, lines[0], "pool-1/node-1")
+	assert.Contains(t, screen, "CPU  50.0%")
+	assert.Contains(t, screen, " 80.0%")
+	assert.Contains(t, screen, "4.0 GB/8.0 GB")
+	assert.Contains(t, screen, "R:     1.0 kB/s")
+	assert.Contains(t, screen, "gpu: timed out")
+
+	dashboard.HandleKey('n')
+	dashboard.HandleKey('r')
+	assert.Contains(t, dashboard.Render(80, 40)[1], "Sort: name (reversed)")
+
+	// The terminal is too small
+	lines = dashboard.Render(20, 3)
+	assert.Equal(t, 3, len(lines))
+	for _, line := range lines {
+		assert.True(t, utf8.RuneCountInString(ansiPattern.ReplaceAllString(line, "")) <= 20, line)
+	}
+	assert.True(t, out.Len() > 0)
+	assert.Equal(t, uint64(2), dashboard.Stats().Sent)
+
+	// The main loop returns and closes the sinks
+	select {
+	case <-dashboard.Done():
+		t.Fatal("Quit before q was pressed")
+	default:
+	}
+	dashboard.HandleKey('q')
+	_, open := <-dashboard.Done()
+	assert.False(t, open)
+}
@@ -1,29 +1,36 @@
 package disk
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"os"
 	"runtime"
+	"strings"
 
 	psutils_disk "github.com/shirou/gopsutil/disk"
 )
 
 var IS_PLATFORM_WINDOWS = runtime.GOOS == "windows"
 
-func GetDiskUsage() []*psutils_disk.UsageStat {
+// GetDiskUsage usage of the watched disks. The error is set if a disk couldn't be read, the other disks are still returned
+func GetDiskUsage(ctx context.Context) ([]*psutils_disk.UsageStat, error) {
 	var disks = getDiskToWatch()
 	var stats []*psutils_disk.UsageStat
+	var errs []string
 
 	for _, diskPath := range disks {
-		usage, err := psutils_disk.Usage(diskPath)
+		usage, err := psutils_disk.UsageWithContext(ctx, diskPath)
 		if err == nil {
 			stats = append(stats, usage)
 		} else {
-			fmt.Println(err)
+			errs = append(errs, err.Error())
 		}
 	}
 
-	return stats
+	if len(errs) > 0 {
+		return stats, errors.New(strings.Join(errs, ", "))
+	}
+	return stats, nil
 }
 
 func getDiskToWatch() []string {
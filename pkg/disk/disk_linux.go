@@ -3,6 +3,7 @@
 package disk
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/Azure/batch-insights/pkg/utils"
@@ -11,12 +12,11 @@ import (
 
 var diskIO = utils.IOAggregator{}
 
-func DiskIO() *utils.IOStats {
-	var counters, err = psutils_disk.IOCounters()
+func DiskIO(ctx context.Context) (*utils.IOStats, error) {
+	var counters, err = psutils_disk.IOCountersWithContext(ctx)
 
 	if err != nil {
-		fmt.Println("Error while retrieving Disk IO", err)
-		return nil
+		return nil, fmt.Errorf("Error while retrieving Disk IO: %v", err)
 	}
 	var readBytes uint64 = 0
 	var writeBytes uint64 = 0
@@ -26,5 +26,5 @@ func DiskIO() *utils.IOStats {
 		writeBytes += v.WriteBytes
 	}
 	var stats = diskIO.UpdateAggregates(readBytes, writeBytes)
-	return &stats
+	return &stats, nil
 }
@@ -24,18 +24,17 @@ type win32_PerfRawData_PerfDisk_PhysicalDisk struct {
 
 var diskIO = utils.IOAggregator{}
 
-func DiskIO() *utils.IOStats {
-	return DiskIOWithContext(context.Background())
+func DiskIO(ctx context.Context) (*utils.IOStats, error) {
+	return DiskIOWithContext(ctx)
 }
 
-func DiskIOWithContext(ctx context.Context, names ...string) *utils.IOStats {
+func DiskIOWithContext(ctx context.Context, names ...string) (*utils.IOStats, error) {
 	var ret []win32_PerfRawData_PerfDisk_PhysicalDisk
 
 	q := wmi.CreateQuery(&ret, "WHERE NOT Name LIKE '%_Total'")
 	err := wmi.QueryWithContext(ctx, q, &ret)
 	if err != nil {
-		fmt.Println("Error while retrieving DISK IO", err)
-		return nil
+		return nil, fmt.Errorf("Error while retrieving DISK IO: %v", err)
 	}
 
 	var readBytes uint64 = 0
@@ -46,5 +45,5 @@ func DiskIOWithContext(ctx context.Context, names ...string) *utils.IOStats {
 	}
 	stats := diskIO.UpdateAggregates(readBytes, writeBytes)
 
-	return &stats
+	return &stats, nil
 }
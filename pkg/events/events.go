@@ -0,0 +1,286 @@
+package events
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/memory"
+	"github.com/Azure/batch-insights/pkg/utils"
+)
+
+// DefaultKernelLog device exposing the kernel log records
+const DefaultKernelLog = "/dev/kmsg"
+
+// Type kind of event detected by the watcher
+type Type string
+
+const (
+	// OOMKill a process was killed by the OOM killer
+	OOMKill Type = "OOM kill"
+	// MemoryCorrectedError the memory controller corrected an error(EDAC)
+	MemoryCorrectedError Type = "Memory corrected error"
+	// MemoryUncorrectedError the memory controller detected an error it couldn't correct(EDAC)
+	MemoryUncorrectedError Type = "Memory uncorrected error"
+	// MachineCheck the CPU reported a hardware error(MCE)
+	MachineCheck Type = "Machine check"
+	// DiskIOError a block device failed an I/O request
+	DiskIOError Type = "Disk I/O error"
+	// GPUXid the Nvidia driver reported an Xid error
+	GPUXid Type = "GPU Xid"
+)
+
+var (
+	oomVictimPattern    = regexp.MustCompile(`Killed process (\d+) \(([^)]*)\)`)
+	oomRSSPattern       = regexp.MustCompile(`anon-rss:(\d+)kB`)
+	oomCgroupPattern    = regexp.MustCompile(`oom-kill:.*task_memcg=([^,]+)`)
+	diskErrorPattern    = regexp.MustCompile(`I/O error, dev ([^,\s]+),? sector (\d+)`)
+	xidPattern          = regexp.MustCompile(`NVRM: Xid \(PCI:([^)]+)\): (\d+),\s*(.*)`)
+	machineCheckPattern = regexp.MustCompile(`mce: \[Hardware Error\]: (.*)`)
+)
+
+// Event discrete event detected on the node
+type Event struct {
+	Time       time.Time
+	Type       Type
+	Message    string
+	Count      uint64 // Number of occurrences when the event is detected from a counter
+	Properties map[string]string
+}
+
+// Watcher watcher detecting OOM kills and hardware errors from procfs, sysfs and the kernel log
+type Watcher struct {
+	procRoot   string
+	sysRoot    string
+	out        io.Writer // Diagnostics
+	kernelLog  *kernelLog
+	bootTime   time.Time
+	counters   map[string]uint64
+	lastCgroup string // Memory cgroup of the last OOM kill, logged before the victim
+}
+
+// NewWatcher Create a new event watcher. The kernel log is optional as it requires privileges to be read.
+// Diagnostics are printed to out
+func NewWatcher(procRoot string, sysRoot string, kernelLogPath string, out io.Writer) *Watcher {
+	watcher := Watcher{
+		procRoot: procRoot,
+		sysRoot:  sysRoot,
+		out:      out,
+		bootTime: readBootTime(procRoot),
+		counters: make(map[string]uint64),
+	}
+
+	log, err := openKernelLog(kernelLogPath)
+	if err != nil {
+		fmt.Fprintln(out, "Kernel log is not readable, OOM kills will be reported without the process details", err)
+	} else {
+		watcher.kernelLog = log
+	}
+	return &watcher
+}
+
+// Poll Get the events which happened since the last poll
+func (watcher *Watcher) Poll() []Event {
+	now := time.Now()
+	var events []Event
+	var victims uint64
+
+	if watcher.kernelLog != nil {
+		records, err := watcher.kernelLog.readRecords()
+		if err != nil {
+			fmt.Fprintln(watcher.out, "Error while reading the kernel log", err)
+		}
+		for _, record := range records {
+			if event, ok := watcher.parseRecord(record, now); ok {
+				if event.Type == OOMKill {
+					victims++
+				}
+				events = append(events, event)
+			}
+		}
+	}
+
+	// Report the kills missing from the kernel log without details
+	if kills := watcher.oomKills(); kills > victims {
+		events = append(events, Event{
+			Time:    now,
+			Type:    OOMKill,
+			Message: fmt.Sprintf("%d process(es) killed by the OOM killer", kills-victims),
+			Count:   kills - victims,
+		})
+	}
+
+	events = append(events, watcher.edacErrors(now)...)
+	if watcher.kernelLog == nil {
+		events = append(events, watcher.diskErrors(now)...)
+	}
+	return events
+}
+
+// Close release the kernel log
+func (watcher *Watcher) Close() {
+	if watcher.kernelLog != nil {
+		watcher.kernelLog.close()
+	}
+}
+
+// parseRecord parse a kernel log record. e.g. "3,1234,5678901,-;Out of memory: Killed process 4321 (python)..."
+func (watcher *Watcher) parseRecord(record string, now time.Time) (Event, bool) {
+	// Continuation lines hold the record dictionary
+	if record == "" || strings.HasPrefix(record, " ") {
+		return Event{}, false
+	}
+
+	timestamp := now
+	message := record
+	if parts := strings.SplitN(record, ";", 2); len(parts) == 2 {
+		message = parts[1]
+		fields := strings.Split(parts[0], ",")
+		if len(fields) >= 3 && !watcher.bootTime.IsZero() {
+			if usec, err := strconv.ParseUint(fields[2], 10, 64); err == nil {
+				timestamp = watcher.bootTime.Add(time.Duration(usec) * time.Microsecond)
+			}
+		}
+	}
+	return watcher.ParseMessage(message, timestamp)
+}
+
+// ParseMessage detect an event in a kernel log message
+func (watcher *Watcher) ParseMessage(message string, timestamp time.Time) (Event, bool) {
+	if match := oomCgroupPattern.FindStringSubmatch(message); match != nil {
+		watcher.lastCgroup = match[1]
+		return Event{}, false
+	}
+	if match := oomVictimPattern.FindStringSubmatch(message); match != nil {
+		properties := map[string]string{"PID": match[1], "Process Name": match[2]}
+		if rss := oomRSSPattern.FindStringSubmatch(message); rss != nil {
+			properties["Anon RSS (kB)"] = rss[1]
+		}
+		if watcher.lastCgroup != "" {
+			properties["Cgroup"] = watcher.lastCgroup
+			watcher.lastCgroup = ""
+		}
+		return Event{Time: timestamp, Type: OOMKill, Message: message, Count: 1, Properties: properties}, true
+	}
+	if match := diskErrorPattern.FindStringSubmatch(message); match != nil {
+		properties := map[string]string{"Device": match[1], "Sector": match[2]}
+		return Event{Time: timestamp, Type: DiskIOError, Message: message, Count: 1, Properties: properties}, true
+	}
+	if match := xidPattern.FindStringSubmatch(message); match != nil {
+		properties := map[string]string{"PCI bus ID": match[1], "Xid": match[2]}
+		return Event{Time: timestamp, Type: GPUXid, Message: message, Count: 1, Properties: properties}, true
+	}
+	if match := machineCheckPattern.FindStringSubmatch(message); match != nil {
+		return Event{Time: timestamp, Type: MachineCheck, Message: match[1], Count: 1}, true
+	}
+	return Event{}, false
+}
+
+// oomKills number of OOM kills since the last poll from the vmstat counter(kernel 4.13+)
+func (watcher *Watcher) oomKills() uint64 {
+	file, err := os.Open(filepath.Join(watcher.procRoot, "vmstat"))
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	counters, err := memory.ParseVMStat(file)
+	if err != nil {
+		return 0
+	}
+	value, ok := counters["oom_kill"]
+	if !ok {
+		return 0
+	}
+	return watcher.delta("oom_kill", value)
+}
+
+// edacErrors memory errors counted by the EDAC memory controllers since the last poll
+func (watcher *Watcher) edacErrors(now time.Time) []Event {
+	controllers, _ := filepath.Glob(filepath.Join(watcher.sysRoot, "devices", "system", "edac", "mc", "mc*"))
+
+	var events []Event
+	for _, controller := range controllers {
+		name := filepath.Base(controller)
+		counts := []struct {
+			file      string
+			eventType Type
+		}{
+			{"ce_count", MemoryCorrectedError},
+			{"ue_count", MemoryUncorrectedError},
+		}
+		for _, count := range counts {
+			value, err := utils.ReadUint(filepath.Join(controller, count.file))
+			if err != nil {
+				continue
+			}
+			if delta := watcher.delta(name+"/"+count.file, value); delta > 0 {
+				events = append(events, Event{
+					Time:       now,
+					Type:       count.eventType,
+					Message:    fmt.Sprintf("%d %s(s) on memory controller %s", delta, strings.ToLower(string(count.eventType)), name),
+					Count:      delta,
+					Properties: map[string]string{"Memory controller": name},
+				})
+			}
+		}
+	}
+	return events
+}
+
+// diskErrors I/O errors counted by the SCSI devices since the last poll. Only used when the kernel log isn't readable
+func (watcher *Watcher) diskErrors(now time.Time) []Event {
+	counters, _ := filepath.Glob(filepath.Join(watcher.sysRoot, "block", "*", "device", "ioerr_cnt"))
+
+	var events []Event
+	for _, counter := range counters {
+		device := filepath.Base(filepath.Dir(filepath.Dir(counter)))
+		value, err := utils.ReadUintBase(counter, 0) // ioerr_cnt uses the 0x prefix
+		if err != nil {
+			continue
+		}
+		if delta := watcher.delta(device+"/ioerr_cnt", value); delta > 0 {
+			events = append(events, Event{
+				Time:       now,
+				Type:       DiskIOError,
+				Message:    fmt.Sprintf("%d I/O error(s) on %s", delta, device),
+				Count:      delta,
+				Properties: map[string]string{"Device": device},
+			})
+		}
+	}
+	return events
+}
+
+// delta increase of a counter since the last poll. The first value is only used as a baseline
+func (watcher *Watcher) delta(key string, value uint64) uint64 {
+	last, ok := watcher.counters[key]
+	watcher.counters[key] = value
+	if !ok || value < last {
+		return 0
+	}
+	return value - last
+}
+
+// readBootTime compute the boot time from the uptime to convert the kernel log timestamps
+func readBootTime(procRoot string) time.Time {
+	content, err := ioutil.ReadFile(filepath.Join(procRoot, "uptime"))
+	if err != nil {
+		return time.Time{}
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return time.Time{}
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Now().Add(-time.Duration(uptime * float64(time.Second)))
+}
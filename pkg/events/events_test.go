@@ -0,0 +1,80 @@
+package events_test
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/events"
+	"github.com/Azure/batch-insights/pkg/testfixture"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFixture procfs and sysfs without any event
+func newFixture(t *testing.T) *testfixture.Fixture {
+	f := testfixture.New(t)
+	f.Write("proc/uptime", "86500.00 172000.00\n")
+	f.Write("proc/vmstat", "pgfault 1000\noom_kill 2\n")
+	f.Write("sys/devices/system/edac/mc/mc0/ce_count", "5\n")
+	f.Write("sys/devices/system/edac/mc/mc0/ue_count", "0\n")
+	f.Write("sys/block/sda/device/ioerr_cnt", "0x1\n")
+	f.Write("kmsg", "")
+	return f
+}
+
+func TestKernelLogEvents(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	f.Write("kmsg", "6,1,1000,-;Linux version 4.15.0\n")
+
+	watcher := events.NewWatcher(f.Path("proc"), f.Path("sys"), f.Path("kmsg"), ioutil.Discard)
+	defer watcher.Close()
+	assert.Equal(t, 0, len(watcher.Poll()))
+
+	records, err := ioutil.ReadFile("testdata/kmsg")
+	assert.Nil(t, err)
+	f.Append("kmsg", string(records))
+	f.Write("proc/vmstat", "pgfault 1000\noom_kill 3\n")
+	detected := watcher.Poll()
+
+	assert.Equal(t, 4, len(detected))
+	oom := detected[0]
+	assert.Equal(t, events.OOMKill, oom.Type)
+	assert.Equal(t, "4321", oom.Properties["PID"])
+	assert.Equal(t, "python", oom.Properties["Process Name"])
+	assert.Equal(t, "4194304", oom.Properties["Anon RSS (kB)"])
+	assert.Equal(t, "/docker/3f2a9c1e8b7d", oom.Properties["Cgroup"])
+	// Logged 100s before the uptime was read
+	assert.WithinDuration(t, time.Now().Add(-100*time.Second), oom.Time, 5*time.Second)
+
+	assert.Equal(t, events.DiskIOError, detected[1].Type)
+	assert.Equal(t, "sdc", detected[1].Properties["Device"])
+	assert.Equal(t, events.GPUXid, detected[2].Type)
+	assert.Equal(t, "79", detected[2].Properties["Xid"])
+	assert.Equal(t, "0001:00:00", detected[2].Properties["PCI bus ID"])
+	assert.Equal(t, events.MachineCheck, detected[3].Type)
+}
+
+func TestCounterEvents(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+
+	// No kernel log, the OOM kills and disk errors are detected from the counters
+	watcher := events.NewWatcher(f.Path("proc"), f.Path("sys"), f.Path("missing"), ioutil.Discard)
+	assert.Equal(t, 0, len(watcher.Poll()))
+
+	f.Write("proc/vmstat", "pgfault 1000\noom_kill 4\n")
+	f.Write("sys/devices/system/edac/mc/mc0/ce_count", "8\n")
+	f.Write("sys/block/sda/device/ioerr_cnt", "0x3\n")
+	detected := watcher.Poll()
+
+	assert.Equal(t, 3, len(detected))
+	assert.Equal(t, events.OOMKill, detected[0].Type)
+	assert.Equal(t, uint64(2), detected[0].Count)
+	assert.Equal(t, events.MemoryCorrectedError, detected[1].Type)
+	assert.Equal(t, uint64(3), detected[1].Count)
+	assert.Equal(t, "mc0", detected[1].Properties["Memory controller"])
+	assert.Equal(t, events.DiskIOError, detected[2].Type)
+	assert.Equal(t, uint64(2), detected[2].Count)
+	assert.Equal(t, "sda", detected[2].Properties["Device"])
+}
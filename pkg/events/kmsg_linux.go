@@ -0,0 +1,59 @@
+// +build linux
+
+package events
+
+import (
+	"io"
+	"strings"
+	"syscall"
+)
+
+// kernelLog non blocking reader of /dev/kmsg.
+// The raw file descriptor is used as the Go poller would block the reads of a character device
+type kernelLog struct {
+	fd      int
+	pending string
+}
+
+func openKernelLog(path string) (*kernelLog, error) {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	// Only report the records logged from now on
+	if _, err := syscall.Seek(fd, 0, io.SeekEnd); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &kernelLog{fd: fd}, nil
+}
+
+// readRecords read the records available without blocking. /dev/kmsg returns one record per read
+func (log *kernelLog) readRecords() ([]string, error) {
+	var records []string
+	buffer := make([]byte, 8192)
+	for {
+		n, err := syscall.Read(log.fd, buffer)
+		switch {
+		case err == syscall.EAGAIN:
+			return records, nil
+		case err == syscall.EPIPE || err == syscall.EINTR:
+			// The oldest records were overwritten before being read
+			continue
+		case err != nil:
+			return records, err
+		case n == 0:
+			return records, nil
+		}
+
+		// Regular files(e.g. tests) return several records per read
+		content := log.pending + string(buffer[:n])
+		lines := strings.Split(content, "\n")
+		log.pending = lines[len(lines)-1]
+		records = append(records, lines[:len(lines)-1]...)
+	}
+}
+
+func (log *kernelLog) close() {
+	syscall.Close(log.fd)
+}
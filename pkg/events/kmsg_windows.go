@@ -0,0 +1,21 @@
+// +build windows
+
+package events
+
+import (
+	"errors"
+)
+
+type kernelLog struct {
+}
+
+func openKernelLog(path string) (*kernelLog, error) {
+	return nil, errors.New("The kernel log is only available on linux")
+}
+
+func (log *kernelLog) readRecords() ([]string, error) {
+	return nil, nil
+}
+
+func (log *kernelLog) close() {
+}
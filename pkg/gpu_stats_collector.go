@@ -1,91 +1,564 @@
 package batchinsights
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/Azure/batch-insights/amdgpu"
 	"github.com/Azure/batch-insights/nvml"
+	"github.com/shirou/gopsutil/process"
 )
 
+// DefaultGPUReinitBackoff time to wait before trying to reinitialize NVML after losing a GPU
+const DefaultGPUReinitBackoff = time.Duration(30) * time.Second
+
+// MaxGPUReinitBackoff maximum time between two NVML reinitialization attempts
+const MaxGPUReinitBackoff = time.Duration(10) * time.Minute
+
 // GPUStatsCollector collector that retrieve gpu usage from nvml
 type GPUStatsCollector struct {
 	nvml        nvml.NvmlClient
+	out         io.Writer // Diagnostics
 	deviceCount uint
+	identities  map[string]GPUIdentity // By UUID, the name and PCI bus ID never change
+	health      []GPUHealth            // In the order the GPUs were first seen. GPUs which vanished stay lost until they come back
+
+	lastProcessSampling map[string]time.Time // By UUID
+
+	// NVML is shutdown and reinitialized with an exponential backoff when a GPU is lost
+	// NVML is also reinitialized with the backoff, without interrupting the other GPUs, while a GPU has vanished
+	initialized bool
+	nextInit    time.Time
+	backoff     time.Duration
+	vanished    int
+
+	MinReinitBackoff time.Duration
+	MaxReinitBackoff time.Duration
 }
 
-// GPUUsage contains gpu stats
+// GPUState health of a GPU as seen by NVML
+type GPUState int
+
+const (
+	// GPUHealthy all the queries succeeded
+	GPUHealthy GPUState = 0
+	// GPUError the device queries failed during the last sample
+	GPUError GPUState = 1
+	// GPULost the device fell off the bus or NVML is unavailable
+	GPULost GPUState = 2
+)
+
+func (state GPUState) String() string {
+	switch state {
+	case GPUHealthy:
+		return "healthy"
+	case GPUError:
+		return "error"
+	case GPULost:
+		return "lost"
+	}
+	return "unknown"
+}
+
+// GPUHealth health of a GPU during the last sample
+type GPUHealth struct {
+	GPUIdentity
+	Index     uint
+	State     GPUState
+	Errors    uint   // Number of failed queries during the last sample
+	LastError string // Last error returned by NVML for this device
+}
+
+// GPUIdentity identify a GPU across reboots and VM sizes. Device indices are not stable
+type GPUIdentity struct {
+	UUID     string
+	Name     string
+	PCIBusID string
+}
+
+// GPUUsage contains gpu stats. Optional values are nil when not supported by the device
 type GPUUsage struct {
-	GPU    float64
-	Memory float64
+	GPUIdentity
+	Index           uint // NVML device index
+	GPU             float64
+	Memory          float64
+	MemoryTotal     uint64   // Bytes
+	MemoryUsed      uint64   // Bytes
+	Temperature     *float64 // Degrees C
+	PowerDraw       *float64 // Watts
+	PowerLimit      *float64 // Watts
+	SMClock         *float64 // MHz
+	MemoryClock     *float64 // MHz
+	ThrottleReasons *nvml.ThrottleReasons
+	ECCErrors       *nvml.ECCErrors
+	PCIeTXBps       *float64
+	PCIeRXBps       *float64
+	Processes       []GPUProcessUsage
 }
 
-// NewGPUStatsCollector Create a new instance of the GPU stats collector
-func NewGPUStatsCollector() GPUStatsCollector {
-	nvmlClient, err := nvml.New()
+// GPUProcessUsage usage of a GPU by a single process
+type GPUProcessUsage struct {
+	PID               uint32
+	Name              string
+	Watched           bool     // Process is part of the watched processes
+	MemoryUsed        uint64   // Bytes
+	SMUtilization     *float64 // Percent, nil when not supported
+	MemoryUtilization *float64 // Percent, nil when not supported
+}
 
-	if err != nil {
-		fmt.Println("No GPU detected. Nvidia driver might be missing")
-	} else {
-		err = nvmlClient.Init()
+// NvmlClientFactory create the NVML client used to query the GPUs
+type NvmlClientFactory func() (nvml.NvmlClient, error)
 
-		if err != nil {
-			fmt.Println("No GPU detected. Nvidia driver might be missing. Error while initializing NVML", err)
-			nvmlClient = nil
-		} else {
-			deviceCount, err := nvmlClient.GetDeviceCount()
-
-			if err != nil {
-				fmt.Println(err)
-			} else {
-				fmt.Printf("NVML is loaded found %d gpus\n", deviceCount)
-			}
+// DefaultNvmlClientFactory load the Nvidia driver installed on the node
+func DefaultNvmlClientFactory() (nvml.NvmlClient, error) {
+	client, err := nvml.New()
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
 
-			return GPUStatsCollector{
-				nvml:        nvmlClient,
-				deviceCount: deviceCount,
-			}
+// NewGPUClientFactory factory using the GPU vendor present on the node. Nvidia is used when no AMD GPU is found in sysfs.
+// The detected vendor is printed to out
+func NewGPUClientFactory(sysRoot string, out io.Writer) NvmlClientFactory {
+	return func() (nvml.NvmlClient, error) {
+		if runtime.GOOS == "linux" && amdgpu.Available(sysRoot) {
+			fmt.Fprintln(out, "AMD GPU detected. Using the amdgpu sysfs interface")
+			return amdgpu.New(sysRoot), nil
 		}
+		return DefaultNvmlClientFactory()
+	}
+}
+
+// SimulatedNvmlClientFactory factory creating a client with count simulated GPUs. Used for demos on nodes without GPU
+func SimulatedNvmlClientFactory(count int) NvmlClientFactory {
+	return func() (nvml.NvmlClient, error) {
+		return nvml.NewSimulatedClient(nvml.DefaultSimulatedDevices(count)...), nil
+	}
+}
+
+// NewGPUStatsCollector Create a new instance of the GPU stats collector using the client created by the given factory.
+// Diagnostics are printed to out
+func NewGPUStatsCollector(factory NvmlClientFactory, out io.Writer) *GPUStatsCollector {
+	nvmlClient, err := factory()
+
+	if err != nil {
+		fmt.Fprintln(out, "No GPU detected. Nvidia driver might be missing")
+		return &GPUStatsCollector{out: out}
+	}
+	return NewGPUStatsCollectorWithClient(nvmlClient, out)
+}
+
+// NewGPUStatsCollectorWithClient Create a new instance of the GPU stats collector using the given NVML client.
+// Diagnostics are printed to out
+func NewGPUStatsCollectorWithClient(nvmlClient nvml.NvmlClient, out io.Writer) *GPUStatsCollector {
+	collector := &GPUStatsCollector{
+		nvml:                nvmlClient,
+		out:                 out,
+		identities:          make(map[string]GPUIdentity),
+		lastProcessSampling: make(map[string]time.Time),
+		MinReinitBackoff:    DefaultGPUReinitBackoff,
+		MaxReinitBackoff:    MaxGPUReinitBackoff,
+	}
+
+	if err := nvmlClient.Init(); err != nil {
+		// e.g. the driver is still loading. The first sample tries again, then NVML is reinitialized with the backoff
+		fmt.Fprintln(out, "No GPU detected. Nvidia driver might be missing. Error while initializing NVML", err)
+		return collector
+	}
+
+	deviceCount, err := nvmlClient.GetDeviceCount()
+
+	if err != nil {
+		fmt.Fprintln(out, err)
+		collector.scheduleReinit()
+	} else {
+		fmt.Fprintf(out, "NVML is loaded found %d gpus\n", deviceCount)
+		collector.deviceCount = deviceCount
+		collector.initialized = true
 	}
-	return GPUStatsCollector{}
+	return collector
 }
 
-// GetStats Get GPU stats
-func (gpu GPUStatsCollector) GetStats() []GPUUsage {
+// GetStats Get GPU stats. Devices which couldn't be queried are omitted and reported in the health
+func (gpu *GPUStatsCollector) GetStats() []GPUUsage {
 	if gpu.nvml == nil {
 		return nil
 	}
 
+	if gpu.initialized && gpu.vanished > 0 && !time.Now().Before(gpu.nextInit) {
+		// Look for the vanished GPUs again
+		gpu.nvml.Shutdown()
+		gpu.initialized = false
+	}
+	if !gpu.initialized && !gpu.reinitialize() {
+		for i := range gpu.health {
+			gpu.health[i].State = GPULost
+			gpu.health[i].Errors = 0
+		}
+		return nil
+	}
+
 	var uses []GPUUsage
+	failed, lost := 0, 0
 
+	seen := make(map[int]bool)
 	for i := uint(0); i < gpu.deviceCount; i++ {
-		device, err := gpu.nvml.DeviceGetHandleByIndex(i)
+		usage, err := gpu.getDeviceStats(i)
+		healthIndex := gpu.healthIndex(usage.GPUIdentity, i, seen)
+		seen[healthIndex] = true
+		health := &gpu.health[healthIndex]
+		health.Index = i
+		health.Errors = 0
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintf(gpu.out, "Error while querying GPU %d: %v\n", i, err)
+			recordError(health, err)
+			failed++
+			if nvml.IsGPULost(err) {
+				lost++
+			}
 			continue
 		}
+		health.State = GPUHealthy
+		health.GPUIdentity = usage.GPUIdentity
+		uses = append(uses, usage)
+	}
 
-		memory, err := gpu.nvml.DeviceGetMemoryInfo(device)
+	// e.g. a reinitialization found fewer devices
+	gpu.vanished = 0
+	for i := range gpu.health {
+		if !seen[i] {
+			gpu.health[i].State = GPULost
+			gpu.health[i].Errors = 0
+			gpu.vanished++
+		}
+	}
 
-		if err != nil {
-			fmt.Println(err)
+	if lost > 0 || (failed > 0 && uint(failed) == gpu.deviceCount) {
+		gpu.scheduleReinit()
+	} else if gpu.vanished > 0 {
+		if !time.Now().Before(gpu.nextInit) {
+			gpu.increaseBackoff()
 		}
+	} else if failed == 0 {
+		gpu.backoff = 0
+	}
+	return uses
+}
 
-		use, err := gpu.nvml.DeviceGetUtilizationRates(device)
+// Health Get the health of each GPU during the last sample
+func (gpu *GPUStatsCollector) Health() []GPUHealth {
+	health := make([]GPUHealth, len(gpu.health))
+	copy(health, gpu.health)
+	return health
+}
 
-		if err != nil {
-			fmt.Println(err)
+// Err returns an error when GPUs were detected but some couldn't be queried during the last sample. Nodes without GPU
+// don't report an error
+func (gpu *GPUStatsCollector) Err() error {
+	failed := 0
+	for _, health := range gpu.health {
+		if health.State != GPUHealthy {
+			failed++
 		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d GPUs couldn't be queried", failed, len(gpu.health))
+}
+
+// getDeviceStats query a device. The identity is set when the device could be identified, even if a query failed
+func (gpu *GPUStatsCollector) getDeviceStats(index uint) (GPUUsage, error) {
+	usage := GPUUsage{Index: index}
+	device, err := gpu.nvml.DeviceGetHandleByIndex(index)
+	if err != nil {
+		return usage, err
+	}
+	usage.GPUIdentity = gpu.getIdentity(device)
+
+	memory, err := gpu.nvml.DeviceGetMemoryInfo(device)
+	if err != nil {
+		return usage, err
+	}
+	if memory.Total == 0 {
+		return usage, errors.New("nvml: device reported no memory")
+	}
+
+	use, err := gpu.nvml.DeviceGetUtilizationRates(device)
+	if err != nil {
+		return usage, err
+	}
+
+	usage.GPU = float64(use.GPU)
+	usage.Memory = float64(memory.Used) / float64(memory.Total) * 100
+	usage.MemoryTotal = memory.Total
+	usage.MemoryUsed = memory.Used
+	gpu.getExtendedStats(device, &usage)
+	usage.Processes = gpu.getProcesses(usage, device)
+	return usage, nil
+}
 
-		usage := GPUUsage{
-			GPU:    float64(use.GPU),
-			Memory: float64(memory.Used) / float64(memory.Total) * 100,
+// healthIndex position of the health of a device, added if the device is new. Devices are matched by UUID,
+// devices which couldn't be identified by index among the ones not seen yet in this sample
+func (gpu *GPUStatsCollector) healthIndex(identity GPUIdentity, index uint, seen map[int]bool) int {
+	for i, health := range gpu.health {
+		if identity.UUID != "" && health.UUID == identity.UUID {
+			return i
 		}
-		uses = append(uses, usage)
 	}
-	return uses
+	for i, health := range gpu.health {
+		if !seen[i] && health.Index == index && (identity.UUID == "" || health.UUID == "") {
+			return i
+		}
+	}
+	gpu.health = append(gpu.health, GPUHealth{GPUIdentity: identity, Index: index})
+	return len(gpu.health) - 1
+}
+
+func recordError(health *GPUHealth, err error) {
+	health.Errors++
+	health.LastError = err.Error()
+	if nvml.IsGPULost(err) {
+		health.State = GPULost
+	} else {
+		health.State = GPUError
+	}
+}
+
+// scheduleReinit shutdown NVML and wait for the backoff before initializing it again
+func (gpu *GPUStatsCollector) scheduleReinit() {
+	if gpu.initialized {
+		gpu.nvml.Shutdown()
+		gpu.initialized = false
+	}
+	gpu.increaseBackoff()
+}
+
+// increaseBackoff double the backoff and set the time of the next reinitialization
+func (gpu *GPUStatsCollector) increaseBackoff() {
+	if gpu.backoff == 0 {
+		gpu.backoff = gpu.MinReinitBackoff
+	} else {
+		gpu.backoff *= 2
+	}
+	if gpu.backoff > gpu.MaxReinitBackoff {
+		gpu.backoff = gpu.MaxReinitBackoff
+	}
+	gpu.nextInit = time.Now().Add(gpu.backoff)
+	fmt.Fprintf(gpu.out, "NVML will be reinitialized in %v\n", gpu.backoff)
+}
+
+// reinitialize try to initialize NVML again once the backoff expired. Returns true if NVML is ready
+func (gpu *GPUStatsCollector) reinitialize() bool {
+	if time.Now().Before(gpu.nextInit) {
+		return false
+	}
+
+	if err := gpu.nvml.Init(); err != nil {
+		fmt.Fprintln(gpu.out, "Error while reinitializing NVML", err)
+		gpu.scheduleReinit()
+		return false
+	}
+	gpu.initialized = true
+
+	deviceCount, err := gpu.nvml.GetDeviceCount()
+	if err != nil {
+		fmt.Fprintln(gpu.out, err)
+		gpu.scheduleReinit()
+		return false
+	}
+
+	fmt.Fprintf(gpu.out, "NVML is reinitialized found %d gpus\n", deviceCount)
+	// Device indices might have changed, the GPUs are matched by UUID
+	gpu.deviceCount = deviceCount
+	return true
+}
+
+// getIdentity retrieve the UUID, name and PCI bus ID of the device. The name and PCI bus ID never change so they are only queried once
+func (gpu *GPUStatsCollector) getIdentity(device nvml.Device) GPUIdentity {
+	uuid, uuidErr := gpu.nvml.DeviceGetUUID(device)
+	if identity, ok := gpu.identities[uuid]; ok && uuidErr == nil {
+		return identity
+	}
+
+	identity := GPUIdentity{}
+	if gpu.checkExtendedError(uuidErr) {
+		identity.UUID = uuid
+	}
+	if name, err := gpu.nvml.DeviceGetName(device); gpu.checkExtendedError(err) {
+		identity.Name = name
+	}
+	if busID, err := gpu.nvml.DeviceGetPCIBusID(device); gpu.checkExtendedError(err) {
+		identity.PCIBusID = busID
+	}
+
+	// Retry on the next sample if the device couldn't be identified
+	if uuidErr == nil {
+		gpu.identities[uuid] = identity
+	}
+	return identity
+}
+
+// getExtendedStats retrieve the thermal, power, clocks, ECC and PCIe stats. Queries not supported by the device are skipped
+func (gpu *GPUStatsCollector) getExtendedStats(device nvml.Device, usage *GPUUsage) {
+	if temperature, err := gpu.nvml.DeviceGetTemperature(device); gpu.checkExtendedError(err) {
+		usage.Temperature = floatPtr(float64(temperature))
+	}
+	if power, err := gpu.nvml.DeviceGetPowerUsage(device); gpu.checkExtendedError(err) {
+		usage.PowerDraw = floatPtr(float64(power) / 1000)
+	}
+	if limit, err := gpu.nvml.DeviceGetPowerLimit(device); gpu.checkExtendedError(err) {
+		usage.PowerLimit = floatPtr(float64(limit) / 1000)
+	}
+	if clocks, err := gpu.nvml.DeviceGetClocks(device); gpu.checkExtendedError(err) {
+		usage.SMClock = floatPtr(float64(clocks.SM))
+		usage.MemoryClock = floatPtr(float64(clocks.Memory))
+	}
+	if reasons, err := gpu.nvml.DeviceGetThrottleReasons(device); gpu.checkExtendedError(err) {
+		usage.ThrottleReasons = &reasons
+	}
+	if eccErrors, err := gpu.nvml.DeviceGetECCErrors(device); gpu.checkExtendedError(err) {
+		usage.ECCErrors = &eccErrors
+	}
+	if pcie, err := gpu.nvml.DeviceGetPCIeThroughput(device); gpu.checkExtendedError(err) {
+		usage.PCIeTXBps = floatPtr(float64(pcie.TX) * 1024)
+		usage.PCIeRXBps = floatPtr(float64(pcie.RX) * 1024)
+	}
+}
+
+// getProcesses retrieve the compute processes running on the device and their utilization since the last sample
+func (gpu *GPUStatsCollector) getProcesses(usage GPUUsage, device nvml.Device) []GPUProcessUsage {
+	infos, err := gpu.nvml.DeviceGetComputeRunningProcesses(device)
+	if !gpu.checkExtendedError(err) || len(infos) == 0 {
+		return nil
+	}
+
+	key := usage.UUID
+	if key == "" {
+		key = fmt.Sprintf("GPU #%d", usage.Index)
+	}
+	now := time.Now()
+	since, ok := gpu.lastProcessSampling[key]
+	if !ok {
+		since = now.Add(-DefaultSamplingRate)
+	}
+	gpu.lastProcessSampling[key] = now
+
+	utilizations := make(map[uint32][]nvml.ProcessUtilization)
+	samples, err := gpu.nvml.DeviceGetProcessUtilization(device, since)
+	utilizationSupported := gpu.checkExtendedError(err)
+	for _, sample := range samples {
+		utilizations[sample.PID] = append(utilizations[sample.PID], sample)
+	}
+
+	var processes []GPUProcessUsage
+	for _, info := range infos {
+		usage := GPUProcessUsage{
+			PID:        info.PID,
+			Name:       getProcessName(info.PID),
+			MemoryUsed: info.UsedMemory,
+		}
+		if utilizationSupported {
+			var sm, memory float64
+			for _, sample := range utilizations[info.PID] {
+				sm += float64(sample.SM)
+				memory += float64(sample.Memory)
+			}
+			if count := len(utilizations[info.PID]); count > 0 {
+				sm /= float64(count)
+				memory /= float64(count)
+			}
+			usage.SMUtilization = &sm
+			usage.MemoryUtilization = &memory
+		}
+		processes = append(processes, usage)
+	}
+	return processes
+}
+
+func getProcessName(pid uint32) string {
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return ""
+	}
+	name, err := p.Name()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// GPUProcessTracker attribute the GPU usage of the processes to the watched processes. The GPU processes of the last
+// GPU sample are kept so the usage is attributed when the gpu and processes collectors run at different intervals
+type GPUProcessTracker struct {
+	processNames []string
+	last         []GPUProcessUsage
+}
+
+// NewGPUProcessTracker Create a tracker for the given watched process names
+func NewGPUProcessTracker(processNames []string) *GPUProcessTracker {
+	return &GPUProcessTracker{processNames: processNames}
+}
+
+// Attribute flag the GPU processes which are watched and report their GPU usage on the watched process stats
+func (tracker *GPUProcessTracker) Attribute(stats *NodeStats) {
+	if gpuSampled(stats) {
+		tracker.last = nil
+		for i := range stats.Gpus {
+			for j := range stats.Gpus[i].Processes {
+				gpuProcess := &stats.Gpus[i].Processes[j]
+				gpuProcess.Watched = containsCaseInsensitive(tracker.processNames, gpuProcess.Name)
+				tracker.last = append(tracker.last, *gpuProcess)
+			}
+		}
+	}
+
+	byPid := make(map[int32]*ProcessPerfInfo)
+	for _, process := range stats.Processes {
+		byPid[process.pid] = process
+	}
+	for _, gpuProcess := range tracker.last {
+		if process, ok := byPid[int32(gpuProcess.PID)]; ok {
+			process.gpuMemory += gpuProcess.MemoryUsed
+			if gpuProcess.SMUtilization != nil {
+				process.gpuUsage += *gpuProcess.SMUtilization
+			}
+		}
+	}
+}
+
+// gpuSampled returns true if the sample holds fresh GPU metrics, including a sample without any GPU
+func gpuSampled(stats *NodeStats) bool {
+	if stats.Gpus != nil {
+		return true
+	}
+	for _, status := range stats.Collectors {
+		if status.Name == "gpu" && !status.TimedOut && !status.Skipped {
+			return true
+		}
+	}
+	return false
+}
+
+// checkExtendedError returns true if the query succeeded. Unsupported queries are expected and not printed
+func (gpu *GPUStatsCollector) checkExtendedError(err error) bool {
+	if err != nil && err != nvml.ErrNotSupported {
+		fmt.Fprintln(gpu.out, err)
+	}
+	return err == nil
+}
+
+func floatPtr(value float64) *float64 {
+	return &value
 }
 
 // Shutdown Dispose of the Nvidia driver connection
-func (gpu GPUStatsCollector) Shutdown() {
-	if gpu.nvml == nil {
+func (gpu *GPUStatsCollector) Shutdown() {
+	if gpu.nvml == nil || !gpu.initialized {
 		return
 	}
 	gpu.nvml.Shutdown()
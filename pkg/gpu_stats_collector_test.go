@@ -0,0 +1,253 @@
+package batchinsights_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/nvml"
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func simulatedV100(uuid string) nvml.SimulatedDevice {
+	return nvml.SimulatedDevice{
+		UUID:        uuid,
+		Name:        "Tesla V100-PCIE-16GB",
+		PCIBusID:    "00000001:00:00.0",
+		MemoryTotal: 1000,
+		MemoryUsed:  nvml.ConstantCurve(250),
+		Utilization: nvml.ConstantCurve(80),
+		PowerUsage:  nvml.ConstantCurve(150000),
+		PowerLimit:  250000,
+	}
+}
+
+func TestGPUStats(t *testing.T) {
+	client := nvml.NewSimulatedClient(simulatedV100("GPU-8d3a6a5e-0f8c-4b43-9a71-6a8b1c2d3e4f"))
+	factory := func() (nvml.NvmlClient, error) { return client, nil }
+	collector := batchinsights.NewGPUStatsCollector(factory, ioutil.Discard)
+
+	gpus := collector.GetStats()
+
+	assert.Equal(t, 1, len(gpus))
+	assert.Equal(t, "GPU-8d3a6a5e-0f8c-4b43-9a71-6a8b1c2d3e4f", gpus[0].UUID)
+	assert.Equal(t, "Tesla V100-PCIE-16GB", gpus[0].Name)
+	assert.Equal(t, float64(80), gpus[0].GPU)
+	assert.Equal(t, uint64(250), gpus[0].MemoryUsed)
+	assert.Equal(t, float64(25), gpus[0].Memory)
+	assert.Equal(t, 150.0, *gpus[0].PowerDraw)
+	assert.Equal(t, 250.0, *gpus[0].PowerLimit)
+	assert.Nil(t, gpus[0].Temperature)
+	assert.Nil(t, gpus[0].SMClock)
+}
+
+func TestGPUMemoryInfoError(t *testing.T) {
+	failing := simulatedV100("GPU-0")
+	failing.Errors = map[string]error{"DeviceGetMemoryInfo": errors.New("nvml: Unknown Error")}
+	client := nvml.NewSimulatedClient(failing, simulatedV100("GPU-1"))
+	collector := batchinsights.NewGPUStatsCollectorWithClient(client, ioutil.Discard)
+
+	gpus := collector.GetStats()
+
+	assert.Equal(t, 1, len(gpus))
+	assert.Equal(t, "GPU-1", gpus[0].UUID)
+	assert.Equal(t, uint(1), gpus[0].Index)
+	assert.Equal(t, float64(25), gpus[0].Memory)
+
+	health := collector.Health()
+	assert.Equal(t, 2, len(health))
+	assert.Equal(t, batchinsights.GPUError, health[0].State)
+	assert.Equal(t, uint(1), health[0].Errors)
+	assert.Equal(t, "nvml: Unknown Error", health[0].LastError)
+	assert.Equal(t, batchinsights.GPUHealthy, health[1].State)
+}
+
+func TestGPULostReinitialization(t *testing.T) {
+	client := nvml.NewSimulatedClient(simulatedV100("GPU-0"))
+	collector := batchinsights.NewGPUStatsCollectorWithClient(client, ioutil.Discard)
+	collector.MinReinitBackoff = 0
+
+	client.Devices[0].Errors = map[string]error{"DeviceGetUtilizationRates": nvml.ErrGPULost}
+	gpus := collector.GetStats()
+
+	assert.Equal(t, 0, len(gpus))
+	assert.Equal(t, batchinsights.GPULost, collector.Health()[0].State)
+	assert.NotNil(t, collector.Err())
+
+	client.Devices[0].Errors = nil
+	gpus = collector.GetStats()
+
+	assert.Equal(t, 1, len(gpus))
+	assert.Equal(t, "GPU-0", gpus[0].UUID)
+	assert.Equal(t, batchinsights.GPUHealthy, collector.Health()[0].State)
+	assert.Nil(t, collector.Err())
+}
+
+func TestGPUVanishedAfterReinitialization(t *testing.T) {
+	client := nvml.NewSimulatedClient(simulatedV100("GPU-0"), simulatedV100("GPU-1"))
+	collector := batchinsights.NewGPUStatsCollectorWithClient(client, ioutil.Discard)
+	collector.MinReinitBackoff = 0
+	collector.GetStats()
+
+	// The reinitialization only finds GPU-1, now at index 0
+	devices := client.Devices
+	client.Devices[0].Errors = map[string]error{"DeviceGetUtilizationRates": nvml.ErrGPULost}
+	collector.GetStats()
+	client.Devices = devices[1:]
+	gpus := collector.GetStats()
+
+	assert.Equal(t, 1, len(gpus))
+	assert.Equal(t, "GPU-1", gpus[0].UUID)
+	health := collector.Health()
+	assert.Equal(t, 2, len(health))
+	assert.Equal(t, "GPU-0", health[0].UUID)
+	assert.Equal(t, batchinsights.GPULost, health[0].State)
+	assert.Equal(t, "GPU-1", health[1].UUID)
+	assert.Equal(t, uint(0), health[1].Index)
+	assert.Equal(t, batchinsights.GPUHealthy, health[1].State)
+
+	// Still lost until it comes back
+	collector.GetStats()
+	assert.Equal(t, batchinsights.GPULost, collector.Health()[0].State)
+
+	devices[0].Errors = nil
+	client.Devices = devices
+	collector.GetStats()
+	collector.GetStats()
+	health = collector.Health()
+	assert.Equal(t, 2, len(health))
+	assert.Equal(t, batchinsights.GPUHealthy, health[0].State)
+	assert.Equal(t, batchinsights.GPUHealthy, health[1].State)
+}
+
+func TestGPUReinitializationBackoff(t *testing.T) {
+	client := nvml.NewSimulatedClient(simulatedV100("GPU-0"))
+	collector := batchinsights.NewGPUStatsCollectorWithClient(client, ioutil.Discard)
+
+	client.Devices[0].Errors = map[string]error{"DeviceGetMemoryInfo": nvml.ErrGPULost}
+	collector.GetStats()
+	client.Devices[0].Errors = nil
+
+	// Still waiting for the backoff to expire
+	assert.Equal(t, 0, len(collector.GetStats()))
+	assert.Equal(t, batchinsights.GPULost, collector.Health()[0].State)
+}
+
+func TestGPUInitError(t *testing.T) {
+	client := nvml.NewSimulatedClient(simulatedV100("GPU-0"))
+	client.Errors["Init"] = errors.New("nvml: Driver Not Loaded")
+	collector := batchinsights.NewGPUStatsCollectorWithClient(client, ioutil.Discard)
+	collector.MinReinitBackoff = time.Hour
+
+	assert.Nil(t, collector.GetStats())
+	// No GPU was ever detected
+	assert.Nil(t, collector.Err())
+
+	// Not retried before the backoff expires
+	delete(client.Errors, "Init")
+	assert.Nil(t, collector.GetStats())
+}
+
+func TestGPUInitRetry(t *testing.T) {
+	client := nvml.NewSimulatedClient(simulatedV100("GPU-0"))
+	client.Errors["Init"] = errors.New("nvml: Driver Not Loaded")
+	collector := batchinsights.NewGPUStatsCollectorWithClient(client, ioutil.Discard)
+	collector.MinReinitBackoff = 0
+
+	assert.Nil(t, collector.GetStats())
+
+	// e.g. the driver finished loading after batch insights started
+	delete(client.Errors, "Init")
+	gpus := collector.GetStats()
+	assert.Equal(t, 1, len(gpus))
+	assert.Equal(t, "GPU-0", gpus[0].UUID)
+}
+
+func TestGPUProcesses(t *testing.T) {
+	pid := uint32(os.Getpid())
+	device := simulatedV100("GPU-0")
+	device.Processes = []nvml.ProcessInfo{{PID: pid, UsedMemory: 2048}}
+	device.ProcessSamples = []nvml.ProcessUtilization{
+		{PID: pid, SM: 30, Memory: 10},
+		{PID: pid, SM: 50, Memory: 20},
+		{PID: pid + 1, SM: 90, Memory: 90},
+	}
+	client := nvml.NewSimulatedClient(device)
+	collector := batchinsights.NewGPUStatsCollectorWithClient(client, ioutil.Discard)
+
+	gpus := collector.GetStats()
+
+	assert.Equal(t, 1, len(gpus))
+	assert.Equal(t, 1, len(gpus[0].Processes))
+	process := gpus[0].Processes[0]
+	assert.Equal(t, pid, process.PID)
+	assert.NotEqual(t, "", process.Name)
+	assert.Equal(t, uint64(2048), process.MemoryUsed)
+	assert.Equal(t, float64(40), *process.SMUtilization)
+	assert.Equal(t, float64(15), *process.MemoryUtilization)
+}
+
+func TestGPUProcessTracker(t *testing.T) {
+	sm := 30.0
+	python := batchinsights.GPUProcessUsage{PID: 10, Name: "Python", MemoryUsed: 100, SMUtilization: &sm}
+	unknown := batchinsights.GPUProcessUsage{PID: 99, Name: "other", MemoryUsed: 500}
+	gpuSample := []batchinsights.CollectorStatus{{Name: "gpu"}}
+
+	tests := []struct {
+		name      string
+		gpus      []batchinsights.GPUUsage
+		gpuMemory uint64
+		gpuUsage  float64
+	}{
+		{"PID match", []batchinsights.GPUUsage{{Processes: []batchinsights.GPUProcessUsage{python}}, {Processes: []batchinsights.GPUProcessUsage{python}}}, 200, 60},
+		{"unknown PID", []batchinsights.GPUUsage{{Processes: []batchinsights.GPUProcessUsage{unknown}}}, 0, 0},
+		{"no GPU process", []batchinsights.GPUUsage{{}}, 0, 0},
+		{"no GPU", nil, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := batchinsights.NewGPUProcessTracker([]string{"python"})
+			// An earlier GPU sample, replaced by the one under test
+			tracker.Attribute(&batchinsights.NodeStats{
+				Gpus:       []batchinsights.GPUUsage{{Processes: []batchinsights.GPUProcessUsage{{PID: 10, MemoryUsed: 1000}}}},
+				Collectors: gpuSample,
+			})
+
+			stats := batchinsights.NodeStats{Gpus: test.gpus, Collectors: gpuSample, Processes: processes(t)}
+			tracker.Attribute(&stats)
+			assertGPUUsage(t, stats.Processes[0], test.gpuMemory, test.gpuUsage)
+			for _, gpu := range stats.Gpus {
+				for _, process := range gpu.Processes {
+					assert.Equal(t, process.PID == 10, process.Watched)
+				}
+			}
+
+			// The processes are sampled without the GPUs, the last GPU sample is used
+			stats = batchinsights.NodeStats{Processes: processes(t)}
+			tracker.Attribute(&stats)
+			assertGPUUsage(t, stats.Processes[0], test.gpuMemory, test.gpuUsage)
+		})
+	}
+}
+
+func processes(t *testing.T) []*batchinsights.ProcessPerfInfo {
+	var processes []*batchinsights.ProcessPerfInfo
+	assert.Nil(t, json.Unmarshal([]byte(`[{"PID": 10, "Name": "python"}]`), &processes))
+	return processes
+}
+
+func assertGPUUsage(t *testing.T, process *batchinsights.ProcessPerfInfo, gpuMemory uint64, gpuUsage float64) {
+	data, err := json.Marshal(process)
+	assert.Nil(t, err)
+	var value struct {
+		GPUMemory uint64
+		GPUUsage  float64
+	}
+	assert.Nil(t, json.Unmarshal(data, &value))
+	assert.Equal(t, gpuMemory, value.GPUMemory)
+	assert.Equal(t, gpuUsage, value.GPUUsage)
+}
@@ -0,0 +1,203 @@
+package batchinsights
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHistoryRetention default time range of samples kept in memory for the query API
+const DefaultHistoryRetention = 10 * time.Minute
+
+// Sample stats collected at a given time
+type Sample struct {
+	Time  time.Time
+	Stats NodeStats
+}
+
+// History ring buffer of the most recent samples
+type History struct {
+	retention time.Duration
+	samples   []Sample
+	next      int // Index where the next sample is written
+	count     int
+	lock      sync.RWMutex
+}
+
+// HistoryQuery filters of a history query. Zero values don't filter
+type HistoryQuery struct {
+	From    time.Time
+	To      time.Time
+	Metrics []string // Metric names, case insensitive. A trailing * matches a prefix. e.g. "Gpu *"
+}
+
+// Series values of a metric with the same properties over time
+type Series struct {
+	Name       string
+	Properties map[string]string `json:",omitempty"`
+	Points     []Point
+	Min        float64
+	Max        float64
+	Avg        float64
+	Last       float64
+}
+
+// Point value of a metric at a given time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// NewHistory Create a history keeping the samples of the last retention. tickInterval is used to size the buffer
+func NewHistory(retention time.Duration, tickInterval time.Duration) *History {
+	capacity := 1
+	if tickInterval > 0 {
+		capacity = int(retention/tickInterval) + 1
+	}
+	return &History{retention: retention, samples: make([]Sample, capacity)}
+}
+
+// Add record a sample, overwriting the oldest one when the buffer is full
+func (history *History) Add(now time.Time, stats NodeStats) {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	history.samples[history.next] = Sample{Time: now, Stats: stats}
+	history.next = (history.next + 1) % len(history.samples)
+	if history.count < len(history.samples) {
+		history.count++
+	}
+}
+
+// Samples samples in the given time range, oldest first. Samples older than the retention are ignored
+func (history *History) Samples(from time.Time, to time.Time) []Sample {
+	history.lock.RLock()
+	defer history.lock.RUnlock()
+
+	result := []Sample{}
+	oldest := (history.next - history.count + len(history.samples)) % len(history.samples)
+	var newest time.Time
+	if history.count > 0 {
+		newest = history.samples[(history.next-1+len(history.samples))%len(history.samples)].Time
+	}
+	for i := 0; i < history.count; i++ {
+		sample := history.samples[(oldest+i)%len(history.samples)]
+		if newest.Sub(sample.Time) > history.retention {
+			continue
+		}
+		if (!from.IsZero() && sample.Time.Before(from)) || (!to.IsZero() && sample.Time.After(to)) {
+			continue
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+// Query series of the metrics matching the query
+func (history *History) Query(query HistoryQuery) []Series {
+	series := make(map[string]*Series)
+	var ids []string
+	for _, sample := range history.Samples(query.From, query.To) {
+		for _, metric := range FlattenStats(sample.Stats) {
+			if !matchMetric(metric.Name, query.Metrics) {
+				continue
+			}
+			id := metric.ID()
+			current, ok := series[id]
+			if !ok {
+				current = &Series{Name: metric.Name, Properties: metric.Properties, Min: metric.Value, Max: metric.Value}
+				series[id] = current
+				ids = append(ids, id)
+			}
+			current.add(Point{Time: sample.Time, Value: metric.Value})
+		}
+	}
+
+	sort.Strings(ids)
+	result := make([]Series, 0, len(ids))
+	for _, id := range ids {
+		current := series[id]
+		current.Avg /= float64(len(current.Points))
+		result = append(result, *current)
+	}
+	return result
+}
+
+// add append a point. Avg holds the sum until the series is complete
+func (series *Series) add(point Point) {
+	series.Points = append(series.Points, point)
+	if point.Value < series.Min {
+		series.Min = point.Value
+	}
+	if point.Value > series.Max {
+		series.Max = point.Value
+	}
+	series.Avg += point.Value
+	series.Last = point.Value
+}
+
+func matchMetric(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseHistoryQuery parse the query parameters: since(e.g. 5m), from and to(RFC3339) and metric(repeatable)
+func ParseHistoryQuery(r *http.Request, now time.Time) (HistoryQuery, error) {
+	values := r.URL.Query()
+	query := HistoryQuery{Metrics: values["metric"]}
+	if since := values.Get("since"); since != "" {
+		duration, err := time.ParseDuration(since)
+		if err != nil {
+			return query, fmt.Errorf("Invalid since: %v", err)
+		}
+		query.From = now.Add(-duration)
+	}
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"from", &query.From}, {"to", &query.To}} {
+		if value := values.Get(param.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, fmt.Errorf("Invalid %s: %v", param.name, err)
+			}
+			*param.value = parsed
+		}
+	}
+	return query, nil
+}
+
+// HandleMetrics serve the series of the metrics matching the query. e.g. /metrics?since=5m&metric=Memory%20used
+func (history *History) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseHistoryQuery(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, history.Query(query))
+}
+
+// HandleSamples serve the raw samples in the query time range. e.g. /samples?since=1m
+func (history *History) HandleSamples(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseHistoryQuery(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, history.Samples(query.From, query.To))
+}
@@ -0,0 +1,57 @@
+package batchinsights_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	// Holds 3 samples
+	history := batchinsights.NewHistory(10*time.Second, 5*time.Second)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		history.Add(start.Add(time.Duration(i)*5*time.Second), batchinsights.NodeStats{
+			Memory:      &mem.VirtualMemoryStat{Used: uint64(i * 100), Total: 1000},
+			CPUPercents: []float64{float64(i)},
+		})
+	}
+
+	samples := history.Samples(time.Time{}, time.Time{})
+	assert.Equal(t, 3, len(samples))
+	assert.Equal(t, uint64(200), samples[0].Stats.Memory.Used)
+	assert.Equal(t, uint64(400), samples[2].Stats.Memory.Used)
+
+	series := history.Query(batchinsights.HistoryQuery{
+		From:    start.Add(15 * time.Second),
+		Metrics: []string{"memory USED", "cpu *"},
+	})
+	assert.Equal(t, 2, len(series))
+	assert.Equal(t, "Cpu usage", series[0].Name)
+	assert.Equal(t, "0", series[0].Properties["CPU #"])
+	assert.Equal(t, "Memory used", series[1].Name)
+	assert.Equal(t, 2, len(series[1].Points))
+	assert.Equal(t, 300.0, series[1].Min)
+	assert.Equal(t, 400.0, series[1].Max)
+	assert.Equal(t, 350.0, series[1].Avg)
+	assert.Equal(t, 400.0, series[1].Last)
+}
+
+func TestHistoryQueryParameters(t *testing.T) {
+	history := batchinsights.NewHistory(time.Minute, 5*time.Second)
+	history.Add(time.Now(), batchinsights.NodeStats{CPUPercents: []float64{50}})
+
+	recorder := httptest.NewRecorder()
+	history.HandleMetrics(recorder, httptest.NewRequest("GET", "/metrics?since=5m&metric=Cpu%20usage", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"Max": 50`)
+
+	recorder = httptest.NewRecorder()
+	history.HandleMetrics(recorder, httptest.NewRequest("GET", "/metrics?since=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
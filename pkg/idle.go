@@ -0,0 +1,175 @@
+package batchinsights
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/events"
+)
+
+// DefaultIdleWindow default time range over which the node utilization is averaged
+const DefaultIdleWindow = 5 * time.Minute
+
+// Utilization thresholds of the idle analyzer
+const (
+	IdleCPUPercent = 5.0    // Average CPU usage below which the node can be idle
+	IdleGPUPercent = 5.0    // Average usage of every GPU below which the node can be idle
+	IdleNetworkBps = 100000 // Network traffic below which the node can be idle. Covers the agents sending telemetry
+	BusyPercent    = 50.0   // Average usage of the GPUs, or of the CPU without GPUs, above which the node is busy
+)
+
+// NodeState utilization class of the node
+type NodeState string
+
+const (
+	// NodeIdle no CPU, GPU and network activity and no watched process running
+	NodeIdle NodeState = "idle"
+	// NodeUnderutilized some activity but the node is not busy. e.g. a CPU only task on a GPU node
+	NodeUnderutilized NodeState = "underutilized"
+	// NodeBusy the GPUs, or the CPU without GPUs, are used
+	NodeBusy NodeState = "busy"
+)
+
+// IdleStats utilization of the node over the analyzer window
+type IdleStats struct {
+	State     NodeState
+	Since     time.Time     // Time the node entered the state
+	TimeIdle  time.Duration // Time the node has been idle, 0 when not idle
+	CPU       *float64      // Average CPU usage, nil when not collected
+	GPU       *float64      // Average usage of the most used GPU, nil without GPUs
+	Network   *float64      // Average network traffic in bytes per second, nil when not collected
+	Processes *int          // Maximum number of watched processes running, nil when not collected
+}
+
+// IdleAnalyzer classify the node as idle, underutilized or busy over a sliding window
+type IdleAnalyzer struct {
+	window  time.Duration
+	samples []idleSample
+	state   NodeState
+	since   time.Time
+}
+
+// idleSample utilization values of a sample, nil when not collected in the sample
+type idleSample struct {
+	time      time.Time
+	cpu       *float64
+	gpu       *float64
+	network   *float64
+	processes *int
+}
+
+// NewIdleAnalyzer Create an analyzer averaging the utilization over the given window
+func NewIdleAnalyzer(window time.Duration) *IdleAnalyzer {
+	return &IdleAnalyzer{window: window}
+}
+
+// Analyze add a sample to the window and classify the node. The event is set when the state changed.
+// Returns nil stats until a sample contains a utilization value
+func (analyzer *IdleAnalyzer) Analyze(now time.Time, stats NodeStats) (*IdleStats, *events.Event) {
+	analyzer.add(now, stats)
+	current := analyzer.average()
+	if current.CPU == nil && current.GPU == nil && current.Network == nil && current.Processes == nil {
+		return nil, nil
+	}
+
+	state := classify(current)
+	var event *events.Event
+	if state != analyzer.state {
+		if analyzer.state != "" {
+			event = &events.Event{
+				Time:    now,
+				Type:    events.Type("Node " + string(state)),
+				Message: fmt.Sprintf("Node is %s, was %s for %v", state, analyzer.state, now.Sub(analyzer.since).Round(time.Second)),
+				Count:   1,
+				Properties: map[string]string{
+					"Previous state":    string(analyzer.state),
+					"Previous duration": strconv.FormatFloat(now.Sub(analyzer.since).Seconds(), 'f', 0, 64),
+				},
+			}
+		}
+		analyzer.state = state
+		analyzer.since = now
+	}
+	current.State = state
+	current.Since = analyzer.since
+	if state == NodeIdle {
+		current.TimeIdle = now.Sub(analyzer.since)
+	}
+	return &current, event
+}
+
+func (analyzer *IdleAnalyzer) add(now time.Time, stats NodeStats) {
+	sample := idleSample{time: now}
+	if len(stats.CPUPercents) > 0 {
+		cpu := avg(stats.CPUPercents)
+		sample.cpu = &cpu
+	}
+	for _, usage := range stats.Gpus {
+		gpu := usage.GPU
+		if sample.gpu == nil || gpu > *sample.gpu {
+			sample.gpu = &gpu
+		}
+	}
+	if stats.NetIO != nil {
+		network := float64(stats.NetIO.ReadBps + stats.NetIO.WriteBps)
+		sample.network = &network
+	}
+	if stats.Processes != nil {
+		processes := len(stats.Processes)
+		sample.processes = &processes
+	}
+	analyzer.samples = append(analyzer.samples, sample)
+	for len(analyzer.samples) > 1 && now.Sub(analyzer.samples[0].time) > analyzer.window {
+		analyzer.samples = analyzer.samples[1:]
+	}
+}
+
+// average utilization over the window. Every value is averaged over the samples where it was collected
+func (analyzer *IdleAnalyzer) average() IdleStats {
+	var cpu, gpu, network []float64
+	var processes *int
+	for _, sample := range analyzer.samples {
+		if sample.cpu != nil {
+			cpu = append(cpu, *sample.cpu)
+		}
+		if sample.gpu != nil {
+			gpu = append(gpu, *sample.gpu)
+		}
+		if sample.network != nil {
+			network = append(network, *sample.network)
+		}
+		if sample.processes != nil && (processes == nil || *sample.processes > *processes) {
+			processes = sample.processes
+		}
+	}
+	return IdleStats{CPU: optionalAvg(cpu), GPU: optionalAvg(gpu), Network: optionalAvg(network), Processes: processes}
+}
+
+func optionalAvg(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	value := avg(values)
+	return &value
+}
+
+// classify the node. Values which weren't collected don't prevent the node from being idle
+func classify(stats IdleStats) NodeState {
+	idle := (stats.CPU == nil || *stats.CPU < IdleCPUPercent) &&
+		(stats.GPU == nil || *stats.GPU < IdleGPUPercent) &&
+		(stats.Network == nil || *stats.Network < IdleNetworkBps) &&
+		(stats.Processes == nil || *stats.Processes == 0)
+	if idle {
+		return NodeIdle
+	}
+	// The GPUs are what matters on a GPU node
+	if stats.GPU != nil {
+		if *stats.GPU >= BusyPercent {
+			return NodeBusy
+		}
+	} else if stats.CPU != nil && *stats.CPU >= BusyPercent {
+		return NodeBusy
+	}
+	return NodeUnderutilized
+}
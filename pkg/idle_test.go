@@ -0,0 +1,48 @@
+package batchinsights_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/Azure/batch-insights/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdleAnalyzer(t *testing.T) {
+	analyzer := batchinsights.NewIdleAnalyzer(time.Minute)
+	start := time.Now()
+	sample := func(cpu float64, gpu float64) batchinsights.NodeStats {
+		return batchinsights.NodeStats{
+			CPUPercents: []float64{cpu, cpu},
+			Gpus:        []batchinsights.GPUUsage{{GPU: 0}, {GPU: gpu}},
+			NetIO:       &utils.IOStats{ReadBps: 1000, WriteBps: 1000},
+		}
+	}
+
+	idle, event := analyzer.Analyze(start, sample(1, 0))
+	assert.Equal(t, batchinsights.NodeIdle, idle.State)
+	assert.Nil(t, event)
+	idle, _ = analyzer.Analyze(start.Add(30*time.Second), batchinsights.NodeStats{})
+	assert.Equal(t, 30*time.Second, idle.TimeIdle)
+
+	// A CPU only task on a GPU node
+	idle, event = analyzer.Analyze(start.Add(time.Minute), sample(90, 0))
+	assert.Equal(t, batchinsights.NodeUnderutilized, idle.State)
+	assert.Equal(t, time.Duration(0), idle.TimeIdle)
+	assert.Equal(t, "Node underutilized", string(event.Type))
+	assert.Equal(t, "idle", event.Properties["Previous state"])
+	assert.Equal(t, "60", event.Properties["Previous duration"])
+
+	// The most used GPU is averaged over the window
+	idle, _ = analyzer.Analyze(start.Add(90*time.Second), sample(90, 100))
+	assert.Equal(t, 50.0, *idle.GPU)
+	assert.Equal(t, batchinsights.NodeBusy, idle.State)
+
+	// A watched process is running
+	stats := sample(0, 0)
+	stats.Processes = []*batchinsights.ProcessPerfInfo{nil}
+	analyzer = batchinsights.NewIdleAnalyzer(time.Minute)
+	idle, _ = analyzer.Analyze(start, stats)
+	assert.Equal(t, batchinsights.NodeUnderutilized, idle.State)
+}
@@ -0,0 +1,175 @@
+package infiniband
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/utils"
+)
+
+// The data counters are expressed in 4 byte words (octets divided by 4)
+const bytesPerWord = 4
+
+// Link state reported by an active port. e.g. "4: ACTIVE"
+const stateActive = 4
+
+// Stats counters of a single InfiniBand port
+type Stats struct {
+	HCA                   string // Host channel adapter. e.g. mlx5_0
+	Port                  string
+	State                 string  // e.g. ACTIVE, DOWN
+	Active                bool    // Link is up and able to transmit data
+	RateGbps              float64 // Link rate. e.g. 100 for 4X EDR
+	TransmitBps           uint64
+	ReceiveBps            uint64
+	TransmitPacketsPerSec float64
+	ReceivePacketsPerSec  float64
+	SymbolErrors          uint64 // Number of symbol errors since the last sample
+	LinkErrorRecoveries   uint64 // Number of link error recoveries since the last sample
+	LinkDowned            uint64 // Number of times the link went down since the last sample
+	ReceiveErrors         uint64 // Number of malformed packets received since the last sample
+}
+
+// Collector collector that retrieve the InfiniBand port counters from sysfs
+type Collector struct {
+	sysRoot string
+	rates   utils.RateAggregator
+	errors  map[string]uint64
+}
+
+// NewCollector Create a new InfiniBand collector reading sysfs at the given root
+func NewCollector(sysRoot string) *Collector {
+	return &Collector{
+		sysRoot: sysRoot,
+		errors:  make(map[string]uint64),
+	}
+}
+
+// Available returns true if the node has at least one InfiniBand HCA
+func Available(sysRoot string) bool {
+	entries, err := ioutil.ReadDir(filepath.Join(sysRoot, "class", "infiniband"))
+	return err == nil && len(entries) > 0
+}
+
+// GetStats Get the counters of every port of every HCA
+func (collector *Collector) GetStats() ([]Stats, error) {
+	hcaRoot := filepath.Join(collector.sysRoot, "class", "infiniband")
+	hcas, err := ioutil.ReadDir(hcaRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	var result []Stats
+	for _, hca := range hcas {
+		portRoot := filepath.Join(hcaRoot, hca.Name(), "ports")
+		ports, err := ioutil.ReadDir(portRoot)
+		if err != nil {
+			continue
+		}
+		for _, port := range ports {
+			stats, ok := collector.readPort(filepath.Join(portRoot, port.Name()), hca.Name(), port.Name(), now, seen)
+			if ok {
+				result = append(result, stats)
+			}
+		}
+	}
+
+	collector.rates.Prune(now)
+	collector.prune(seen)
+	return result, nil
+}
+
+// prune forget the error counters of the ports which are gone. seen contains the keys of the counters read in this scan
+func (collector *Collector) prune(seen map[string]bool) {
+	for key := range collector.errors {
+		if !seen[key] {
+			delete(collector.errors, key)
+		}
+	}
+}
+
+func (collector *Collector) readPort(dir string, hca string, port string, now time.Time, seen map[string]bool) (Stats, bool) {
+	stats := Stats{HCA: hca, Port: port}
+
+	state, err := utils.ReadString(filepath.Join(dir, "state"))
+	if err != nil {
+		return stats, false
+	}
+	stats.State, stats.Active = parseState(state)
+	if rate, err := utils.ReadString(filepath.Join(dir, "rate")); err == nil {
+		stats.RateGbps = ParseRate(rate)
+	}
+
+	counters := filepath.Join(dir, "counters")
+	key := hca + "/" + port + "/"
+	if value, err := utils.ReadUint(filepath.Join(counters, "port_xmit_data")); err == nil {
+		if rate, ok := collector.rates.UpdateRate(key+"xmitData", value*bytesPerWord, now); ok {
+			stats.TransmitBps = uint64(rate)
+		}
+	}
+	if value, err := utils.ReadUint(filepath.Join(counters, "port_rcv_data")); err == nil {
+		if rate, ok := collector.rates.UpdateRate(key+"rcvData", value*bytesPerWord, now); ok {
+			stats.ReceiveBps = uint64(rate)
+		}
+	}
+	if value, err := utils.ReadUint(filepath.Join(counters, "port_xmit_packets")); err == nil {
+		stats.TransmitPacketsPerSec, _ = collector.rates.UpdateRate(key+"xmitPackets", value, now)
+	}
+	if value, err := utils.ReadUint(filepath.Join(counters, "port_rcv_packets")); err == nil {
+		stats.ReceivePacketsPerSec, _ = collector.rates.UpdateRate(key+"rcvPackets", value, now)
+	}
+
+	stats.SymbolErrors = collector.errorDelta(key, counters, "symbol_error", seen)
+	stats.LinkErrorRecoveries = collector.errorDelta(key, counters, "link_error_recovery", seen)
+	stats.LinkDowned = collector.errorDelta(key, counters, "link_downed", seen)
+	stats.ReceiveErrors = collector.errorDelta(key, counters, "port_rcv_errors", seen)
+	return stats, true
+}
+
+// errorDelta number of errors since the last sample. Errors counted before batch insights started are ignored
+func (collector *Collector) errorDelta(key string, counters string, name string, seen map[string]bool) uint64 {
+	value, err := utils.ReadUint(filepath.Join(counters, name))
+	if err != nil {
+		return 0
+	}
+	key += name
+	seen[key] = true
+	last, ok := collector.errors[key]
+	collector.errors[key] = value
+	if !ok || value < last {
+		return 0
+	}
+	return value - last
+}
+
+// parseState parse the port state. e.g. "4: ACTIVE"
+func parseState(value string) (string, bool) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return value, false
+	}
+	code, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
+	return strings.TrimSpace(parts[1]), code == stateActive
+}
+
+// ParseRate parse the port rate in Gb/s. e.g. "100 Gb/sec (4X EDR)"
+func ParseRate(value string) float64 {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+	rate, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return rate
+}
@@ -0,0 +1,80 @@
+package infiniband_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/infiniband"
+	"github.com/Azure/batch-insights/pkg/testfixture"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStats(t *testing.T) {
+	assert.True(t, infiniband.Available("testdata/sys"))
+	assert.False(t, infiniband.Available("testdata/missing"))
+
+	collector := infiniband.NewCollector("testdata/sys")
+	stats, err := collector.GetStats()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, "mlx5_0", stats[0].HCA)
+	assert.Equal(t, "1", stats[0].Port)
+	assert.Equal(t, "ACTIVE", stats[0].State)
+	assert.True(t, stats[0].Active)
+	assert.Equal(t, 100.0, stats[0].RateGbps)
+	assert.Equal(t, uint64(0), stats[0].SymbolErrors)
+
+	assert.Equal(t, "DOWN", stats[1].State)
+	assert.False(t, stats[1].Active)
+	assert.Equal(t, 10.0, stats[1].RateGbps)
+}
+
+func TestGetStatsRates(t *testing.T) {
+	f := testfixture.New(t)
+	defer f.Close()
+	port := "class/infiniband/mlx5_0/ports/1/"
+	f.Write(port+"state", "4: ACTIVE\n")
+	f.Write(port+"counters/port_xmit_data", "1000")
+	f.Write(port+"counters/port_rcv_packets", "10")
+	f.Write(port+"counters/symbol_error", "5")
+
+	collector := infiniband.NewCollector(f.Root)
+	start := time.Now()
+	_, err := collector.GetStats()
+	firstDone := time.Now()
+	assert.Nil(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+	f.Write(port+"counters/port_xmit_data", "26000")
+	f.Write(port+"counters/port_rcv_packets", "1010")
+	f.Write(port+"counters/symbol_error", "7")
+	secondStart := time.Now()
+	stats, err := collector.GetStats()
+	end := time.Now()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stats))
+	minElapsed, maxElapsed := secondStart.Sub(firstDone).Seconds(), end.Sub(start).Seconds()
+	// 25000 words of 4 bytes, truncated to whole bytes per second
+	assert.True(t, float64(stats[0].TransmitBps) >= 100000/maxElapsed-1 && float64(stats[0].TransmitBps) <= 100000/minElapsed)
+	assert.True(t, stats[0].ReceivePacketsPerSec >= 1000/maxElapsed && stats[0].ReceivePacketsPerSec <= 1000/minElapsed)
+	assert.Equal(t, uint64(2), stats[0].SymbolErrors)
+
+	// The port disappears, e.g. the HCA was reset, and comes back. The errors counted meanwhile are ignored
+	f.Remove(port)
+	stats, err = collector.GetStats()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(stats))
+	f.Write(port+"state", "4: ACTIVE\n")
+	f.Write(port+"counters/symbol_error", "20")
+	stats, err = collector.GetStats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), stats[0].SymbolErrors)
+}
+
+func TestParseRate(t *testing.T) {
+	assert.Equal(t, 200.0, infiniband.ParseRate("200 Gb/sec (4X HDR)"))
+	assert.Equal(t, 2.5, infiniband.ParseRate("2.5 Gb/sec (1X SDR)"))
+	assert.Equal(t, 0.0, infiniband.ParseRate(""))
+}
@@ -0,0 +1,189 @@
+package load
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/utils"
+)
+
+// PressureResources resources exposing Pressure Stall Information under /proc/pressure
+var PressureResources = []string{"cpu", "memory", "io"}
+
+// Stats load and pressure of the node
+type Stats struct {
+	Load1        float64
+	Load5        float64
+	Load15       float64
+	ProcsRunning uint64 // Number of processes in runnable state
+	ProcsBlocked uint64 // Number of processes blocked waiting for I/O
+	Pressure     []PressureStats
+}
+
+// PressureStats Pressure Stall Information for a single resource and kind
+type PressureStats struct {
+	Resource     string  // cpu, memory or io
+	Kind         string  // some or full
+	Avg10        float64 // Percent of time stalled over the last 10 seconds
+	Avg60        float64
+	Avg300       float64
+	Total        uint64  // Total stall time in microseconds
+	StallPercent float64 // Percent of time stalled since the last sample
+}
+
+// Collector collector that retrieve load averages, run queue and pressure stall information from procfs
+type Collector struct {
+	procRoot     string
+	out          io.Writer // Diagnostics
+	psiAvailable bool
+	stalls       utils.RateAggregator
+}
+
+// NewCollector Create a new load collector reading procfs at the given root. Diagnostics are printed to out
+func NewCollector(procRoot string, out io.Writer) *Collector {
+	collector := Collector{
+		procRoot: procRoot,
+		out:      out,
+	}
+	collector.psiAvailable = collector.detectPSI()
+	if !collector.psiAvailable {
+		fmt.Fprintln(out, "Pressure Stall Information is not available on this kernel")
+	}
+	return &collector
+}
+
+// PSIAvailable returns true if the kernel exposes Pressure Stall Information
+func (collector *Collector) PSIAvailable() bool {
+	return collector.psiAvailable
+}
+
+func (collector *Collector) detectPSI() bool {
+	// When the kernel is booted with psi=0 the files exist but can't be read
+	_, err := ioutil.ReadFile(filepath.Join(collector.procRoot, "pressure", "cpu"))
+	return err == nil
+}
+
+// GetStats Get load stats
+func (collector *Collector) GetStats() (*Stats, error) {
+	stats := Stats{}
+
+	loadavg, err := ioutil.ReadFile(filepath.Join(collector.procRoot, "loadavg"))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(loadavg))
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("Unexpected loadavg format: %s", loadavg)
+	}
+	if stats.Load1, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return nil, err
+	}
+	if stats.Load5, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return nil, err
+	}
+	if stats.Load15, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return nil, err
+	}
+
+	if err = collector.readProcs(&stats); err != nil {
+		return nil, err
+	}
+
+	if collector.psiAvailable {
+		now := time.Now()
+		for _, resource := range PressureResources {
+			pressure, err := collector.readPressure(resource, now)
+			if err != nil {
+				fmt.Fprintln(collector.out, "Error while reading pressure stall information", err)
+				continue
+			}
+			stats.Pressure = append(stats.Pressure, pressure...)
+		}
+	}
+	return &stats, nil
+}
+
+func (collector *Collector) readProcs(stats *Stats) error {
+	file, err := os.Open(filepath.Join(collector.procRoot, "stat"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "procs_running":
+			stats.ProcsRunning, _ = strconv.ParseUint(fields[1], 10, 64)
+		case "procs_blocked":
+			stats.ProcsBlocked, _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return scanner.Err()
+}
+
+func (collector *Collector) readPressure(resource string, now time.Time) ([]PressureStats, error) {
+	content, err := ioutil.ReadFile(filepath.Join(collector.procRoot, "pressure", resource))
+	if err != nil {
+		return nil, err
+	}
+
+	pressures, err := ParsePressure(resource, string(content))
+	if err != nil {
+		return nil, err
+	}
+	for i := range pressures {
+		key := resource + "/" + pressures[i].Kind
+		// Total is in microseconds so the rate is in microseconds stalled per second
+		if rate, ok := collector.stalls.UpdateRate(key, pressures[i].Total, now); ok {
+			pressures[i].StallPercent = rate / 1e4
+		}
+	}
+	return pressures, nil
+}
+
+// ParsePressure parse the content of a /proc/pressure/<resource> file
+func ParsePressure(resource string, content string) ([]PressureStats, error) {
+	var pressures []PressureStats
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		pressure := PressureStats{Resource: resource, Kind: fields[0]}
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Unexpected pressure format: %s", line)
+			}
+			var err error
+			switch parts[0] {
+			case "avg10":
+				pressure.Avg10, err = strconv.ParseFloat(parts[1], 64)
+			case "avg60":
+				pressure.Avg60, err = strconv.ParseFloat(parts[1], 64)
+			case "avg300":
+				pressure.Avg300, err = strconv.ParseFloat(parts[1], 64)
+			case "total":
+				pressure.Total, err = strconv.ParseUint(parts[1], 10, 64)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		pressures = append(pressures, pressure)
+	}
+	return pressures, nil
+}
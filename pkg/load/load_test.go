@@ -0,0 +1,94 @@
+package load_test
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/load"
+	"github.com/Azure/batch-insights/pkg/testfixture"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStats(t *testing.T) {
+	collector := load.NewCollector("testdata/proc", ioutil.Discard)
+	assert.True(t, collector.PSIAvailable())
+
+	stats, err := collector.GetStats()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1.52, stats.Load1)
+	assert.Equal(t, 0.98, stats.Load5)
+	assert.Equal(t, 0.45, stats.Load15)
+	assert.Equal(t, uint64(3), stats.ProcsRunning)
+	assert.Equal(t, uint64(1), stats.ProcsBlocked)
+	assert.Equal(t, 6, len(stats.Pressure))
+
+	io := stats.Pressure[4]
+	assert.Equal(t, "io", io.Resource)
+	assert.Equal(t, "some", io.Kind)
+	assert.Equal(t, 12.5, io.Avg10)
+	assert.Equal(t, 8.25, io.Avg60)
+	assert.Equal(t, 3.1, io.Avg300)
+	assert.Equal(t, uint64(45678901), io.Total)
+}
+
+func TestGetStatsWithoutPSI(t *testing.T) {
+	collector := load.NewCollector("testdata/proc-nopsi", ioutil.Discard)
+	assert.False(t, collector.PSIAvailable())
+
+	stats, err := collector.GetStats()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1.52, stats.Load1)
+	assert.Equal(t, uint64(3), stats.ProcsRunning)
+	assert.Equal(t, 0, len(stats.Pressure))
+}
+
+func TestGetStatsStallPercent(t *testing.T) {
+	f := testfixture.New(t)
+	defer f.Close()
+	f.Copy("loadavg", "testdata/proc/loadavg")
+	f.Copy("stat", "testdata/proc/stat")
+	for _, resource := range load.PressureResources {
+		f.Write("pressure/"+resource, "some avg10=0.00 avg60=0.00 avg300=0.00 total=1000000\n")
+	}
+	collector := load.NewCollector(f.Root, ioutil.Discard)
+
+	start := time.Now()
+	stats, err := collector.GetStats()
+	firstDone := time.Now()
+	assert.Nil(t, err)
+	// The stall time needs two samples
+	assert.Equal(t, 0.0, stats.Pressure[0].StallPercent)
+
+	time.Sleep(10 * time.Millisecond)
+	// 5ms stalled
+	f.Write("pressure/io", "some avg10=0.00 avg60=0.00 avg300=0.00 total=1005000\n")
+	secondStart := time.Now()
+	stats, err = collector.GetStats()
+	end := time.Now()
+	assert.Nil(t, err)
+
+	minElapsed, maxElapsed := secondStart.Sub(firstDone).Seconds(), end.Sub(start).Seconds()
+	for _, pressure := range stats.Pressure {
+		if pressure.Resource != "io" {
+			assert.Equal(t, 0.0, pressure.StallPercent)
+			continue
+		}
+		// Percent of the elapsed time
+		assert.True(t, pressure.StallPercent >= 0.5/maxElapsed && pressure.StallPercent <= 0.5/minElapsed, "%v not in [%v, %v]", pressure.StallPercent, 0.5/maxElapsed, 0.5/minElapsed)
+	}
+}
+
+func TestParsePressure(t *testing.T) {
+	pressures, err := load.ParsePressure("cpu", "some avg10=1.00 avg60=2.00 avg300=3.00 total=42\n")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []load.PressureStats{
+		{Resource: "cpu", Kind: "some", Avg10: 1, Avg60: 2, Avg300: 3, Total: 42},
+	}, pressures)
+
+	_, err = load.ParsePressure("cpu", "some avg10")
+	assert.NotNil(t, err)
+}
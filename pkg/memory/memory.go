@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PagingStats swap and page fault activity of the node
+type PagingStats struct {
+	SwapInBps             uint64  // Bytes swapped in per second
+	SwapOutBps            uint64  // Bytes swapped out per second
+	PageFaultsPerSec      float64 // Minor and major page faults per second
+	MajorPageFaultsPerSec float64 // Page faults that required a disk access per second
+}
+
+// ParseVMStat parse the content of /proc/vmstat into a map of counter name to value
+func ParseVMStat(reader io.Reader) (map[string]uint64, error) {
+	counters := make(map[string]uint64)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[fields[0]] = value
+	}
+	return counters, scanner.Err()
+}
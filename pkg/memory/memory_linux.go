@@ -0,0 +1,47 @@
+// +build linux
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/utils"
+)
+
+var paging = utils.RateAggregator{}
+
+// Paging Retrieve the swap and page fault rates from vmstat under the given procfs root
+// Returns nil stats until two samples were read
+func Paging(ctx context.Context, procRoot string) (*PagingStats, error) {
+	file, err := os.Open(filepath.Join(procRoot, "vmstat"))
+	if err != nil {
+		return nil, fmt.Errorf("Error while retrieving paging stats: %v", err)
+	}
+	defer file.Close()
+
+	counters, err := ParseVMStat(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error while retrieving paging stats: %v", err)
+	}
+
+	now := time.Now()
+	pageSize := float64(os.Getpagesize())
+	swapIn, ok := paging.UpdateRate("pswpin", counters["pswpin"], now)
+	swapOut, _ := paging.UpdateRate("pswpout", counters["pswpout"], now)
+	faults, _ := paging.UpdateRate("pgfault", counters["pgfault"], now)
+	majorFaults, _ := paging.UpdateRate("pgmajfault", counters["pgmajfault"], now)
+	if !ok {
+		return nil, nil
+	}
+
+	return &PagingStats{
+		SwapInBps:             uint64(swapIn * pageSize),
+		SwapOutBps:            uint64(swapOut * pageSize),
+		PageFaultsPerSec:      faults,
+		MajorPageFaultsPerSec: majorFaults,
+	}, nil
+}
@@ -0,0 +1,42 @@
+// +build linux
+
+package memory_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaging(t *testing.T) {
+	start := time.Now()
+	stats, err := memory.Paging(context.Background(), "testdata/proc")
+	firstDone := time.Now()
+	assert.Nil(t, err)
+	// Rates need two samples
+	assert.Nil(t, stats)
+
+	time.Sleep(10 * time.Millisecond)
+	secondStart := time.Now()
+	stats, err = memory.Paging(context.Background(), "testdata/proc-later")
+	end := time.Now()
+	assert.Nil(t, err)
+
+	minElapsed, maxElapsed := secondStart.Sub(firstDone).Seconds(), end.Sub(start).Seconds()
+	assertRate := func(delta float64, rate float64) {
+		assert.True(t, rate >= delta/maxElapsed && rate <= delta/minElapsed, "%v not in [%v, %v]", rate, delta/maxElapsed, delta/minElapsed)
+	}
+	assertRate(500, stats.PageFaultsPerSec)
+	assertRate(20, stats.MajorPageFaultsPerSec)
+	// Pages, truncated to whole bytes per second
+	pageSize := float64(os.Getpagesize())
+	assert.True(t, float64(stats.SwapInBps) >= 4*pageSize/maxElapsed-1 && float64(stats.SwapInBps) <= 4*pageSize/minElapsed)
+	assert.True(t, float64(stats.SwapOutBps) >= 8*pageSize/maxElapsed-1 && float64(stats.SwapOutBps) <= 8*pageSize/minElapsed)
+
+	_, err = memory.Paging(context.Background(), "testdata/missing")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,30 @@
+package memory_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/batch-insights/pkg/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+const vmstat = `nr_free_pages 1983623
+nr_dirty 26
+pgfault 1097454113
+pgmajfault 6011
+pswpin 12
+pswpout 48
+oom_kill 2
+`
+
+func TestParseVMStat(t *testing.T) {
+	counters, err := memory.ParseVMStat(strings.NewReader(vmstat))
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1097454113), counters["pgfault"])
+	assert.Equal(t, uint64(6011), counters["pgmajfault"])
+	assert.Equal(t, uint64(12), counters["pswpin"])
+	assert.Equal(t, uint64(48), counters["pswpout"])
+	assert.Equal(t, uint64(2), counters["oom_kill"])
+	assert.Equal(t, 7, len(counters))
+}
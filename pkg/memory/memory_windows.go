@@ -0,0 +1,53 @@
+// +build windows
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/utils"
+	"github.com/Azure/batch-insights/pkg/wmi"
+)
+
+type win32_PerfRawData_PerfOS_Memory struct {
+	PageFaultsPersec  uint32
+	PageReadsPersec   uint32
+	PagesInputPersec  uint32
+	PagesOutputPersec uint32
+}
+
+var paging = utils.RateAggregator{}
+
+// Paging Retrieve the paging and page fault rates from the memory performance counters. procRoot is not used on windows
+func Paging(ctx context.Context, procRoot string) (*PagingStats, error) {
+	var ret []win32_PerfRawData_PerfOS_Memory
+
+	q := wmi.CreateQuery(&ret, "")
+	err := wmi.QueryWithContext(ctx, q, &ret)
+	if err != nil {
+		return nil, fmt.Errorf("Error while retrieving paging stats: %v", err)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("Error while retrieving paging stats: no memory performance counters")
+	}
+
+	now := time.Now()
+	pageSize := float64(os.Getpagesize())
+	swapIn, ok := paging.UpdateRate("pagesInput", uint64(ret[0].PagesInputPersec), now)
+	swapOut, _ := paging.UpdateRate("pagesOutput", uint64(ret[0].PagesOutputPersec), now)
+	faults, _ := paging.UpdateRate("pageFaults", uint64(ret[0].PageFaultsPersec), now)
+	majorFaults, _ := paging.UpdateRate("pageReads", uint64(ret[0].PageReadsPersec), now)
+	if !ok {
+		return nil, nil
+	}
+
+	return &PagingStats{
+		SwapInBps:             uint64(swapIn * pageSize),
+		SwapOutBps:            uint64(swapOut * pageSize),
+		PageFaultsPerSec:      faults,
+		MajorPageFaultsPerSec: majorFaults,
+	}, nil
+}
@@ -0,0 +1,187 @@
+package netstat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/utils"
+)
+
+// TCPStates name of the TCP states in the order of their code in /proc/net/tcp
+var TCPStates = []string{
+	"ESTABLISHED",
+	"SYN_SENT",
+	"SYN_RECV",
+	"FIN_WAIT1",
+	"FIN_WAIT2",
+	"TIME_WAIT",
+	"CLOSE",
+	"CLOSE_WAIT",
+	"LAST_ACK",
+	"LISTEN",
+	"CLOSING",
+}
+
+// Stats socket and protocol statistics of the node
+type Stats struct {
+	TCPConnections               []TCPStateCount // Number of IPv4 and IPv6 TCP sockets in each state
+	TCPRetransmitsPerSec         float64
+	TCPRetransmitPercent         float64 // Percent of the segments sent which were retransmissions
+	TCPResetsPerSec              float64 // Resets sent
+	TCPEstablishedResetsPerSec   float64 // Established connections reset
+	TCPAttemptFailsPerSec        float64 // Connection attempts which failed
+	TCPListenOverflowsPerSec     float64 // Connections dropped because the accept queue was full
+	UDPReceiveErrorsPerSec       float64
+	UDPReceiveBufferErrorsPerSec float64 // Datagrams dropped because the socket receive buffer was full
+	UDPNoPortsPerSec             float64 // Datagrams received on a port nobody listens on
+}
+
+// TCPStateCount number of TCP sockets in a given state
+type TCPStateCount struct {
+	State string
+	Count uint64
+}
+
+// Listening number of listening TCP sockets
+func (stats Stats) Listening() uint64 {
+	for _, connections := range stats.TCPConnections {
+		if connections.State == "LISTEN" {
+			return connections.Count
+		}
+	}
+	return 0
+}
+
+// Collector collector that retrieve the socket states and protocol counters from procfs
+type Collector struct {
+	procRoot string
+	rates    utils.RateAggregator
+}
+
+// NewCollector Create a new socket collector reading procfs at the given root
+func NewCollector(procRoot string) *Collector {
+	return &Collector{procRoot: procRoot}
+}
+
+// GetStats Get the socket and protocol stats
+func (collector *Collector) GetStats() (*Stats, error) {
+	stats := Stats{}
+
+	counts := make([]uint64, len(TCPStates))
+	for _, name := range []string{"tcp", "tcp6"} {
+		if err := collector.countTCPStates(name, counts); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	for i, state := range TCPStates {
+		stats.TCPConnections = append(stats.TCPConnections, TCPStateCount{State: state, Count: counts[i]})
+	}
+
+	snmp, err := collector.readCounters("snmp")
+	if err != nil {
+		return nil, err
+	}
+	// Extended counters are optional
+	netstat, _ := collector.readCounters("netstat")
+
+	now := time.Now()
+	retransmits, ok := collector.rate("Tcp", "RetransSegs", snmp, now)
+	stats.TCPRetransmitsPerSec = retransmits
+	if sent, _ := collector.rate("Tcp", "OutSegs", snmp, now); ok && sent > 0 {
+		stats.TCPRetransmitPercent = retransmits / sent * 100
+	}
+	stats.TCPResetsPerSec, _ = collector.rate("Tcp", "OutRsts", snmp, now)
+	stats.TCPEstablishedResetsPerSec, _ = collector.rate("Tcp", "EstabResets", snmp, now)
+	stats.TCPAttemptFailsPerSec, _ = collector.rate("Tcp", "AttemptFails", snmp, now)
+	stats.TCPListenOverflowsPerSec, _ = collector.rate("TcpExt", "ListenOverflows", netstat, now)
+	stats.UDPReceiveErrorsPerSec, _ = collector.rate("Udp", "InErrors", snmp, now)
+	stats.UDPReceiveBufferErrorsPerSec, _ = collector.rate("Udp", "RcvbufErrors", snmp, now)
+	stats.UDPNoPortsPerSec, _ = collector.rate("Udp", "NoPorts", snmp, now)
+	return &stats, nil
+}
+
+func (collector *Collector) rate(protocol string, name string, counters map[string]map[string]uint64, now time.Time) (float64, bool) {
+	value, ok := counters[protocol][name]
+	if !ok {
+		return 0, false
+	}
+	return collector.rates.UpdateRate(protocol+"/"+name, value, now)
+}
+
+func (collector *Collector) countTCPStates(name string, counts []uint64) error {
+	file, err := os.Open(filepath.Join(collector.procRoot, "net", name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return CountTCPStates(file, counts)
+}
+
+func (collector *Collector) readCounters(name string) (map[string]map[string]uint64, error) {
+	file, err := os.Open(filepath.Join(collector.procRoot, "net", name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseCounters(file)
+}
+
+// CountTCPStates count the sockets of a /proc/net/tcp or /proc/net/tcp6 file by state. counts is indexed like TCPStates
+func CountTCPStates(reader io.Reader, counts []uint64) error {
+	scanner := bufio.NewScanner(reader)
+	// Skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return fmt.Errorf("Unexpected tcp socket state: %s", fields[3])
+		}
+		if state >= 1 && int(state) <= len(counts) {
+			counts[state-1]++
+		}
+	}
+	return scanner.Err()
+}
+
+// ParseCounters parse the content of /proc/net/snmp or /proc/net/netstat. Each protocol has a header line followed by a value line
+func ParseCounters(reader io.Reader) (map[string]map[string]uint64, error) {
+	counters := make(map[string]map[string]uint64)
+	var header []string
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if header == nil || header[0] != fields[0] {
+			header = fields
+			continue
+		}
+		if len(header) != len(fields) {
+			return nil, fmt.Errorf("Unexpected number of values for %s", fields[0])
+		}
+
+		protocol := strings.TrimSuffix(fields[0], ":")
+		counters[protocol] = make(map[string]uint64)
+		for i := 1; i < len(fields); i++ {
+			// Some values are signed, e.g. Tcp MaxConn is -1
+			if value, err := strconv.ParseUint(fields[i], 10, 64); err == nil {
+				counters[protocol][header[i]] = value
+			}
+		}
+		header = nil
+	}
+	return counters, scanner.Err()
+}
@@ -0,0 +1,84 @@
+package netstat_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/netstat"
+	"github.com/Azure/batch-insights/pkg/testfixture"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCounters(t *testing.T) {
+	file, err := os.Open("testdata/proc/net/snmp")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	counters, err := netstat.ParseCounters(file)
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(43500), counters["Tcp"]["RetransSegs"])
+	assert.Equal(t, uint64(9876), counters["Tcp"]["OutRsts"])
+	assert.Equal(t, uint64(34), counters["Udp"]["InErrors"])
+	// Negative values are skipped
+	_, ok := counters["Tcp"]["MaxConn"]
+	assert.False(t, ok)
+}
+
+func TestGetStats(t *testing.T) {
+	f := testfixture.New(t)
+	defer f.Close()
+	var snmp string
+	for _, name := range []string{"netstat", "snmp", "tcp", "tcp6"} {
+		content := f.Copy("net/"+name, "testdata/proc/net/"+name)
+		if name == "snmp" {
+			snmp = content
+		}
+	}
+	collector := netstat.NewCollector(f.Root)
+
+	start := time.Now()
+	stats, err := collector.GetStats()
+	firstDone := time.Now()
+
+	assert.Nil(t, err)
+	assert.Equal(t, len(netstat.TCPStates), len(stats.TCPConnections))
+	assert.Equal(t, netstat.TCPStateCount{State: "ESTABLISHED", Count: 3}, stats.TCPConnections[0])
+	assert.Equal(t, netstat.TCPStateCount{State: "TIME_WAIT", Count: 2}, stats.TCPConnections[5])
+	assert.Equal(t, netstat.TCPStateCount{State: "CLOSE_WAIT", Count: 1}, stats.TCPConnections[7])
+	assert.Equal(t, uint64(3), stats.Listening())
+	// Rates need a second sample
+	assert.Equal(t, 0.0, stats.TCPRetransmitsPerSec)
+
+	// 10000 segments sent, 500 of them retransmitted, 100 resets sent, 50 failed connection attempts,
+	// 20 UDP receive errors, 10 of them because the receive buffer was full
+	time.Sleep(100 * time.Millisecond)
+	updated := strings.Replace(snmp, "Tcp: 1 200 120000 -1 123456 7890 321 654 42 98000000 87000000 43500 3 9876 0", "Tcp: 1 200 120000 -1 123456 7890 371 654 42 98000000 87010000 44000 3 9976 0", 1)
+	updated = strings.Replace(updated, "Udp: 56789 12 34 56000 30 0 4 0", "Udp: 56789 12 54 56000 40 0 4 0", 1)
+	assert.NotEqual(t, snmp, updated)
+	f.Write("net/snmp", updated)
+
+	secondStart := time.Now()
+	stats, err = collector.GetStats()
+	end := time.Now()
+
+	assert.Nil(t, err)
+	assert.InDelta(t, 5.0, stats.TCPRetransmitPercent, 1e-9)
+	minElapsed, maxElapsed := secondStart.Sub(firstDone).Seconds(), end.Sub(start).Seconds()
+	assertRate := func(delta float64, rate float64) {
+		assert.True(t, rate >= delta/maxElapsed && rate <= delta/minElapsed, "%v not in [%v, %v]", rate, delta/maxElapsed, delta/minElapsed)
+	}
+	assertRate(500, stats.TCPRetransmitsPerSec)
+	assertRate(100, stats.TCPResetsPerSec)
+	assertRate(50, stats.TCPAttemptFailsPerSec)
+	assertRate(20, stats.UDPReceiveErrorsPerSec)
+	assertRate(10, stats.UDPReceiveBufferErrorsPerSec)
+	// Every rate is computed over the same interval
+	assert.InDelta(t, 5.0, stats.TCPRetransmitsPerSec/stats.TCPResetsPerSec, 1e-9)
+	assert.InDelta(t, 2.0, stats.UDPReceiveErrorsPerSec/stats.UDPReceiveBufferErrorsPerSec, 1e-9)
+	assert.Equal(t, 0.0, stats.TCPEstablishedResetsPerSec)
+	assert.Equal(t, 0.0, stats.UDPNoPortsPerSec)
+	assert.Equal(t, 0.0, stats.TCPListenOverflowsPerSec)
+}
@@ -0,0 +1,257 @@
+package nfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/utils"
+)
+
+// Mount raw counters of an NFS mount as found in mountstats
+type Mount struct {
+	Device     string // e.g. 10.0.0.4:/export
+	MountPoint string
+	FSType     string
+	ReadBytes  uint64 // Bytes read from the server
+	WriteBytes uint64 // Bytes written to the server
+	Operations map[string]Operation
+}
+
+// Operation cumulative counters of a single NFS operation(READ, WRITE, GETATTR...)
+type Operation struct {
+	Ops           uint64
+	Transmissions uint64
+	MajorTimeouts uint64
+	BytesSent     uint64
+	BytesReceived uint64
+	QueueMs       uint64 // Cumulative time spent queued before being transmitted
+	RTTMs         uint64 // Cumulative time between the transmission and the reply
+	ExecuteMs     uint64 // Cumulative time from the submission to the completion
+}
+
+// Stats activity of an NFS mount since the last sample
+type Stats struct {
+	Device          string
+	MountPoint      string
+	ReadBps         uint64
+	WriteBps        uint64
+	OpsPerSec       float64
+	Retransmissions uint64 // Number of retransmitted requests since the last sample
+	Operations      []OperationStats
+}
+
+// OperationStats activity of a single NFS operation since the last sample. Only operations which were called are reported
+type OperationStats struct {
+	Name      string
+	OpsPerSec float64
+	RTTMs     float64 // Average round trip time per operation
+	ExecuteMs float64 // Average execution time per operation, including the queue time
+}
+
+// Collector collector that retrieve the NFS client statistics from mountstats
+type Collector struct {
+	procRoot        string
+	rates           utils.RateAggregator
+	operations      map[string]Operation
+	retransmissions map[string]uint64
+}
+
+// NewCollector Create a new NFS collector reading procfs at the given root
+func NewCollector(procRoot string) *Collector {
+	return &Collector{
+		procRoot:        procRoot,
+		operations:      make(map[string]Operation),
+		retransmissions: make(map[string]uint64),
+	}
+}
+
+// GetStats Get the activity of every NFS mount
+func (collector *Collector) GetStats() ([]Stats, error) {
+	file, err := os.Open(filepath.Join(collector.procRoot, "self", "mountstats"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts, err := ParseMountStats(file)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var result []Stats
+	seen := make(map[string]bool)
+	for _, mount := range mounts {
+		result = append(result, collector.computeStats(mount, now))
+		key := mountKey(mount)
+		seen[key] = true
+		for name := range mount.Operations {
+			seen[key+name] = true
+		}
+	}
+
+	collector.rates.Prune(now)
+	collector.prune(seen)
+	return result, nil
+}
+
+// prune forget the counters of the mounts which are gone. seen contains the keys of the mounts and of their operations
+func (collector *Collector) prune(seen map[string]bool) {
+	for key := range collector.operations {
+		if !seen[key] {
+			delete(collector.operations, key)
+		}
+	}
+	for key := range collector.retransmissions {
+		if !seen[key] {
+			delete(collector.retransmissions, key)
+		}
+	}
+}
+
+func mountKey(mount Mount) string {
+	return mount.Device + " " + mount.MountPoint + "/"
+}
+
+func (collector *Collector) computeStats(mount Mount, now time.Time) Stats {
+	stats := Stats{Device: mount.Device, MountPoint: mount.MountPoint}
+	key := mountKey(mount)
+
+	if rate, ok := collector.rates.UpdateRate(key+"readBytes", mount.ReadBytes, now); ok {
+		stats.ReadBps = uint64(rate)
+	}
+	if rate, ok := collector.rates.UpdateRate(key+"writeBytes", mount.WriteBytes, now); ok {
+		stats.WriteBps = uint64(rate)
+	}
+
+	var totalOps, retransmissions uint64
+	for _, name := range sortedOperationNames(mount.Operations) {
+		operation := mount.Operations[name]
+		totalOps += operation.Ops
+		if operation.Transmissions > operation.Ops {
+			retransmissions += operation.Transmissions - operation.Ops
+		}
+
+		opsPerSec, ok := collector.rates.UpdateRate(key+name, operation.Ops, now)
+		last, seen := collector.operations[key+name]
+		collector.operations[key+name] = operation
+		if !ok || !seen || operation.Ops <= last.Ops {
+			continue
+		}
+
+		ops := float64(operation.Ops - last.Ops)
+		stats.Operations = append(stats.Operations, OperationStats{
+			Name:      name,
+			OpsPerSec: opsPerSec,
+			RTTMs:     float64(operation.RTTMs-last.RTTMs) / ops,
+			ExecuteMs: float64(operation.ExecuteMs-last.ExecuteMs) / ops,
+		})
+	}
+
+	stats.OpsPerSec, _ = collector.rates.UpdateRate(key+"ops", totalOps, now)
+	if last, ok := collector.retransmissions[key]; ok && retransmissions > last {
+		stats.Retransmissions = retransmissions - last
+	}
+	collector.retransmissions[key] = retransmissions
+	return stats
+}
+
+func sortedOperationNames(operations map[string]Operation) []string {
+	var names []string
+	for name := range operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseMountStats parse the content of /proc/self/mountstats and return the NFS mounts
+func ParseMountStats(reader io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	var current *Mount
+	inOperations := false
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// device 10.0.0.4:/export mounted on /mnt/data with fstype nfs4 statvers=1.1
+		if fields[0] == "device" {
+			if current != nil {
+				mounts = append(mounts, *current)
+				current = nil
+			}
+			inOperations = false
+			if len(fields) >= 8 && fields[2] == "mounted" && strings.HasPrefix(fields[7], "nfs") {
+				current = &Mount{
+					Device:     fields[1],
+					MountPoint: fields[4],
+					FSType:     fields[7],
+					Operations: make(map[string]Operation),
+				}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		switch {
+		case fields[0] == "bytes:":
+			// normalread normalwrite directread directwrite serverread serverwrite readpages writepages
+			if len(fields) < 7 {
+				return nil, fmt.Errorf("Unexpected mountstats bytes format: %s", line)
+			}
+			current.ReadBytes, _ = strconv.ParseUint(fields[5], 10, 64)
+			current.WriteBytes, _ = strconv.ParseUint(fields[6], 10, 64)
+		case fields[0] == "per-op":
+			inOperations = true
+		case inOperations && strings.HasSuffix(fields[0], ":"):
+			operation, err := parseOperation(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("Unexpected mountstats operation format: %s", line)
+			}
+			current.Operations[strings.TrimSuffix(fields[0], ":")] = operation
+		}
+	}
+	if current != nil {
+		mounts = append(mounts, *current)
+	}
+	return mounts, scanner.Err()
+}
+
+// parseOperation parse the counters of an operation. Newer kernels append an error count which is ignored
+func parseOperation(fields []string) (Operation, error) {
+	if len(fields) < 8 {
+		return Operation{}, fmt.Errorf("expected 8 counters, got %d", len(fields))
+	}
+	var values [8]uint64
+	for i := range values {
+		value, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return Operation{}, err
+		}
+		values[i] = value
+	}
+	return Operation{
+		Ops:           values[0],
+		Transmissions: values[1],
+		MajorTimeouts: values[2],
+		BytesSent:     values[3],
+		BytesReceived: values[4],
+		QueueMs:       values[5],
+		RTTMs:         values[6],
+		ExecuteMs:     values[7],
+	}, nil
+}
@@ -0,0 +1,78 @@
+package nfs_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Azure/batch-insights/pkg/nfs"
+	"github.com/Azure/batch-insights/pkg/testfixture"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMountStats(t *testing.T) {
+	file, err := os.Open("testdata/mountstats")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	mounts, err := nfs.ParseMountStats(file)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(mounts))
+	assert.Equal(t, "10.0.0.4:/volume1", mounts[0].Device)
+	assert.Equal(t, "/mnt/anf", mounts[0].MountPoint)
+	assert.Equal(t, "nfs4", mounts[0].FSType)
+	assert.Equal(t, uint64(1073741824), mounts[0].ReadBytes)
+	assert.Equal(t, uint64(536870912), mounts[0].WriteBytes)
+	assert.Equal(t, 4, len(mounts[0].Operations))
+	assert.Equal(t, nfs.Operation{
+		Ops:           4096,
+		Transmissions: 4098,
+		BytesSent:     602112,
+		BytesReceived: 1074266112,
+		QueueMs:       40,
+		RTTMs:         8192,
+		ExecuteMs:     12288,
+	}, mounts[0].Operations["READ"])
+
+	// Older kernels don't report the errors
+	assert.Equal(t, "nfs", mounts[1].FSType)
+	assert.Equal(t, uint64(2), mounts[1].Operations["GETATTR"].Ops)
+}
+
+func TestGetStats(t *testing.T) {
+	f := testfixture.New(t)
+	defer f.Close()
+	content := f.Copy("self/mountstats", "testdata/mountstats")
+
+	collector := nfs.NewCollector(f.Root)
+	stats, err := collector.GetStats()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, 0, len(stats[0].Operations))
+
+	// 100 more reads taking 2ms RTT and 3ms in total each, 4 of them retransmitted
+	updated := strings.Replace(content, "READ: 4096 4098 0 602112 1074266112 40 8192 12288 0", "READ: 4196 4202 0 602112 1074266112 40 8392 12588 0", 1)
+	f.Write("self/mountstats", updated)
+	stats, err = collector.GetStats()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/mnt/anf", stats[0].MountPoint)
+	assert.Equal(t, uint64(4), stats[0].Retransmissions)
+	assert.Equal(t, 1, len(stats[0].Operations))
+	assert.Equal(t, "READ", stats[0].Operations[0].Name)
+	assert.Equal(t, 2.0, stats[0].Operations[0].RTTMs)
+	assert.Equal(t, 3.0, stats[0].Operations[0].ExecuteMs)
+	assert.Equal(t, 0, len(stats[1].Operations))
+
+	// The counters of an unmounted share are forgotten, the next mount starts over
+	f.Write("self/mountstats", "")
+	stats, err = collector.GetStats()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(stats))
+	f.Write("self/mountstats", strings.Replace(updated, "READ: 4196 4202", "READ: 4296 4310", 1))
+	stats, err = collector.GetStats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), stats[0].Retransmissions)
+	assert.Equal(t, 0, len(stats[0].Operations))
+}
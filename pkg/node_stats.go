@@ -1,27 +1,78 @@
 package batchinsights
 
 import (
+	"encoding/json"
+
 	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/mem"
 
+	"github.com/Azure/batch-insights/pkg/cgroup"
+	"github.com/Azure/batch-insights/pkg/cpu"
+	"github.com/Azure/batch-insights/pkg/events"
+	"github.com/Azure/batch-insights/pkg/infiniband"
+	"github.com/Azure/batch-insights/pkg/load"
+	"github.com/Azure/batch-insights/pkg/memory"
+	"github.com/Azure/batch-insights/pkg/netstat"
+	"github.com/Azure/batch-insights/pkg/nfs"
 	"github.com/Azure/batch-insights/pkg/utils"
 )
 
 // ProcessPerfInfo Process specific information
 type ProcessPerfInfo struct {
-	pid    int32
-	name   string
-	cpu    float64
-	memory uint64
+	pid       int32
+	name      string
+	cpu       float64
+	memory    uint64
+	gpuMemory uint64  // GPU memory used by the process on all the GPUs
+	gpuUsage  float64 // Sum of the SM utilization of the process on all the GPUs
+}
+
+// processPerfInfoJSON JSON representation of ProcessPerfInfo, used by the HTTP API and the recordings
+type processPerfInfoJSON struct {
+	PID       int32
+	Name      string
+	CPU       float64
+	Memory    uint64
+	GPUMemory uint64
+	GPUUsage  float64
+}
+
+// MarshalJSON encode the process info
+func (info ProcessPerfInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(processPerfInfoJSON{info.pid, info.name, info.cpu, info.memory, info.gpuMemory, info.gpuUsage})
+}
+
+// UnmarshalJSON decode the process info
+func (info *ProcessPerfInfo) UnmarshalJSON(data []byte) error {
+	var value processPerfInfoJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*info = ProcessPerfInfo{value.PID, value.Name, value.CPU, value.Memory, value.GPUMemory, value.GPUUsage}
+	return nil
 }
 
 // NodeStats Combined model for all metrics being collected at the given interal
 type NodeStats struct {
-	Memory      *mem.VirtualMemoryStat
-	CPUPercents []float64
-	DiskUsage   []*disk.UsageStat
-	DiskIO      *utils.IOStats
-	NetIO       *utils.IOStats
-	Gpus        []GPUUsage
-	Processes   []*ProcessPerfInfo
+	Memory       *mem.VirtualMemoryStat
+	Swap         *mem.SwapMemoryStat
+	Paging       *memory.PagingStats
+	CPUPercents  []float64
+	CPUTimes     *cpu.TimesBreakdown
+	CPUFrequency *cpu.FrequencyStats
+	Load         *load.Stats
+	DiskUsage    []*disk.UsageStat
+	DiskIO       *utils.IOStats
+	NetIO        *utils.IOStats
+	Netstat      *netstat.Stats
+	Gpus         []GPUUsage
+	GPUHealth    []GPUHealth
+	Processes    []*ProcessPerfInfo
+	Containers   []cgroup.Stats
+	InfiniBand   []infiniband.Stats
+	NFS          []nfs.Stats
+	Events       []events.Event
+	Agent        *AgentStats
+	Idle         *IdleStats        // Not set by the collectors
+	Collectors   []CollectorStatus // Not set by the collectors
 }
@@ -1,6 +1,7 @@
 package batchinsights
 
 import (
+	"context"
 	"strings"
 
 	"github.com/shirou/gopsutil/process"
@@ -20,19 +21,23 @@ func containsCaseInsensitive(xs []string, str string) bool {
 }
 
 // ListProcesses Retrieve process cpu, memory, etc usage for the given list of process names
-func ListProcesses(processNames []string) ([]*ProcessPerfInfo, error) {
-	pids, err := process.Pids()
+func ListProcesses(ctx context.Context, processNames []string) ([]*ProcessPerfInfo, error) {
+	pids, err := process.PidsWithContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	ps := []*ProcessPerfInfo{}
 	for _, pid := range pids {
+		// Timed out, the processes listed so far are returned
+		if ctx.Err() != nil {
+			return ps, ctx.Err()
+		}
 
 		// if err != nil, process has probably disappeared, continue on
 		if p, err := process.NewProcess(pid); err == nil {
 
-			name, err := p.Name()
+			name, err := p.NameWithContext(ctx)
 			if err != nil {
 				// process might have disappeared
 				continue
@@ -43,13 +48,13 @@ func ListProcesses(processNames []string) ([]*ProcessPerfInfo, error) {
 				continue
 			}
 
-			cpuPercent, err := p.CPUPercent()
+			cpuPercent, err := p.CPUPercentWithContext(ctx)
 			if err != nil {
 				// process might have disappeared
 				continue
 			}
 
-			memoryInfoStat, err := p.MemoryInfo()
+			memoryInfoStat, err := p.MemoryInfoWithContext(ctx)
 			if err != nil {
 				// process might have disappeared
 				continue
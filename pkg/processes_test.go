@@ -0,0 +1,19 @@
+package batchinsights_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListProcessesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	processes, err := batchinsights.ListProcesses(ctx, []string{"python"})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, len(processes))
+}
@@ -0,0 +1,88 @@
+package batchinsights
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Recorder write the raw samples of the collectors to a file, one JSON sample per line
+type Recorder struct {
+	file    *os.File
+	encoder *json.Encoder
+	lock    sync.Mutex
+}
+
+// NewRecorder Create a recorder appending the samples to the given file
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Record write a sample collected at the given time
+func (recorder *Recorder) Record(now time.Time, stats NodeStats) error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	return recorder.encoder.Encode(Sample{Time: now, Stats: stats})
+}
+
+// Close close the file
+func (recorder *Recorder) Close() error {
+	return recorder.file.Close()
+}
+
+// Replayer read recorded samples and hand them over at the pace they were recorded
+type Replayer struct {
+	decoder  *json.Decoder
+	speed    float64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReplayer Create a replayer reading the samples written by a Recorder. A speed of 1 replays in real time,
+// 10 ten times faster and 0 as fast as possible
+func NewReplayer(reader io.Reader, speed float64) *Replayer {
+	return &Replayer{decoder: json.NewDecoder(reader), speed: speed, stop: make(chan struct{})}
+}
+
+// Stop end the replay before the next sample
+func (replayer *Replayer) Stop() {
+	replayer.stopOnce.Do(func() {
+		close(replayer.stop)
+	})
+}
+
+// Run call handle with every sample and its recorded time until the end of the recording or Stop.
+// Returns the number of samples replayed
+func (replayer *Replayer) Run(handle func(now time.Time, stats NodeStats)) (int, error) {
+	count := 0
+	var previous time.Time
+	for {
+		select {
+		case <-replayer.stop:
+			return count, nil
+		default:
+		}
+		var sample Sample
+		if err := replayer.decoder.Decode(&sample); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+		if replayer.speed > 0 && !previous.IsZero() && sample.Time.After(previous) {
+			select {
+			case <-time.After(time.Duration(float64(sample.Time.Sub(previous)) / replayer.speed)):
+			case <-replayer.stop:
+				return count, nil
+			}
+		}
+		previous = sample.Time
+		handle(sample.Time, sample.Stats)
+		count++
+	}
+}
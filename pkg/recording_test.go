@@ -0,0 +1,86 @@
+package batchinsights_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/Azure/batch-insights/pkg/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recording.jsonl")
+
+	recorder, err := batchinsights.NewRecorder(path)
+	assert.Nil(t, err)
+	start := time.Unix(1700000000, 0).UTC()
+	event := events.Event{Time: start, Type: events.OOMKill, Message: "Killed process 42 (python)", Properties: map[string]string{"PID": "42"}}
+	assert.Nil(t, recorder.Record(start, batchinsights.NodeStats{CPUPercents: []float64{12.5}, Events: []events.Event{event}}))
+	assert.Nil(t, recorder.Record(start.Add(5*time.Second), batchinsights.NodeStats{CPUPercents: []float64{50}}))
+	assert.Nil(t, recorder.Close())
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	var samples []batchinsights.Sample
+	count, err := batchinsights.NewReplayer(file, 0).Run(func(now time.Time, stats batchinsights.NodeStats) {
+		samples = append(samples, batchinsights.Sample{Time: now, Stats: stats})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, start, samples[0].Time.UTC())
+	assert.Equal(t, []float64{12.5}, samples[0].Stats.CPUPercents)
+	assert.Equal(t, events.OOMKill, samples[0].Stats.Events[0].Type)
+	assert.Equal(t, 5*time.Second, samples[1].Time.Sub(samples[0].Time))
+}
+
+func TestReplayerStop(t *testing.T) {
+	file, err := os.Open("testdata/recording.jsonl")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	replayer := batchinsights.NewReplayer(file, 0)
+	count, err := replayer.Run(func(now time.Time, stats batchinsights.NodeStats) {
+		replayer.Stop()
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestReplayFixture(t *testing.T) {
+	file, err := os.Open("testdata/recording.jsonl")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	var metrics [][]batchinsights.Metric
+	_, err = batchinsights.NewReplayer(file, 0).Run(func(now time.Time, stats batchinsights.NodeStats) {
+		metrics = append(metrics, batchinsights.FlattenStats(stats))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(metrics))
+
+	values := make(map[string]float64)
+	for _, metric := range metrics[0] {
+		values[metric.Name+metric.Properties["Process Name"]] = metric.Value
+	}
+	assert.Equal(t, 25.0, values["Memory used percent"])
+	assert.Equal(t, 55.5, values["Process CPUpython"])
+	assert.Equal(t, 80.0, values["Gpu usage"])
+	assert.Equal(t, 1.0, findMetric(metrics[1], "Collector timeouts").Value)
+}
+
+func findMetric(metrics []batchinsights.Metric, name string) batchinsights.Metric {
+	for _, metric := range metrics {
+		if metric.Name == name {
+			return metric
+		}
+	}
+	return batchinsights.Metric{}
+}
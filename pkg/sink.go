@@ -0,0 +1,104 @@
+package batchinsights
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Sink destination of the samples. e.g. Application Insights, console
+type Sink interface {
+	Name() string
+	// Write hand a sample taken at the given time to the sink. Sinks may buffer and send it later
+	Write(now time.Time, stats NodeStats) error
+	// Stats delivery counters of the sink
+	Stats() SinkStats
+	// Close flush the pending items
+	Close()
+}
+
+// SinkStats delivery counters of a sink since batch insights started
+type SinkStats struct {
+	Name        string
+	Queued      uint64    // Items handed to the sink
+	Sent        uint64    // Items accepted by the destination
+	Failed      uint64    // Items which failed a transmission attempt. Retried items are counted on every attempt
+	Dropped     uint64    // Items discarded without being sent, e.g. while throttled
+	LastSuccess time.Time // Zero if nothing was sent yet
+	LastFailure time.Time // Zero if nothing failed yet
+	LastError   string
+}
+
+// Healthy returns true if the last transmission succeeded or nothing failed yet
+func (stats SinkStats) Healthy() bool {
+	return stats.LastFailure.IsZero() || stats.LastSuccess.After(stats.LastFailure)
+}
+
+func (stats *SinkStats) fail(message string) {
+	stats.LastError = message
+	stats.LastFailure = time.Now()
+}
+
+// Pending items queued but neither sent nor dropped yet
+func (stats SinkStats) Pending() uint64 {
+	done := stats.Sent + stats.Dropped
+	if done > stats.Queued {
+		return 0
+	}
+	return stats.Queued - done
+}
+
+// sinkCounters thread safe SinkStats, updated by the sinks
+type sinkCounters struct {
+	stats SinkStats
+	lock  sync.Mutex
+}
+
+func (counters *sinkCounters) update(update func(stats *SinkStats)) {
+	counters.lock.Lock()
+	defer counters.lock.Unlock()
+	update(&counters.stats)
+}
+
+func (counters *sinkCounters) get() SinkStats {
+	counters.lock.Lock()
+	defer counters.lock.Unlock()
+	return counters.stats
+}
+
+// ConsoleSink sink printing the samples to the console
+type ConsoleSink struct {
+	counters sinkCounters
+}
+
+// NewConsoleSink Create a sink printing the samples
+func NewConsoleSink() *ConsoleSink {
+	sink := ConsoleSink{}
+	sink.counters.stats.Name = sink.Name()
+	return &sink
+}
+
+// Name name of the sink
+func (sink *ConsoleSink) Name() string {
+	return "console"
+}
+
+// Write print the sample
+func (sink *ConsoleSink) Write(now time.Time, stats NodeStats) error {
+	printStats(os.Stdout, stats)
+	sink.counters.update(func(stats *SinkStats) {
+		stats.Queued++
+		stats.Sent++
+		stats.LastSuccess = time.Now()
+	})
+	return nil
+}
+
+// Stats delivery counters of the sink
+func (sink *ConsoleSink) Stats() SinkStats {
+	return sink.counters.get()
+}
+
+// Close nothing to flush
+func (sink *ConsoleSink) Close() {
+}
@@ -0,0 +1,192 @@
+package batchinsights
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultHTTPAddress address of the local HTTP API when only a port is given
+const DefaultHTTPAddress = "127.0.0.1"
+
+// StatusServer local HTTP API reporting whether batch insights is working: /healthz, /readyz and /status
+type StatusServer struct {
+	config       Config
+	sinks        []Sink
+	out          io.Writer // Diagnostics
+	tickInterval time.Duration
+	start        time.Time
+	mux          *http.ServeMux
+
+	lock       sync.RWMutex
+	lastSample time.Time
+	received   time.Time // When the last sample was handed over. Differs from the sample time during a replay
+	stats      NodeStats
+	collectors map[string]CollectorStatus
+}
+
+// Status content of the /status endpoint
+type Status struct {
+	Version    string
+	Uptime     string
+	Config     Config
+	LastSample *time.Time
+	Sample     *NodeStats `json:",omitempty"`
+	Sinks      []SinkStats
+	Collectors []CollectorStatus // Last status of every collector
+}
+
+// NewStatusServer Create the status API. tickInterval is the expected time between two samples.
+// Diagnostics are printed to out
+func NewStatusServer(config Config, sinks []Sink, tickInterval time.Duration, out io.Writer) *StatusServer {
+	server := StatusServer{
+		config:       config,
+		sinks:        sinks,
+		out:          out,
+		tickInterval: tickInterval,
+		start:        time.Now(),
+		mux:          http.NewServeMux(),
+		collectors:   make(map[string]CollectorStatus),
+	}
+	// The instrumentation key is a secret
+	server.config.InstrumentationKey = hideSecret(config.InstrumentationKey)
+
+	// 200 while the sampling loop is running
+	server.mux.HandleFunc("/healthz", server.handleHealth)
+	// 200 once a sample was taken and every sink could send its last items
+	server.mux.HandleFunc("/readyz", server.handleReady)
+	server.mux.HandleFunc("/status", server.handleStatus)
+	return &server
+}
+
+// Handler HTTP handler serving the API
+func (server *StatusServer) Handler() http.Handler {
+	return server.mux
+}
+
+// HandleFunc register an additional endpoint
+func (server *StatusServer) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	server.mux.HandleFunc(pattern, handler)
+}
+
+// ListenAndServe serve the API in the background
+func (server *StatusServer) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(server.out, "Serving the status API on http://%s\n", listener.Addr())
+	go func() {
+		err := http.Serve(listener, server.mux)
+		fmt.Fprintln(server.out, "Status API stopped", err)
+	}()
+	return nil
+}
+
+// Update record the last sample
+func (server *StatusServer) Update(stats NodeStats, now time.Time) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.lastSample = now
+	server.received = time.Now()
+	server.stats = stats
+	for _, status := range stats.Collectors {
+		server.collectors[status.Name] = status
+	}
+}
+
+// Healthy returns true if a sample was taken recently. Some ticks can be late while collectors time out
+func (server *StatusServer) Healthy(now time.Time) bool {
+	server.lock.RLock()
+	defer server.lock.RUnlock()
+	if server.received.IsZero() {
+		// Give the first sample time to complete
+		return now.Sub(server.start) < 3*server.tickInterval
+	}
+	return now.Sub(server.received) < 3*server.tickInterval
+}
+
+// Ready returns true once a sample was taken and every sink is healthy
+func (server *StatusServer) Ready() (bool, string) {
+	server.lock.RLock()
+	sampled := !server.lastSample.IsZero()
+	server.lock.RUnlock()
+	if !sampled {
+		return false, "no sample collected yet"
+	}
+	for _, sink := range server.sinks {
+		if stats := sink.Stats(); !stats.Healthy() {
+			return false, fmt.Sprintf("sink %s is failing: %s", stats.Name, stats.LastError)
+		}
+	}
+	return true, "ok"
+}
+
+// GetStatus current status of the agent
+func (server *StatusServer) GetStatus() Status {
+	server.lock.RLock()
+	defer server.lock.RUnlock()
+
+	status := Status{
+		Version: Version,
+		Uptime:  time.Since(server.start).Round(time.Second).String(),
+		Config:  server.config,
+	}
+	if !server.lastSample.IsZero() {
+		lastSample := server.lastSample
+		stats := server.stats
+		status.LastSample = &lastSample
+		status.Sample = &stats
+	}
+	for _, sink := range server.sinks {
+		status.Sinks = append(status.Sinks, sink.Stats())
+	}
+	for _, name := range sortedKeys(server.collectors) {
+		status.Collectors = append(status.Collectors, server.collectors[name])
+	}
+	return status
+}
+
+func (server *StatusServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !server.Healthy(time.Now()) {
+		http.Error(w, "no sample collected recently", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (server *StatusServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	ready, reason := server.Ready()
+	if !ready {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, reason)
+}
+
+func (server *StatusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, server.GetStatus())
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		fmt.Println("Error while writing the HTTP response", err)
+	}
+}
+
+func sortedKeys(m map[string]CollectorStatus) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,64 @@
+package batchinsights_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingSink struct {
+	batchinsights.ConsoleSink
+	stats batchinsights.SinkStats
+}
+
+func (sink *failingSink) Stats() batchinsights.SinkStats {
+	return sink.stats
+}
+
+func get(server *batchinsights.StatusServer, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder
+}
+
+func TestStatusServer(t *testing.T) {
+	config := batchinsights.Config{PoolID: "pool-1", NodeID: "node-1", InstrumentationKey: "secret"}
+	sink := &failingSink{stats: batchinsights.SinkStats{Name: "appInsights"}}
+	server := batchinsights.NewStatusServer(config, []batchinsights.Sink{sink}, time.Second, ioutil.Discard)
+
+	assert.Equal(t, http.StatusOK, get(server, "/healthz").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, get(server, "/readyz").Code)
+
+	server.Update(batchinsights.NodeStats{
+		CPUPercents: []float64{12},
+		Collectors:  []batchinsights.CollectorStatus{{Name: "cpu"}, {Name: "gpu", Error: "NVML not found"}},
+	}, time.Now())
+	assert.Equal(t, http.StatusOK, get(server, "/readyz").Code)
+
+	sink.stats.LastFailure = time.Now()
+	sink.stats.LastError = "Response: 500"
+	response := get(server, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Contains(t, response.Body.String(), "Response: 500")
+
+	var status batchinsights.Status
+	response = get(server, "/status")
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &status))
+	assert.Equal(t, batchinsights.Version, status.Version)
+	assert.Equal(t, "pool-1", status.Config.PoolID)
+	assert.NotContains(t, response.Body.String(), "secret")
+	assert.Equal(t, []float64{12}, status.Sample.CPUPercents)
+	assert.Contains(t, response.Body.String(), `"Error": "NVML not found"`)
+
+	assert.False(t, server.Healthy(time.Now().Add(time.Minute)))
+
+	// Replayed samples carry their recorded time
+	server.Update(batchinsights.NodeStats{}, time.Now().Add(-time.Hour))
+	assert.True(t, server.Healthy(time.Now()))
+}
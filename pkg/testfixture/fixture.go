@@ -0,0 +1,66 @@
+package testfixture
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fixture temporary file tree standing for procfs or sysfs in the tests, e.g. to change counters between two samples.
+// Trees which don't change are checked in testdata instead
+type Fixture struct {
+	t    *testing.T
+	Root string
+}
+
+// New Create an empty tree. Close must be deferred to remove it
+func New(t *testing.T) *Fixture {
+	root, err := ioutil.TempDir("", "batch-insights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Fixture{t: t, Root: root}
+}
+
+// Path absolute path of a file of the tree. e.g. proc/vmstat
+func (f *Fixture) Path(path string) string {
+	return filepath.Join(f.Root, path)
+}
+
+// Write create or replace a file, creating its directories. Returns the absolute path of the file
+func (f *Fixture) Write(path string, content string) string {
+	path = f.Path(path)
+	assert.Nil(f.t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(f.t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+// Copy write a file of the tree with the content of a checked-in file. Returns the content
+func (f *Fixture) Copy(path string, source string) string {
+	content, err := ioutil.ReadFile(source)
+	assert.Nil(f.t, err)
+	f.Write(path, string(content))
+	return string(content)
+}
+
+// Append add content at the end of an existing file
+func (f *Fixture) Append(path string, content string) {
+	file, err := os.OpenFile(f.Path(path), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(f.t, err)
+	defer file.Close()
+	_, err = file.WriteString(content)
+	assert.Nil(f.t, err)
+}
+
+// Remove delete a file or directory of the tree. e.g. a container or port which disappeared
+func (f *Fixture) Remove(path string) {
+	assert.Nil(f.t, os.RemoveAll(f.Path(path)))
+}
+
+// Close remove the tree
+func (f *Fixture) Close() {
+	os.RemoveAll(f.Root)
+}
@@ -0,0 +1,77 @@
+package batchinsights
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Application Insights SDK doesn't expose the outcome of its transmissions, only diagnostics messages
+var (
+	transmittingPattern = regexp.MustCompile(`^-+ Transmitting (\d+) items -+$`)
+	responsePattern     = regexp.MustCompile(`^Response: (\d+)$`)
+	itemsPattern        = regexp.MustCompile(`^Items accepted/received: (\d+)/(\d+)$`)
+)
+
+// TransmissionTracker count the telemetry items sent by the Application Insights SDK from its diagnostics messages
+type TransmissionTracker struct {
+	counters sinkCounters
+	inFlight uint64 // Items of the transmission in progress
+}
+
+// NewTransmissionTracker Create a new tracker reporting the stats under the given sink name
+func NewTransmissionTracker(name string) *TransmissionTracker {
+	tracker := TransmissionTracker{}
+	tracker.counters.stats.Name = name
+	return &tracker
+}
+
+// Stats delivery counters of the telemetry items
+func (tracker *TransmissionTracker) Stats() SinkStats {
+	return tracker.counters.get()
+}
+
+func (tracker *TransmissionTracker) queued(count uint64) {
+	tracker.counters.update(func(stats *SinkStats) {
+		stats.Queued += count
+	})
+}
+
+// Handle process an SDK diagnostics message. Transmissions are sequential so the messages aren't interleaved
+func (tracker *TransmissionTracker) Handle(message string) error {
+	message = strings.TrimSpace(message)
+	tracker.counters.update(func(stats *SinkStats) {
+		switch {
+		case transmittingPattern.MatchString(message):
+			tracker.inFlight, _ = strconv.ParseUint(transmittingPattern.FindStringSubmatch(message)[1], 10, 64)
+		case responsePattern.MatchString(message):
+			code, _ := strconv.Atoi(responsePattern.FindStringSubmatch(message)[1])
+			if code >= 200 && code < 300 {
+				// Partial successes are corrected when the items are reported
+				stats.Sent += tracker.inFlight
+				stats.LastSuccess = time.Now()
+			} else {
+				stats.Failed += tracker.inFlight
+				stats.fail(message)
+			}
+		case itemsPattern.MatchString(message):
+			match := itemsPattern.FindStringSubmatch(message)
+			accepted, _ := strconv.ParseUint(match[1], 10, 64)
+			received, _ := strconv.ParseUint(match[2], 10, 64)
+			if received > accepted && stats.Sent >= received-accepted {
+				stats.Sent -= received - accepted
+				stats.Failed += received - accepted
+				stats.LastError = "Some items were rejected: " + message
+			}
+		case strings.HasPrefix(message, "Failed to transmit telemetry"):
+			stats.Failed += tracker.inFlight
+			stats.fail(message)
+		case strings.HasPrefix(message, "Gave up transmitting payload"), strings.HasPrefix(message, "Cannot retry telemetry submission"),
+			strings.HasPrefix(message, "Refusing to retry telemetry submission"):
+			stats.Dropped += tracker.inFlight
+			stats.fail(message)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,50 @@
+package batchinsights_test
+
+import (
+	"testing"
+
+	"github.com/Azure/batch-insights/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransmissionTracker(t *testing.T) {
+	tracker := batchinsights.NewTransmissionTracker("appInsights")
+
+	messages := []string{
+		"--------- Transmitting 10 items ---------",
+		"Telemetry transmitted in 52ms",
+		"Response: 200",
+		"Items accepted/received: 10/10",
+		"--------- Transmitting 5 items ---------",
+		"Response: 206",
+		"Items accepted/received: 3/5",
+		"--------- Transmitting 2 items ---------",
+		"Failed to transmit telemetry: dial tcp: i/o timeout",
+		"Gave up transmitting payload; exhausted retries",
+		"--------- Transmitting 4 items ---------",
+		"Response: 500",
+	}
+	for _, message := range messages {
+		tracker.Handle(message)
+	}
+
+	stats := tracker.Stats()
+	assert.Equal(t, "appInsights", stats.Name)
+	assert.Equal(t, uint64(13), stats.Sent)
+	assert.Equal(t, uint64(8), stats.Failed)
+	assert.Equal(t, uint64(2), stats.Dropped)
+	assert.Equal(t, "Response: 500", stats.LastError)
+	assert.False(t, stats.LastSuccess.IsZero())
+}
+
+func TestAgentMonitor(t *testing.T) {
+	monitor := batchinsights.NewAgentMonitor()
+	sink := batchinsights.NewConsoleSink()
+
+	stats, err := monitor.GetStats([]batchinsights.Sink{sink})
+
+	assert.Nil(t, err)
+	assert.True(t, stats.RSS > 0)
+	assert.True(t, stats.Goroutines > 0)
+	assert.Equal(t, []batchinsights.SinkStats{{Name: "console"}}, stats.Sinks)
+}
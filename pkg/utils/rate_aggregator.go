@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"time"
+)
+
+// RateAggregator compute the per second rate of monotonic counters identified by a key
+type RateAggregator struct {
+	lastTimestamps map[string]time.Time
+	lastValues     map[string]uint64
+}
+
+// UpdateRate register the current value of the given counter and return its rate per second since the last update.
+// ok is false the first time a counter is seen or when the counter went backward(e.g. reset)
+func (aggregator *RateAggregator) UpdateRate(key string, value uint64, now time.Time) (rate float64, ok bool) {
+	if aggregator.lastValues == nil {
+		aggregator.lastTimestamps = make(map[string]time.Time)
+		aggregator.lastValues = make(map[string]uint64)
+	}
+
+	lastTimestamp, seen := aggregator.lastTimestamps[key]
+	lastValue := aggregator.lastValues[key]
+
+	aggregator.lastTimestamps[key] = now
+	aggregator.lastValues[key] = value
+
+	if !seen || value < lastValue {
+		return 0, false
+	}
+
+	delta := now.Sub(lastTimestamp).Seconds()
+	if delta <= 0 {
+		return 0, false
+	}
+	return float64(value-lastValue) / delta, true
+}
+
+// Prune forget the counters that were not updated since the given time(e.g. a device or container that disappeared)
+func (aggregator *RateAggregator) Prune(before time.Time) {
+	for key, timestamp := range aggregator.lastTimestamps {
+		if timestamp.Before(before) {
+			delete(aggregator.lastTimestamps, key)
+			delete(aggregator.lastValues, key)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/batch-insights/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateAggregator(t *testing.T) {
+	aggregator := utils.RateAggregator{}
+	start := time.Now()
+
+	_, ok := aggregator.UpdateRate("reads", 100, start)
+	assert.False(t, ok)
+
+	rate, ok := aggregator.UpdateRate("reads", 300, start.Add(2*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, float64(100), rate)
+
+	// Counter reset
+	_, ok = aggregator.UpdateRate("reads", 10, start.Add(4*time.Second))
+	assert.False(t, ok)
+
+	rate, ok = aggregator.UpdateRate("reads", 20, start.Add(5*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, float64(10), rate)
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// ReadString read a sysfs or procfs file holding a single value, without the surrounding whitespace
+func ReadString(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// ReadUint read a sysfs or procfs file holding a decimal integer
+func ReadUint(path string) (uint64, error) {
+	return ReadUintBase(path, 10)
+}
+
+// ReadUintBase read a sysfs or procfs file holding an integer in the given base. base 0 accepts the 0x prefix
+func ReadUintBase(path string, base int) (uint64, error) {
+	value, err := ReadString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(value, base, 64)
+}
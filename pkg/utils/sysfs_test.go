@@ -0,0 +1,31 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/Azure/batch-insights/pkg/testfixture"
+	"github.com/Azure/batch-insights/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSysfs(t *testing.T) {
+	f := testfixture.New(t)
+	defer f.Close()
+
+	value, err := utils.ReadString(f.Write("state", "4: ACTIVE\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "4: ACTIVE", value)
+
+	count, err := utils.ReadUint(f.Write("count", "42\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), count)
+
+	count, err = utils.ReadUintBase(f.Write("ioerr_cnt", "0x1f\n"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(31), count)
+
+	_, err = utils.ReadUint(f.Write("invalid", "max\n"))
+	assert.NotNil(t, err)
+	_, err = utils.ReadString(f.Path("missing"))
+	assert.NotNil(t, err)
+}